@@ -8,13 +8,14 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 )
 
 type CategoryClient interface {
 	CategoryList(token string) ([]*model.Category, error)
 	AddCategory(token, name string) (respCode int, err error)
 	UpdateCategory(token, id, name string) (respCode int, err error)
-	DeleteCategory(token, id string) (respCode int, err error)
+	DeleteCategory(token, id string, mode model.CategoryDeleteMode, reassignTo string) (respCode int, err error)
 }
 
 type categoryClient struct {
@@ -89,8 +90,8 @@ func (c *categoryClient) AddCategory(token, name string) (respCode int, err erro
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return -1, errors.New("status code not 200")
+	if resp.StatusCode != 201 {
+		return -1, errors.New("status code not 201")
 	}
 
 	return resp.StatusCode, nil
@@ -131,13 +132,20 @@ func (c *categoryClient) UpdateCategory(token, id, name string) (respCode int, e
 	return resp.StatusCode, nil
 }
 
-func (c *categoryClient) DeleteCategory(token, id string) (respCode int, err error) {
+// DeleteCategory deletes category id, handling its tasks according to mode.
+// reassignTo is only used with model.CategoryDeleteReassign.
+func (c *categoryClient) DeleteCategory(token, id string, mode model.CategoryDeleteMode, reassignTo string) (respCode int, err error) {
 	client, err := GetClientWithCookie(token)
 	if err != nil {
 		return -1, err
 	}
 
-	req, err := http.NewRequest("DELETE", config.SetUrl("/api/v1/Category/delete/"+id), nil)
+	query := url.Values{"mode": {string(mode)}}
+	if mode == model.CategoryDeleteReassign {
+		query.Set("reassign_to", reassignTo)
+	}
+
+	req, err := http.NewRequest("DELETE", config.SetUrl("/api/v1/category/delete/"+id+"?"+query.Encode()), nil)
 	if err != nil {
 		return -1, err
 	}
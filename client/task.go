@@ -74,7 +74,6 @@ func (t *taskClient) AddTask(token string, task model.Task) (respCode int, err e
 		"priority":    task.Priority,
 		"status":      task.Status,
 		"category_id": task.CategoryID,
-		"user_id":     task.UserID,
 	}
 
 	data, err := json.Marshal(datajson)
@@ -95,8 +94,8 @@ func (t *taskClient) AddTask(token string, task model.Task) (respCode int, err e
 
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return -1, errors.New("status code not 200")
+	if resp.StatusCode != 201 {
+		return -1, errors.New("status code not 201")
 	}
 
 	return resp.StatusCode, nil
@@ -114,7 +113,6 @@ func (t *taskClient) UpdateTask(token string, task model.Task) (respCode int, er
 		"priority":    task.Priority,
 		"status":      task.Status,
 		"category_id": task.CategoryID,
-		"user_id":     task.UserID,
 	}
 
 	data, err := json.Marshal(datajson)
@@ -11,10 +11,16 @@ import (
 )
 
 type UserClient interface {
-	Login(email, password string) (respCode int, err error)
+	Login(email, password, userAgent, clientIP string) (respCode int, tokens model.TokenPair, challenge string, err error)
+	LoginSecondFactor(challenge, code, userAgent, clientIP string) (respCode int, tokens model.TokenPair, err error)
 	Register(fullname, email, password string) (respCode int, err error)
+	ForgotPassword(email string) (respCode int, err error)
+	ResetPassword(token, password string) (respCode int, err error)
+	VerifyEmail(token string) (respCode int, err error)
+	ResendVerification(email string) (respCode int, err error)
 
 	GetUserTaskCategory(token string) (*[]model.UserTaskCategory, error)
+	GetOwnTaskCategory(token string) (*[]model.UserTaskCategory, error)
 }
 
 type userClient struct {
@@ -24,38 +30,74 @@ func NewUserClient() *userClient {
 	return &userClient{}
 }
 
-func (u *userClient) Login(email, password string) (respCode int, err error) {
-	datajson := map[string]string{
+// Login signs in through the API and returns the tokens it issued. For
+// accounts with two-factor login the status is 202 and challenge must be
+// passed to LoginSecondFactor together with a code instead.
+// userAgent and clientIP are forwarded so the session records the browser
+// that logged in rather than this client.
+func (u *userClient) Login(email, password, userAgent, clientIP string) (respCode int, tokens model.TokenPair, challenge string, err error) {
+	return postLogin("/api/v1/user/login", map[string]string{
 		"email":    email,
 		"password": password,
-	}
+	}, userAgent, clientIP)
+}
 
+// LoginSecondFactor finishes a login that Login answered with a challenge.
+func (u *userClient) LoginSecondFactor(challenge, code, userAgent, clientIP string) (respCode int, tokens model.TokenPair, err error) {
+	respCode, tokens, _, err = postLogin("/api/v1/user/login/2fa", map[string]string{
+		"challenge": challenge,
+		"code":      code,
+	}, userAgent, clientIP)
+	return respCode, tokens, err
+}
+
+func postLogin(url string, datajson map[string]string, userAgent, clientIP string) (int, model.TokenPair, string, error) {
 	data, err := json.Marshal(datajson)
 	if err != nil {
-		return -1, err
+		return -1, model.TokenPair{}, "", err
 	}
 
-	req, err := http.NewRequest("POST", config.SetUrl("/api/v1/user/login"), bytes.NewBuffer(data))
+	req, err := http.NewRequest("POST", config.SetUrl(url), bytes.NewBuffer(data))
 	if err != nil {
-		return -1, err
+		return -1, model.TokenPair{}, "", err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Forwarded-For", clientIP)
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 
 	if err != nil {
-		return -1, err
+		return -1, model.TokenPair{}, "", err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return resp.StatusCode, model.TokenPair{}, "", nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return -1, err
-	} else {
-		return resp.StatusCode, nil
+		return -1, model.TokenPair{}, "", err
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		var challenge model.TwoFactorChallenge
+		if err := json.Unmarshal(b, &challenge); err != nil {
+			return -1, model.TokenPair{}, "", err
+		}
+		return resp.StatusCode, model.TokenPair{}, challenge.Challenge, nil
 	}
+
+	var loginResponse model.LoginResponse
+	if err := json.Unmarshal(b, &loginResponse); err != nil {
+		return -1, model.TokenPair{}, "", err
+	}
+
+	return resp.StatusCode, loginResponse.TokenPair, "", nil
 }
 
 func (u *userClient) Register(fullname, email, password string) (respCode int, err error) {
@@ -93,13 +135,73 @@ func (u *userClient) Register(fullname, email, password string) (respCode int, e
 	}
 }
 
+func (u *userClient) ForgotPassword(email string) (respCode int, err error) {
+	return postJSON("/api/v1/user/password/forgot", map[string]string{
+		"email": email,
+	})
+}
+
+func (u *userClient) ResetPassword(token, password string) (respCode int, err error) {
+	return postJSON("/api/v1/user/password/reset", map[string]string{
+		"token":    token,
+		"password": password,
+	})
+}
+
+func (u *userClient) VerifyEmail(token string) (respCode int, err error) {
+	return postJSON("/api/v1/user/verify", map[string]string{
+		"token": token,
+	})
+}
+
+func (u *userClient) ResendVerification(email string) (respCode int, err error) {
+	return postJSON("/api/v1/user/verify/resend", map[string]string{
+		"email": email,
+	})
+}
+
+// postJSON posts datajson to the API without credentials and returns the
+// response status.
+func postJSON(url string, datajson map[string]string) (respCode int, err error) {
+	data, err := json.Marshal(datajson)
+	if err != nil {
+		return -1, err
+	}
+
+	req, err := http.NewRequest("POST", config.SetUrl(url), bytes.NewBuffer(data))
+	if err != nil {
+		return -1, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
+
+// GetUserTaskCategory lists the tasks of every user. Only admins may call it.
 func (u *userClient) GetUserTaskCategory(token string) (*[]model.UserTaskCategory, error) {
+	return u.getUserTaskCategory(token, "/api/v1/user/tasks")
+}
+
+// GetOwnTaskCategory lists the tasks of the logged-in user.
+func (u *userClient) GetOwnTaskCategory(token string) (*[]model.UserTaskCategory, error) {
+	return u.getUserTaskCategory(token, "/api/v1/user/tasks/me")
+}
+
+func (u *userClient) getUserTaskCategory(token, url string) (*[]model.UserTaskCategory, error) {
 	client, err := GetClientWithCookie(token)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", config.SetUrl("/api/v1/user/tasks"), nil)
+	req, err := http.NewRequest("GET", config.SetUrl(url), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"a21hc3NpZ25tZW50/config"
+	"a21hc3NpZ25tZW50/db/filebased"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runCommand runs one of the maintenance subcommands given on the command
+// line instead of the server.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "fsck":
+		return fsckCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %q, expected migrate, backup, restore or fsck", args[0])
+}
+
+// serverStorage reads the server's options, given on the command line in
+// place of a subcommand. -storage overrides STORAGE, so that
+// `--storage=memory` starts a throwaway instance.
+func serverStorage(args []string) (config.StorageConfig, error) {
+	cfg, err := config.Storage()
+	if err != nil {
+		return config.StorageConfig{}, err
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.StringVar(&cfg.Backend, "storage", cfg.Backend, `where to keep the data: "file", "sql" or "memory"`)
+	if err := flags.Parse(args); err != nil {
+		return config.StorageConfig{}, err
+	}
+	if flags.NArg() > 0 {
+		return config.StorageConfig{}, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+	return cfg, cfg.Validate()
+}
+
+// filePath returns the database file the server would open, DB_PATH or its
+// default, which the subcommands' -db flag defaults to.
+func filePath() (string, error) {
+	cfg, err := config.Storage()
+	if err != nil {
+		return "", err
+	}
+	return cfg.Path, nil
+}
+
+// migrateCommand brings the database up to the schema of this binary. The
+// server does the same on startup; with -dry-run the pending migrations are
+// tried and rolled back, so their result can be checked before an upgrade.
+func migrateCommand(args []string) error {
+	defaultPath, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	path := flags.String("db", defaultPath, "database file")
+	dryRun := flags.Bool("dry-run", false, "report the pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	applied, err := filebased.MigrateFile(*path, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "applied"
+	if *dryRun {
+		verb = "would apply"
+	}
+	if len(applied) == 0 {
+		fmt.Printf("%s is at schema version %d, nothing to do\n", *path, filebased.LatestSchemaVersion())
+		return nil
+	}
+	for _, m := range applied {
+		fmt.Printf("%s migration %d: %s\n", verb, m.Version, m.Description)
+	}
+	return nil
+}
+
+// backupCommand writes a snapshot of a database that no server has open; a
+// running server is backed up through /api/v1/admin/backup or BACKUP_DIR.
+func backupCommand(args []string) error {
+	defaultPath, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	path := flags.String("db", defaultPath, "database file")
+	out := flags.String("o", "-", "file to write the snapshot to, - for standard output")
+	compress := flags.Bool("gzip", false, "gzip the snapshot")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := filebased.OpenReadOnly(*path)
+	if err != nil {
+		return err
+	}
+	defer data.CloseDB()
+
+	if *out == "-" {
+		return data.Backup(os.Stdout, *compress)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := data.Backup(f, *compress); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	return f.Close()
+}
+
+// restoreCommand replaces a database with a snapshot written by backup. The
+// server has to be stopped while it runs.
+func restoreCommand(args []string) error {
+	defaultPath, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	path := flags.String("db", defaultPath, "database file to replace")
+	in := flags.String("i", "-", "snapshot to restore, - for standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	snapshot := os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		snapshot = f
+	}
+
+	if err := filebased.Restore(*path, snapshot); err != nil {
+		return err
+	}
+	fmt.Printf("%s restored, the previous file was kept as %s.before-restore\n", *path, *path)
+	return nil
+}
+
+// fsckCommand checks a database that no server has open and, with
+// -quarantine, moves the bad records aside. It fails when problems remain, so
+// it can guard scripts.
+func fsckCommand(args []string) error {
+	defaultPath, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	path := flags.String("db", defaultPath, "database file")
+	quarantine := flags.Bool("quarantine", false, "move bad records to the Quarantine bucket and rebuild the indexes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var data *filebased.Data
+	if *quarantine {
+		data, err = filebased.Open(*path)
+	} else {
+		data, err = filebased.OpenReadOnly(*path)
+	}
+	if err != nil {
+		return err
+	}
+	defer data.CloseDB()
+
+	report, err := data.CheckIntegrity(*quarantine)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range report.Problems {
+		state := ""
+		if p.Quarantined {
+			state = " (quarantined)"
+		}
+		fmt.Printf("%s %s: %s: %s%s\n", p.Bucket, p.Key, p.Kind, p.Detail, state)
+	}
+	fmt.Printf("%d records checked, %d problems\n", report.Records, len(report.Problems))
+	if report.IndexesRebuilt {
+		fmt.Println("indexes rebuilt")
+	}
+
+	if len(report.Problems) > 0 && !*quarantine {
+		return fmt.Errorf("%s has %d problems, run again with -quarantine to set them aside", *path, len(report.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package config
+
+import "os"
+
+// AdminConfig describes the administrator account created at startup.
+//
+// When ADMIN_EMAIL is set the server makes sure that user exists and has the
+// admin role. An existing account is promoted and keeps its password;
+// otherwise a new one is created with ADMIN_PASSWORD and ADMIN_FULLNAME.
+type AdminConfig struct {
+	Email    string
+	Password string
+	Fullname string
+}
+
+func Admin() AdminConfig {
+	cfg := AdminConfig{
+		Email:    os.Getenv("ADMIN_EMAIL"),
+		Password: os.Getenv("ADMIN_PASSWORD"),
+		Fullname: os.Getenv("ADMIN_FULLNAME"),
+	}
+	if cfg.Fullname == "" {
+		cfg.Fullname = "Administrator"
+	}
+	return cfg
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BackupConfig controls the scheduled local backups.
+//
+// When BACKUP_DIR is set a snapshot of the database is written there every
+// BACKUP_INTERVAL (24h by default) and only the newest BACKUP_KEEP (7 by
+// default) are kept. Snapshots are gzipped unless BACKUP_GZIP is "false".
+type BackupConfig struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int
+	Compress bool
+}
+
+func Backup() (BackupConfig, error) {
+	cfg := BackupConfig{
+		Dir:      os.Getenv("BACKUP_DIR"),
+		Keep:     7,
+		Compress: os.Getenv("BACKUP_GZIP") != "false",
+	}
+
+	interval, err := parseTTL("BACKUP_INTERVAL", os.Getenv("BACKUP_INTERVAL"), 24*time.Hour)
+	if err != nil {
+		return BackupConfig{}, err
+	}
+	cfg.Interval = interval
+
+	if v := os.Getenv("BACKUP_KEEP"); v != "" {
+		keep, err := strconv.Atoi(v)
+		if err != nil || keep < 1 {
+			return BackupConfig{}, fmt.Errorf("BACKUP_KEEP must be a positive number, got %q", v)
+		}
+		cfg.Keep = keep
+	}
+	return cfg, nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig holds the keys used to sign and verify session tokens.
+//
+// JWT_SIGNING_KEYS is a comma separated list of kid:secret pairs. Every listed
+// key is accepted for verification, so an old key can stay in the list while
+// its tokens expire. JWT_ACTIVE_KEY_ID selects the key new tokens are signed
+// with and defaults to the first entry. JWT_TOKEN_TTL (access tokens) and
+// JWT_REFRESH_TTL (refresh tokens) are Go duration strings.
+type JWTConfig struct {
+	Keys        map[string][]byte
+	ActiveKeyID string
+	TokenTTL    time.Duration
+	RefreshTTL  time.Duration
+}
+
+const (
+	defaultTokenTTL   = 15 * time.Minute
+	defaultRefreshTTL = 7 * 24 * time.Hour
+)
+
+var (
+	jwtConfig     JWTConfig
+	jwtConfigErr  error
+	jwtConfigOnce sync.Once
+)
+
+// JWT returns the process wide JWT configuration, loading it from the
+// environment on first use.
+func JWT() (JWTConfig, error) {
+	jwtConfigOnce.Do(func() {
+		jwtConfig, jwtConfigErr = LoadJWTConfig(
+			os.Getenv("JWT_SIGNING_KEYS"),
+			os.Getenv("JWT_ACTIVE_KEY_ID"),
+			os.Getenv("JWT_TOKEN_TTL"),
+		)
+		if jwtConfigErr == nil {
+			jwtConfig.RefreshTTL, jwtConfigErr = parseTTL("JWT_REFRESH_TTL", os.Getenv("JWT_REFRESH_TTL"), defaultRefreshTTL)
+		}
+	})
+	return jwtConfig, jwtConfigErr
+}
+
+func LoadJWTConfig(keys, activeKeyID, ttl string) (JWTConfig, error) {
+	cfg := JWTConfig{
+		Keys:        map[string][]byte{},
+		ActiveKeyID: activeKeyID,
+		TokenTTL:    defaultTokenTTL,
+		RefreshTTL:  defaultRefreshTTL,
+	}
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || secret == "" {
+			return JWTConfig{}, fmt.Errorf("invalid JWT signing key entry %q, expected kid:secret", pair)
+		}
+		if _, exists := cfg.Keys[kid]; exists {
+			return JWTConfig{}, fmt.Errorf("duplicate JWT key id %q", kid)
+		}
+		cfg.Keys[kid] = []byte(secret)
+		if cfg.ActiveKeyID == "" {
+			cfg.ActiveKeyID = kid
+		}
+	}
+
+	if len(cfg.Keys) == 0 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return JWTConfig{}, err
+		}
+		cfg.ActiveKeyID = "ephemeral-" + hex.EncodeToString(secret[:4])
+		cfg.Keys[cfg.ActiveKeyID] = secret
+		log.Println("JWT_SIGNING_KEYS is not set, using an ephemeral signing key; sessions will not survive a restart")
+	}
+
+	if _, ok := cfg.Keys[cfg.ActiveKeyID]; !ok {
+		return JWTConfig{}, fmt.Errorf("active JWT key id %q is not in JWT_SIGNING_KEYS", cfg.ActiveKeyID)
+	}
+
+	tokenTTL, err := parseTTL("JWT_TOKEN_TTL", ttl, defaultTokenTTL)
+	if err != nil {
+		return JWTConfig{}, err
+	}
+	cfg.TokenTTL = tokenTTL
+
+	return cfg, nil
+}
+
+func parseTTL(name, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return d, nil
+}
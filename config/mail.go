@@ -0,0 +1,35 @@
+package config
+
+import "os"
+
+// MailConfig selects how outgoing mail is delivered.
+//
+// When SMTP_HOST is set mail is sent through that server, authenticating with
+// SMTP_USERNAME and SMTP_PASSWORD if given. Otherwise messages are written to
+// MAIL_LOG_FILE, or to the standard logger when that is unset as well.
+type MailConfig struct {
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	LogFile      string
+}
+
+func Mail() MailConfig {
+	cfg := MailConfig{
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     os.Getenv("SMTP_PORT"),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		From:         os.Getenv("MAIL_FROM"),
+		LogFile:      os.Getenv("MAIL_LOG_FILE"),
+	}
+	if cfg.SMTPPort == "" {
+		cfg.SMTPPort = "25"
+	}
+	if cfg.From == "" {
+		cfg.From = "no-reply@localhost"
+	}
+	return cfg
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// OIDCConfig describes the OpenID Connect provider users may log in with.
+//
+// Single sign-on is turned on by setting OIDC_ISSUER; the provider's endpoints
+// are then discovered from its /.well-known/openid-configuration.
+// OIDC_CLIENT_SECRET may be left empty for providers that register the
+// application as a public client, since every login uses PKCE.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+func OIDC() OIDCConfig {
+	cfg := OIDCConfig{
+		Issuer:       strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = SetUrl("/api/v1/user/oidc/callback")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return cfg
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// TrustedProxies lists the addresses and CIDR ranges whose X-Forwarded-For
+// header is believed when working out a client's IP, taken from the
+// comma-separated TRUSTED_PROXIES. By default only loopback is trusted: that
+// covers the web client, which passes on the browser's address, and a
+// reverse proxy on the same host. "none" trusts nobody. A header from anyone
+// else is ignored, so it cannot be rotated to dodge the per-IP login lockout.
+func TrustedProxies() []string {
+	v := os.Getenv("TRUSTED_PROXIES")
+	if v == "" {
+		return []string{"127.0.0.1", "::1"}
+	}
+	if v == "none" {
+		return nil
+	}
+
+	var proxies []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
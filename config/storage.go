@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"a21hc3NpZ25tZW50/model"
+)
+
+const (
+	StorageFile   = "file"
+	StorageSQL    = "sql"
+	StorageMemory = "memory"
+)
+
+// StorageConfig selects where the server keeps its data.
+//
+// STORAGE is "file" (the default), which keeps everything in the bbolt file
+// DB_PATH (file.db by default), or "sql", which uses the database/sql driver
+// DATABASE_DRIVER ("postgres" by default). The SQL database is DATABASE_URL
+// when set; otherwise a PostgreSQL connection is made from DB_HOST (localhost),
+// DB_PORT (5432), DB_USER, DB_PASSWORD, DB_NAME and DB_SCHEMA. "memory" keeps
+// everything in the process and loses it on exit.
+type StorageConfig struct {
+	Backend    string
+	Path       string
+	Driver     string
+	URL        string
+	Credential model.Credential
+}
+
+func Storage() (StorageConfig, error) {
+	cfg := StorageConfig{
+		Backend: os.Getenv("STORAGE"),
+		Path:    os.Getenv("DB_PATH"),
+		Driver:  os.Getenv("DATABASE_DRIVER"),
+		URL:     os.Getenv("DATABASE_URL"),
+		Credential: model.Credential{
+			Host:         os.Getenv("DB_HOST"),
+			Username:     os.Getenv("DB_USER"),
+			Password:     os.Getenv("DB_PASSWORD"),
+			DatabaseName: os.Getenv("DB_NAME"),
+			Port:         5432,
+			Schema:       os.Getenv("DB_SCHEMA"),
+		},
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = StorageFile
+	}
+	if cfg.Path == "" {
+		cfg.Path = "file.db"
+	}
+	if cfg.Driver == "" {
+		cfg.Driver = "postgres"
+	}
+	if cfg.Credential.Host == "" {
+		cfg.Credential.Host = "localhost"
+	}
+
+	if v := os.Getenv("DB_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 {
+			return StorageConfig{}, fmt.Errorf("DB_PORT must be a port number, got %q", v)
+		}
+		cfg.Credential.Port = port
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return StorageConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Validate checks that Backend names a storage the server knows, so a bad
+// -storage flag is reported the same way as a bad STORAGE variable.
+func (cfg StorageConfig) Validate() error {
+	switch cfg.Backend {
+	case StorageFile, StorageSQL, StorageMemory:
+		return nil
+	default:
+		return fmt.Errorf("STORAGE must be %q, %q or %q, got %q", StorageFile, StorageSQL, StorageMemory, cfg.Backend)
+	}
+}
@@ -0,0 +1,164 @@
+package filebased
+
+import (
+	"encoding/json"
+
+	"a21hc3NpZ25tZW50/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// ExportUserData reads user userID, their tasks and the categories those
+// tasks are in, all from the same transaction.
+func (data *Data) ExportUserData(userID int) (model.User, []model.Category, []model.Task, error) {
+	var (
+		user       model.User
+		categories []model.Category
+		tasks      []model.Task
+	)
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte("Users")).Get(itob(userID))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		if err := json.Unmarshal(v, &user); err != nil {
+			return err
+		}
+
+		seen := map[int]bool{}
+		categoriesBucket := tx.Bucket([]byte("Categories"))
+		return tx.Bucket([]byte("Tasks")).ForEach(func(k, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil || task.UserID != userID {
+				return nil
+			}
+			tasks = append(tasks, task)
+
+			if seen[task.CategoryID] {
+				return nil
+			}
+			seen[task.CategoryID] = true
+			var category model.Category
+			if err := json.Unmarshal(categoriesBucket.Get(itob(task.CategoryID)), &category); err != nil {
+				return nil // Tasks from before categories were checked may point nowhere
+			}
+			categories = append(categories, category)
+			return nil
+		})
+	})
+	if err != nil {
+		return model.User{}, nil, nil, err
+	}
+	return user, categories, tasks, nil
+}
+
+// ImportUserData adds the categories and tasks of archive to user userID in a
+// single transaction, so a failed import changes nothing. Archived
+// categories are matched to existing ones by name, and created under a new
+// ID otherwise; tasks always get new IDs. With replace set the user's tasks
+// are deleted first and their full name is taken from the archive. The
+// archive must have been validated: every task's category must be in it.
+func (data *Data) ImportUserData(userID int, archive model.Archive, replace bool) (model.ImportResult, error) {
+	var result model.ImportResult
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("Users"))
+		v := usersBucket.Get(itob(userID))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+
+		if replace {
+			var user model.User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			if archive.Profile.Fullname != "" && archive.Profile.Fullname != user.Fullname {
+				user.Fullname = archive.Profile.Fullname
+				userJSON, err := json.Marshal(user)
+				if err != nil {
+					return err
+				}
+				if err := usersBucket.Put(itob(userID), userJSON); err != nil {
+					return err
+				}
+			}
+
+			var tasks []model.Task
+			err := tx.Bucket([]byte("Tasks")).ForEach(func(k, v []byte) error {
+				var t model.Task
+				if json.Unmarshal(v, &t) == nil && t.UserID == userID {
+					tasks = append(tasks, t)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, t := range tasks {
+				if err := deleteTask(tx, t); err != nil {
+					return err
+				}
+			}
+			result.TasksRemoved = len(tasks)
+		}
+
+		categoriesBucket := tx.Bucket([]byte("Categories"))
+		byName := map[string]int{}
+		err := categoriesBucket.ForEach(func(k, v []byte) error {
+			var category model.Category
+			if err := json.Unmarshal(v, &category); err == nil {
+				if _, ok := byName[category.Name]; !ok {
+					byName[category.Name] = category.ID
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		categoryIDs := map[int]int{}
+		for _, category := range archive.Categories {
+			if id, ok := byName[category.Name]; ok {
+				categoryIDs[category.ID] = id
+				result.CategoriesMatched++
+				continue
+			}
+
+			id, err := allocateID(categoriesBucket, 0)
+			if err != nil {
+				return err
+			}
+			categoryJSON, err := json.Marshal(model.Category{ID: id, Name: category.Name, UserID: userID})
+			if err != nil {
+				return err
+			}
+			if err := categoriesBucket.Put(itob(id), categoryJSON); err != nil {
+				return err
+			}
+			byName[category.Name] = id
+			categoryIDs[category.ID] = id
+			result.CategoriesCreated++
+		}
+
+		tasksBucket := tx.Bucket([]byte("Tasks"))
+		for _, task := range archive.Tasks {
+			id, err := allocateID(tasksBucket, 0)
+			if err != nil {
+				return err
+			}
+			task.ID = id
+			task.UserID = userID
+			task.CategoryID = categoryIDs[task.CategoryID]
+			if err := putTask(tx, task); err != nil {
+				return err
+			}
+			result.TasksImported++
+		}
+		return nil
+	})
+	if err != nil {
+		return model.ImportResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,209 @@
+package filebased
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrInvalidSnapshot is returned by Restore for a file that is not a usable
+// backup of this database.
+var ErrInvalidSnapshot = errors.New("invalid database snapshot")
+
+// OpenReadOnly opens the database at path for reading only, without creating
+// buckets or running migrations. It waits for the lock like Open, so it fails
+// while a server has the database open.
+func OpenReadOnly(path string) (*Data, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 2 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	return &Data{DB: db}, nil
+}
+
+// Backup writes a consistent snapshot of the whole database to w, gzipped if
+// compress is set. It runs in a read transaction, so the server keeps
+// serving requests, writes included, while it streams.
+func (data *Data) Backup(w io.Writer, compress bool) error {
+	return data.DB.View(func(tx *bbolt.Tx) error {
+		if !compress {
+			_, err := tx.WriteTo(w)
+			return err
+		}
+
+		zw := gzip.NewWriter(w)
+		if _, err := tx.WriteTo(zw); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+}
+
+// backupLayout is the time format in the names of scheduled backups, which
+// sorts the same way as the times themselves.
+const backupLayout = "20060102T150405.000Z"
+
+// BackupToDir writes a snapshot to a new file in dir and then removes all but
+// the keep newest backups there. keep below one keeps every backup. The
+// snapshot is written under a temporary name and renamed once complete, so a
+// failed backup never looks like a good one.
+func (data *Data) BackupToDir(dir string, keep int, compress bool) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	name := "backup-" + time.Now().UTC().Format(backupLayout) + ".db"
+	if compress {
+		name += ".gz"
+	}
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := data.Backup(tmp, compress); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, pruneBackups(dir, keep)
+}
+
+func pruneBackups(dir string, keep int) error {
+	if keep < 1 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "backup-") && (strings.HasSuffix(name, ".db") || strings.HasSuffix(name, ".db.gz")) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the database file at path with the snapshot read from r,
+// which may be gzipped. The snapshot is written next to path and checked
+// with ValidateSnapshot before anything is replaced. The database must not be
+// open, by the server or anything else; the file it replaces is kept as
+// path + ".before-restore".
+func Restore(path string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := ValidateSnapshot(tmp.Name()); err != nil {
+		return err
+	}
+
+	// Taking the database's lock makes sure no server is using it. It is
+	// held until the new file is in place.
+	if _, err := os.Stat(path); err == nil {
+		current, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+		if err != nil {
+			return fmt.Errorf("database %s is in use, stop the server first: %v", path, err)
+		}
+		defer current.Close()
+
+		if err := os.Rename(path, path+".before-restore"); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ValidateSnapshot checks that the file at path is an intact bbolt database
+// holding this application's data, at a schema version this binary can run.
+func ValidateSnapshot(path string) error {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		// The channel has to be drained for the check to finish.
+		var checkErr error
+		for err := range tx.Check() {
+			if checkErr == nil {
+				checkErr = err
+			}
+		}
+		if checkErr != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSnapshot, checkErr)
+		}
+		for _, name := range []string{"Users", "Tasks", "Categories", "Sessions"} {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("%w: no %s bucket", ErrInvalidSnapshot, name)
+			}
+		}
+		if version := schemaVersion(tx); version > LatestSchemaVersion() {
+			return fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+		}
+		return nil
+	})
+}
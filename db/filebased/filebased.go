@@ -1,10 +1,12 @@
 package filebased
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"a21hc3NpZ25tZW50/model"
@@ -16,79 +18,306 @@ type Data struct {
 	DB *bbolt.DB
 }
 
+// DefaultPath is the database file the server uses.
+const DefaultPath = "file.db"
+
 func InitDB() (*Data, error) {
-	db, err := bbolt.Open("file.db", 0600, &bbolt.Options{Timeout: 2 * time.Second})
+	return Open(DefaultPath)
+}
+
+// Open opens the database at path, creating it and any missing buckets, and
+// applies the pending schema migrations. A database written by a newer
+// binary is refused with ErrSchemaTooNew.
+func Open(path string) (*Data, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 2 * time.Second})
 	if err != nil {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
 
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("Tasks"))
-		if err != nil {
-			return fmt.Errorf("create tasks bucket: %v", err)
+	if _, err := Migrate(db, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Data{DB: db}, nil
+}
+
+// ensureBuckets creates any missing bucket. Indexes are built when their
+// bucket is missing, which also covers databases from before they existed.
+func ensureBuckets(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists([]byte("Tasks"))
+	if err != nil {
+		return fmt.Errorf("create tasks bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("Categories"))
+	if err != nil {
+		return fmt.Errorf("create categories bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("Users"))
+	if err != nil {
+		return fmt.Errorf("create users bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("Sessions"))
+	if err != nil {
+		return fmt.Errorf("create sessions bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("AccessTokens"))
+	if err != nil {
+		return fmt.Errorf("create access tokens bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("LoginAttempts"))
+	if err != nil {
+		return fmt.Errorf("create login attempts bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte("PasswordResets"))
+	if err != nil {
+		return fmt.Errorf("create password resets bucket: %v", err)
+	}
+	_, err = tx.CreateBucketIfNotExists([]byte(metaBucket))
+	if err != nil {
+		return fmt.Errorf("create meta bucket: %v", err)
+	}
+
+	for _, name := range indexBuckets {
+		if tx.Bucket([]byte(name)) == nil {
+			if err := rebuildIndexes(tx); err != nil {
+				return fmt.Errorf("build indexes: %v", err)
+			}
+			break
 		}
-		_, err = tx.CreateBucketIfNotExists([]byte("Categories"))
+	}
+	return nil
+}
+
+// StoreTask inserts a new task. When task.ID is zero the next value of the
+// Tasks bucket sequence is assigned; a client-supplied ID is accepted only if
+// it is not taken yet. The task's category must exist, otherwise
+// model.ErrCategoryNotFound is returned.
+func (data *Data) StoreTask(task model.Task) (model.Task, error) {
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Tasks"))
+		id, err := allocateID(b, task.ID)
 		if err != nil {
-			return fmt.Errorf("create categories bucket: %v", err)
+			return err
+		}
+		task.ID = id
+
+		if !categoryExists(tx, task.CategoryID) {
+			return model.ErrCategoryNotFound
 		}
-		_, err = tx.CreateBucketIfNotExists([]byte("Users"))
+		return putTask(tx, task)
+	})
+	if err != nil {
+		return model.Task{}, err
+	}
+	return task, nil
+}
+
+// StoreCategory inserts a new category, allocating its ID the same way as
+// StoreTask.
+func (data *Data) StoreCategory(category model.Category) (model.Category, error) {
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Categories"))
+		id, err := allocateID(b, category.ID)
 		if err != nil {
-			return fmt.Errorf("create users bucket: %v", err)
+			return err
 		}
-		_, err = tx.CreateBucketIfNotExists([]byte("Sessions"))
+		category.ID = id
+
+		categoryJSON, err := json.Marshal(category)
 		if err != nil {
-			return fmt.Errorf("create sessions bucket: %v", err)
+			return err
 		}
-		return nil
+		return b.Put(itob(category.ID), categoryJSON)
 	})
 	if err != nil {
-		return nil, err
+		return model.Category{}, err
 	}
+	return category, nil
+}
 
-	return &Data{DB: db}, nil
+// allocateID returns the key a new record should be stored under. A zero
+// requested ID takes the bucket's next sequence value; otherwise the requested
+// ID is used if free and the sequence is moved past it so later allocations
+// cannot collide. Requested IDs outside 1..model.MaxID are refused.
+func allocateID(b *bbolt.Bucket, requested int) (int, error) {
+	if requested == 0 {
+		// Records written before the sequence was kept may sit ahead of
+		// it; skip them rather than overwrite one.
+		for {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return 0, err
+			}
+			if seq > model.MaxID {
+				return 0, model.ErrIDsExhausted
+			}
+			if b.Get(itob(int(seq))) == nil {
+				return int(seq), nil
+			}
+		}
+	}
+
+	if requested < 0 || requested > model.MaxID {
+		return 0, model.ErrInvalidID
+	}
+	if b.Get(itob(requested)) != nil {
+		return 0, model.ErrRecordExists
+	}
+	if uint64(requested) > b.Sequence() {
+		if err := b.SetSequence(uint64(requested)); err != nil {
+			return 0, err
+		}
+	}
+	return requested, nil
+}
+
+// UpdateTask replaces the task stored under id. The stored task must belong to
+// task.UserID, otherwise model.ErrRecordNotFound is returned, and the new
+// category must exist, otherwise model.ErrCategoryNotFound is returned.
+func (data *Data) UpdateTask(id int, task model.Task) error {
+	task.ID = id
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		stored, err := checkTaskOwner(tx.Bucket([]byte("Tasks")).Get(itob(id)), task.UserID)
+		if err != nil {
+			return err
+		}
+		if !categoryExists(tx, task.CategoryID) {
+			return model.ErrCategoryNotFound
+		}
+		if err := unindexTask(tx, stored); err != nil {
+			return err
+		}
+		return putTask(tx, task)
+	})
 }
 
-func (data *Data) StoreTask(task model.Task) error {
+// putTask writes task and its category index entry.
+func putTask(tx *bbolt.Tx, task model.Task) error {
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
-	return data.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("Tasks"))
-		return b.Put([]byte(fmt.Sprintf("%d", task.ID)), taskJSON)
-	})
+	if err := tx.Bucket([]byte("Tasks")).Put(itob(task.ID), taskJSON); err != nil {
+		return err
+	}
+	return indexTask(tx, task)
 }
 
-func (data *Data) StoreCategory(category model.Category) error {
-	categoryJSON, err := json.Marshal(category)
-	if err != nil {
+// deleteTask removes task and its category index entry.
+func deleteTask(tx *bbolt.Tx, task model.Task) error {
+	if err := tx.Bucket([]byte("Tasks")).Delete(itob(task.ID)); err != nil {
 		return err
 	}
-	return data.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("Categories"))
-		return b.Put([]byte(fmt.Sprintf("%d", category.ID)), categoryJSON)
-	})
+	return unindexTask(tx, task)
 }
 
-func (data *Data) UpdateTask(id int, task model.Task) error {
-	return data.StoreTask(task) // Reuse StoreTask as it will replace the existing entry
+func categoryExists(tx *bbolt.Tx, id int) bool {
+	return tx.Bucket([]byte("Categories")).Get(itob(id)) != nil
 }
 
+// UpdateCategory renames the category stored under id; its owner is kept. An
+// unknown id yields model.ErrRecordNotFound; categories are only created by
+// StoreCategory, so that every ID comes from the bucket sequence.
 func (data *Data) UpdateCategory(id int, category model.Category) error {
-	return data.StoreCategory(category) // Reuse StoreCategory as it will replace the existing entry
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		categoriesBucket := tx.Bucket([]byte("Categories"))
+		v := categoriesBucket.Get(itob(id))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+
+		var stored model.Category
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		stored.Name = category.Name
+		categoryJSON, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		return categoriesBucket.Put(itob(id), categoryJSON)
+	})
 }
 
-func (data *Data) DeleteTask(id int) error {
+// DeleteTask removes the task stored under id if it belongs to userID.
+func (data *Data) DeleteTask(userID, id int) error {
 	return data.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("Tasks"))
-		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+		task, err := checkTaskOwner(tx.Bucket([]byte("Tasks")).Get(itob(id)), userID)
+		if err != nil {
+			return err
+		}
+		return deleteTask(tx, task)
 	})
 }
 
-func (data *Data) DeleteCategory(id int) error {
+// checkTaskOwner decodes the raw task value. It reports
+// model.ErrRecordNotFound when the value is missing or owned by another user,
+// so callers cannot probe for foreign IDs.
+func checkTaskOwner(v []byte, userID int) (model.Task, error) {
+	if v == nil {
+		return model.Task{}, model.ErrRecordNotFound
+	}
+	var task model.Task
+	if err := json.Unmarshal(v, &task); err != nil {
+		return model.Task{}, err
+	}
+	if task.UserID != userID {
+		return model.Task{}, model.ErrRecordNotFound
+	}
+	return task, nil
+}
+
+// DeleteCategory removes category id. Its tasks are handled according to
+// mode: CategoryDeleteRestrict fails with model.ErrCategoryInUse if there are
+// any, CategoryDeleteCascade deletes them and CategoryDeleteReassign moves them
+// to category reassignTo, which must exist. An unknown id yields
+// model.ErrRecordNotFound.
+func (data *Data) DeleteCategory(id int, mode model.CategoryDeleteMode, reassignTo int) error {
 	return data.DB.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("Categories"))
-		return b.Delete([]byte(fmt.Sprintf("%d", id)))
+		if !categoryExists(tx, id) {
+			return model.ErrRecordNotFound
+		}
+
+		tasksBucket := tx.Bucket([]byte("Tasks"))
+		keys := taskKeysByCategory(tx, id)
+		switch mode {
+		case model.CategoryDeleteRestrict:
+			if len(keys) > 0 {
+				return model.ErrCategoryInUse
+			}
+		case model.CategoryDeleteCascade:
+			for _, k := range keys {
+				var task model.Task
+				if err := json.Unmarshal(tasksBucket.Get(k), &task); err != nil {
+					return err
+				}
+				if err := deleteTask(tx, task); err != nil {
+					return err
+				}
+			}
+		case model.CategoryDeleteReassign:
+			if reassignTo == id || !categoryExists(tx, reassignTo) {
+				return model.ErrCategoryNotFound
+			}
+			for _, k := range keys {
+				var task model.Task
+				if err := json.Unmarshal(tasksBucket.Get(k), &task); err != nil {
+					return err
+				}
+				if err := unindexTask(tx, task); err != nil {
+					return err
+				}
+				task.CategoryID = reassignTo
+				if err := putTask(tx, task); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unknown category delete mode %q", mode)
+		}
+
+		return tx.Bucket([]byte("Categories")).Delete(itob(id))
 	})
 }
 
@@ -96,9 +325,9 @@ func (data *Data) GetTaskByID(id int) (*model.Task, error) {
 	var task model.Task
 	err := data.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Tasks"))
-		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		v := b.Get(itob(id))
 		if v == nil {
-			return fmt.Errorf("record not found")
+			return model.ErrRecordNotFound
 		}
 		return json.Unmarshal(v, &task)
 	})
@@ -112,9 +341,9 @@ func (data *Data) GetCategoryByID(id int) (*model.Category, error) {
 	var category model.Category
 	err := data.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Categories"))
-		v := b.Get([]byte(fmt.Sprintf("%d", id)))
+		v := b.Get(itob(id))
 		if v == nil {
-			return fmt.Errorf("record not found")
+			return model.ErrRecordNotFound
 		}
 		return json.Unmarshal(v, &category)
 	})
@@ -144,6 +373,28 @@ func (data *Data) GetTasks() ([]model.Task, error) {
 	return tasks, nil
 }
 
+func (data *Data) GetTasksByUser(userID int) ([]model.Task, error) {
+	var tasks []model.Task
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Tasks"))
+		return b.ForEach(func(k, v []byte) error {
+			var task model.Task
+			if err := json.Unmarshal(v, &task); err != nil {
+				log.Println("Error unmarshaling task:", err)
+				return nil // Continue despite error
+			}
+			if task.UserID == userID {
+				tasks = append(tasks, task)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tasks: %v", err)
+	}
+	return tasks, nil
+}
+
 func (data *Data) GetCategories() ([]model.Category, error) {
 	var categories []model.Category
 	err := data.DB.View(func(tx *bbolt.Tx) error {
@@ -164,19 +415,26 @@ func (data *Data) GetCategories() ([]model.Category, error) {
 	return categories, nil
 }
 
+// Reset deletes every record, index and sequence, leaving an empty database
+// at the current schema version.
 func (data *Data) Reset() error {
 	return data.DB.Update(func(tx *bbolt.Tx) error {
-		if err := tx.DeleteBucket([]byte("Tasks")); err != nil {
-			return err
-		}
-		if err := tx.DeleteBucket([]byte("Categories")); err != nil {
+		var names [][]byte
+		err := tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if string(name) != metaBucket {
+				names = append(names, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
 			return err
 		}
-		if err := tx.DeleteBucket([]byte("Users")); err != nil {
-			return err
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
 		}
-
-		return nil
+		return ensureBuckets(tx)
 	})
 }
 
@@ -184,7 +442,7 @@ func (data *Data) CloseDB() error {
 	return data.DB.Close()
 }
 
-func (data *Data) GetTaskListByCategory(categoryID int) ([]model.TaskCategory, error) {
+func (data *Data) GetTaskListByCategory(userID, categoryID int) ([]model.TaskCategory, error) {
 	var taskCategories []model.TaskCategory
 	category, err := data.GetCategoryByID(categoryID)
 	if err != nil {
@@ -193,24 +451,21 @@ func (data *Data) GetTaskListByCategory(categoryID int) ([]model.TaskCategory, e
 
 	err = data.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Tasks"))
-		if b == nil {
-			return fmt.Errorf("tasks bucket not found")
-		}
-		return b.ForEach(func(k, v []byte) error {
+		for _, k := range taskKeysByCategory(tx, categoryID) {
 			var task model.Task
-			if err := json.Unmarshal(v, &task); err != nil {
+			if err := json.Unmarshal(b.Get(k), &task); err != nil {
 				log.Printf("Error unmarshaling task: %v", err)
-				return nil // Continue processing next item in case of error
+				continue // Continue processing next item in case of error
 			}
-			if task.CategoryID == categoryID {
+			if task.UserID == userID {
 				taskCategories = append(taskCategories, model.TaskCategory{
 					ID:       task.ID,
 					Title:    task.Title,
 					Category: category.Name,
 				})
 			}
-			return nil
-		})
+		}
+		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("error fetching tasks for category %d: %v", categoryID, err)
@@ -221,40 +476,29 @@ func (data *Data) GetTaskListByCategory(categoryID int) ([]model.TaskCategory, e
 	return taskCategories, nil
 }
 
+// GetUserByEmail looks the user up through the email index, ignoring case.
+// An unknown email yields a zero User and no error.
 func (data *Data) GetUserByEmail(email string) (model.User, error) {
 	var user model.User
-	found := false // Flag to check if the user is found
-
 	err := data.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("Users"))
-		if b == nil {
-			return fmt.Errorf("users bucket not found")
+		id := tx.Bucket([]byte(usersByEmailBucket)).Get(emailKey(email))
+		if id == nil {
+			return nil
 		}
-
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var u model.User
-			if err := json.Unmarshal(v, &u); err != nil {
-				continue // Skip on unmarshal error
-			}
-			if u.Email == email {
-				user = u
-				found = true
-				break // Stop the loop once the user is found
-			}
+		v := tx.Bucket([]byte("Users")).Get(id)
+		if v == nil {
+			return nil
 		}
-		return nil // Return nil error from the View transaction
+		return json.Unmarshal(v, &user)
 	})
-
 	if err != nil {
-		return model.User{}, err // Return the error if the transaction failed
-	}
-	if !found {
-		return model.User{}, nil // Return an empty User struct and nil error if not found
+		return model.User{}, err
 	}
-	return user, nil // Return the found user and nil error
+	return user, nil
 }
 
+// CreateUser stores user under the next ID of the Users sequence, so the ID
+// of a deleted user is never handed out again.
 func (data *Data) CreateUser(user model.User) (model.User, error) {
 	err := data.DB.Update(func(tx *bbolt.Tx) error {
 		usersBucket := tx.Bucket([]byte("Users"))
@@ -262,30 +506,22 @@ func (data *Data) CreateUser(user model.User) (model.User, error) {
 			return fmt.Errorf("users bucket not found")
 		}
 
-		// Find the highest existing ID
-		maxID := 0
-		err := usersBucket.ForEach(func(k, v []byte) error {
-			id := btoi(k)
-			if id > maxID {
-				maxID = id
-			}
-			return nil
-		})
+		id, err := allocateID(usersBucket, 0)
 		if err != nil {
-			return fmt.Errorf("error reading user IDs: %v", err)
+			return err
 		}
-
-		// Set the new user's ID to maxID + 1
-		newUserID := maxID + 1
-		user.ID = newUserID // Assuming User.ID is of type int
+		user.ID = id
 
 		userJSON, err := json.Marshal(user)
 		if err != nil {
 			return fmt.Errorf("error marshaling user: %v", err)
 		}
 
-		// Store the new user with the new ID
-		return usersBucket.Put(itob(newUserID), userJSON)
+		if err := indexUser(tx, user); err != nil {
+			return err
+		}
+
+		return usersBucket.Put(itob(user.ID), userJSON)
 	})
 	if err != nil {
 		return model.User{}, err
@@ -293,22 +529,201 @@ func (data *Data) CreateUser(user model.User) (model.User, error) {
 	return user, nil
 }
 
-// itob converts an integer to a byte slice
-func itob(v int) []byte {
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, uint64(v))
-	return b
+// GetUserByID returns the user stored under id, or model.ErrRecordNotFound.
+func (data *Data) GetUserByID(id int) (model.User, error) {
+	var user model.User
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("Users"))
+		if usersBucket == nil {
+			return fmt.Errorf("users bucket not found")
+		}
+		v := usersBucket.Get(itob(id))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		return json.Unmarshal(v, &user)
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// UpdateUser replaces the stored record of an existing user.
+func (data *Data) UpdateUser(user model.User) error {
+	userJSON, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("error marshaling user: %v", err)
+	}
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("Users"))
+		if usersBucket == nil {
+			return fmt.Errorf("users bucket not found")
+		}
+		v := usersBucket.Get(itob(user.ID))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+
+		var current model.User
+		if err := json.Unmarshal(v, &current); err != nil {
+			return err
+		}
+		if !strings.EqualFold(current.Email, user.Email) {
+			if err := unindexUser(tx, current); err != nil {
+				return err
+			}
+		}
+		if err := indexUser(tx, user); err != nil {
+			return err
+		}
+
+		return usersBucket.Put(itob(user.ID), userJSON)
+	})
+}
+
+// DeleteUser removes user id together with everything that belongs to it:
+// tasks, sessions, access tokens, password resets, the login attempts kept
+// under "email:<address>" and "user:<id>", and the categories it created. A
+// category that tasks of other users are still in is kept without an owner
+// instead. It all happens in one transaction, so a failure leaves the user
+// intact.
+func (data *Data) DeleteUser(id int) error {
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		usersBucket := tx.Bucket([]byte("Users"))
+		v := usersBucket.Get(itob(id))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		var user model.User
+		if err := json.Unmarshal(v, &user); err != nil {
+			return err
+		}
+		if err := usersBucket.Delete(itob(id)); err != nil {
+			return err
+		}
+		if err := unindexUser(tx, user); err != nil {
+			return err
+		}
+
+		var tasks []model.Task
+		err := tx.Bucket([]byte("Tasks")).ForEach(func(k, v []byte) error {
+			var t model.Task
+			if json.Unmarshal(v, &t) == nil && t.UserID == id {
+				tasks = append(tasks, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			if err := deleteTask(tx, t); err != nil {
+				return err
+			}
+		}
+		if err := deleteOwnedCategories(tx, id); err != nil {
+			return err
+		}
+		if err := deleteSessionsByEmail(tx, user.Email); err != nil {
+			return err
+		}
+		err = deleteWhere(tx.Bucket([]byte("AccessTokens")), func(v []byte) bool {
+			var pat model.PersonalAccessToken
+			return json.Unmarshal(v, &pat) == nil && pat.UserID == id
+		})
+		if err != nil {
+			return err
+		}
+		err = deleteWhere(tx.Bucket([]byte("PasswordResets")), func(v []byte) bool {
+			var r model.PasswordReset
+			return json.Unmarshal(v, &r) == nil && r.UserID == id
+		})
+		if err != nil {
+			return err
+		}
+
+		attemptsBucket := tx.Bucket([]byte("LoginAttempts"))
+		for _, key := range []string{"email:" + strings.ToLower(user.Email), "user:" + strconv.Itoa(id)} {
+			if err := attemptsBucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteOwnedCategories removes the categories userID created. The user's
+// own tasks must be gone already; a category still holding tasks of others
+// loses its owner instead.
+func deleteOwnedCategories(tx *bbolt.Tx, userID int) error {
+	categoriesBucket := tx.Bucket([]byte("Categories"))
+
+	var owned []model.Category
+	err := categoriesBucket.ForEach(func(k, v []byte) error {
+		var category model.Category
+		if json.Unmarshal(v, &category) == nil && category.UserID == userID {
+			owned = append(owned, category)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, category := range owned {
+		if len(taskKeysByCategory(tx, category.ID)) > 0 {
+			category.UserID = 0
+			categoryJSON, err := json.Marshal(category)
+			if err != nil {
+				return err
+			}
+			if err := categoriesBucket.Put(itob(category.ID), categoryJSON); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := categoriesBucket.Delete(itob(category.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// btoi converts a byte slice to an integer
-func btoi(b []byte) int {
-	if len(b) < 8 {
-		return 0
+// deleteWhere removes every record of b whose value matches. Keys are
+// collected first because a bucket must not be changed while it is iterated.
+func deleteWhere(b *bbolt.Bucket, match func(v []byte) bool) error {
+	var keys [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		if match(v) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	return int(binary.BigEndian.Uint64(b))
+
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (data *Data) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(0)
+}
+
+// GetUserTaskCategoryByUser is GetUserTaskCategory limited to one user.
+func (data *Data) GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(userID)
+}
+
+// userTaskCategory joins users with their tasks and categories. A userID of 0
+// includes every user.
+func (data *Data) userTaskCategory(userID int) ([]model.UserTaskCategory, error) {
 	var results []model.UserTaskCategory
 
 	err := data.DB.View(func(tx *bbolt.Tx) error {
@@ -323,22 +738,28 @@ func (data *Data) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
 		return usersBucket.ForEach(func(_, userValue []byte) error {
 			var user model.User
 			if err := json.Unmarshal(userValue, &user); err != nil {
-				return err // skip badly formatted user records
+				log.Println("Error unmarshaling user:", err)
+				return nil // skip badly formatted user records
+			}
+			if userID != 0 && user.ID != userID {
+				return nil
 			}
 
 			// Now fetch tasks for the user
 			return tasksBucket.ForEach(func(_, taskValue []byte) error {
 				var task model.Task
 				if err := json.Unmarshal(taskValue, &task); err != nil {
-					return err // skip badly formatted task records
+					log.Println("Error unmarshaling task:", err)
+					return nil // skip badly formatted task records
 				}
 
 				if task.UserID == user.ID { // Check if the task belongs to the user
 					var category model.Category
-					catValue := categoriesBucket.Get([]byte(fmt.Sprintf("%d", task.CategoryID)))
+					catValue := categoriesBucket.Get(itob(task.CategoryID))
 					if catValue != nil {
 						if err := json.Unmarshal(catValue, &category); err != nil {
-							return err // skip badly formatted category records
+							// The task is still listed, without a category name.
+							log.Println("Error unmarshaling category:", err)
 						}
 					}
 
@@ -366,13 +787,37 @@ func (data *Data) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
 	return results, nil
 }
 
+// AddSession stores session under its token. A session without an ID gets
+// the next value of the Sessions bucket sequence so it can be referred to
+// without exposing the token.
 func (data *Data) AddSession(session model.Session) error {
-	sessionJSON, err := json.Marshal(session)
-	if err != nil {
-		return err
-	}
 	return data.DB.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Sessions"))
+		if session.ID == 0 {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			session.ID = int(seq)
+		}
+
+		// Overwriting a session may move it to another email.
+		if v := b.Get([]byte(session.Token)); v != nil {
+			var current model.Session
+			if err := json.Unmarshal(v, &current); err == nil {
+				if err := unindexSession(tx, current); err != nil {
+					return err
+				}
+			}
+		}
+
+		sessionJSON, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		if err := indexSession(tx, session); err != nil {
+			return err
+		}
 		return b.Put([]byte(session.Token), sessionJSON)
 	})
 }
@@ -380,14 +825,109 @@ func (data *Data) AddSession(session model.Session) error {
 func (data *Data) DeleteSession(token string) error {
 	return data.DB.Update(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Sessions"))
+		if v := b.Get([]byte(token)); v != nil {
+			var current model.Session
+			if err := json.Unmarshal(v, &current); err == nil {
+				current.Token = token
+				if err := unindexSession(tx, current); err != nil {
+					return err
+				}
+			}
+		}
 		return b.Delete([]byte(token))
 	})
 }
 
+// DeleteSessionsByEmail removes every session that belongs to email.
+func (data *Data) DeleteSessionsByEmail(email string) error {
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		return deleteSessionsByEmail(tx, email)
+	})
+}
+
 func (data *Data) UpdateSession(session model.Session) error {
 	return data.AddSession(session) // Reuse AddSession as it will overwrite the existing entry
 }
 
+// SessionByRefreshHash finds the session whose refresh token hashes to hash.
+// reused is true when hash belongs to a refresh token that has already been
+// exchanged, i.e. the token is being replayed.
+func (data *Data) SessionByRefreshHash(hash string) (session model.Session, reused bool, err error) {
+	found := false
+
+	err = data.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Sessions"))
+		if b == nil {
+			return fmt.Errorf("sessions bucket not found")
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var s model.Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				continue // Skip badly formatted session records
+			}
+			if s.RefreshTokenHash == hash {
+				session, found = s, true
+				return nil
+			}
+			for _, used := range s.UsedRefreshHashes {
+				if used == hash {
+					session, found, reused = s, true, true
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return model.Session{}, false, err
+	}
+	if !found {
+		return model.Session{}, false, model.ErrRecordNotFound
+	}
+	return session, reused, nil
+}
+
+// RotateSession replaces the session stored under oldToken with session in a
+// single transaction. It fails with model.ErrRecordNotFound unless the stored
+// row still expects refreshHash, so two concurrent refreshes with the same
+// token cannot both succeed.
+func (data *Data) RotateSession(oldToken, refreshHash string, session model.Session) error {
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Sessions"))
+
+		v := b.Get([]byte(oldToken))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		var current model.Session
+		if err := json.Unmarshal(v, &current); err != nil {
+			return err
+		}
+		if current.RefreshTokenHash != refreshHash {
+			return model.ErrRecordNotFound
+		}
+
+		current.Token = oldToken
+		if err := unindexSession(tx, current); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte(oldToken)); err != nil {
+			return err
+		}
+		if err := indexSession(tx, session); err != nil {
+			return err
+		}
+		return b.Put([]byte(session.Token), sessionJSON)
+	})
+}
+
 func (data *Data) SessionByToken(token string) (model.Session, error) {
 	var session model.Session
 	err := data.DB.View(func(tx *bbolt.Tx) error {
@@ -459,27 +999,24 @@ func (data *Data) GetFirstSession() (model.Session, error) {
 	return session, nil // Return the first session found
 }
 
+// SessionAvailEmail returns a session of email, looked up through the email
+// index.
 func (data *Data) SessionAvailEmail(email string) (model.Session, error) {
 	var session model.Session
 	found := false // Flag to check if at least one session matches the email
 
 	err := data.DB.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte("Sessions"))
-		if b == nil {
-			return fmt.Errorf("sessions bucket not found")
-		}
-
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var s model.Session
-			if err := json.Unmarshal(v, &s); err != nil {
-				continue // Skip badly formatted session records
+		for _, token := range sessionTokensByEmail(tx, email) {
+			v := b.Get(token)
+			if v == nil {
+				continue
 			}
-			if s.Email == email {
-				session = s
-				found = true
-				break // Stop the iteration as we found the session
+			if err := json.Unmarshal(v, &session); err != nil {
+				continue // Skip badly formatted session records
 			}
+			found = true
+			return nil
 		}
 		return nil
 	})
@@ -495,6 +1032,32 @@ func (data *Data) SessionAvailEmail(email string) (model.Session, error) {
 	return session, nil // Return the found session
 }
 
+// SessionsByEmail returns every session that belongs to email, oldest first.
+func (data *Data) SessionsByEmail(email string) ([]model.Session, error) {
+	var sessions []model.Session
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("Sessions"))
+		for _, token := range sessionTokensByEmail(tx, email) {
+			v := b.Get(token)
+			if v == nil {
+				continue
+			}
+			var s model.Session
+			if err := json.Unmarshal(v, &s); err != nil {
+				continue // Skip badly formatted session records
+			}
+			sessions = append(sessions, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
 func (data *Data) SessionAvailToken(token string) (model.Session, error) {
 	var session model.Session
 	err := data.DB.View(func(tx *bbolt.Tx) error {
@@ -517,3 +1080,217 @@ func (data *Data) SessionAvailToken(token string) (model.Session, error) {
 
 	return session, nil // Return the found session
 }
+
+// StoreAccessToken inserts a personal access token under its hash and assigns
+// it the next ID of the AccessTokens bucket.
+func (data *Data) StoreAccessToken(pat model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("AccessTokens"))
+		if b.Get([]byte(pat.TokenHash)) != nil {
+			return model.ErrRecordExists
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		pat.ID = int(seq)
+
+		patJSON, err := json.Marshal(pat)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(pat.TokenHash), patJSON)
+	})
+	if err != nil {
+		return model.PersonalAccessToken{}, err
+	}
+	return pat, nil
+}
+
+func (data *Data) UpdateAccessToken(pat model.PersonalAccessToken) error {
+	patJSON, err := json.Marshal(pat)
+	if err != nil {
+		return err
+	}
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("AccessTokens"))
+		if b.Get([]byte(pat.TokenHash)) == nil {
+			return model.ErrRecordNotFound
+		}
+		return b.Put([]byte(pat.TokenHash), patJSON)
+	})
+}
+
+func (data *Data) AccessTokenByHash(hash string) (model.PersonalAccessToken, error) {
+	var pat model.PersonalAccessToken
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("AccessTokens"))
+		v := b.Get([]byte(hash))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		return json.Unmarshal(v, &pat)
+	})
+	if err != nil {
+		return model.PersonalAccessToken{}, err
+	}
+	pat.TokenHash = hash
+	return pat, nil
+}
+
+// AccessTokensByUser returns the personal access tokens of userID ordered by
+// ID.
+func (data *Data) AccessTokensByUser(userID int) ([]model.PersonalAccessToken, error) {
+	var pats []model.PersonalAccessToken
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("AccessTokens"))
+		return b.ForEach(func(k, v []byte) error {
+			var pat model.PersonalAccessToken
+			if err := json.Unmarshal(v, &pat); err != nil {
+				log.Println("Error unmarshaling access token:", err)
+				return nil // Continue despite error
+			}
+			if pat.UserID == userID {
+				pat.TokenHash = string(k)
+				pats = append(pats, pat)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching access tokens: %v", err)
+	}
+
+	sort.Slice(pats, func(i, j int) bool { return pats[i].ID < pats[j].ID })
+	return pats, nil
+}
+
+// DeleteAccessToken removes the token with the given ID if it belongs to
+// userID.
+func (data *Data) DeleteAccessToken(userID, id int) error {
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("AccessTokens"))
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var pat model.PersonalAccessToken
+			if err := json.Unmarshal(v, &pat); err != nil {
+				continue // Skip badly formatted token records
+			}
+			if pat.ID == id && pat.UserID == userID {
+				return b.Delete(k)
+			}
+		}
+		return model.ErrRecordNotFound
+	})
+}
+
+// LoginAttempt returns the failed login counter stored under key. A key
+// without failures yields a zero counter.
+func (data *Data) LoginAttempt(key string) (model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Key: key}
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte("LoginAttempts")).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &attempt)
+	})
+	if err != nil {
+		return model.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// RecordLoginFailure increments the counter stored under key. A counter whose
+// last failure is older than resetAfter starts again from zero.
+func (data *Data) RecordLoginFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Key: key}
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("LoginAttempts"))
+		if v := b.Get([]byte(key)); v != nil {
+			if err := json.Unmarshal(v, &attempt); err != nil {
+				return err
+			}
+		}
+
+		if now.Sub(attempt.LastFailureAt) > resetAfter {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailureAt = now
+
+		attemptJSON, err := json.Marshal(attempt)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), attemptJSON)
+	})
+	if err != nil {
+		return model.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+func (data *Data) DeleteLoginAttempt(key string) error {
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("LoginAttempts")).Delete([]byte(key))
+	})
+}
+
+// StorePasswordReset saves reset under its token hash. Any earlier reset of
+// the same user is dropped, so only the most recent link works.
+func (data *Data) StorePasswordReset(reset model.PasswordReset) error {
+	resetJSON, err := json.Marshal(reset)
+	if err != nil {
+		return err
+	}
+	return data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("PasswordResets"))
+
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var r model.PasswordReset
+			if err := json.Unmarshal(v, &r); err != nil {
+				log.Println("Error unmarshaling password reset:", err)
+				return nil // Continue despite error
+			}
+			if r.UserID == reset.UserID {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(reset.TokenHash), resetJSON)
+	})
+}
+
+// ConsumePasswordReset removes and returns the reset stored under hash, so a
+// token can be redeemed only once.
+func (data *Data) ConsumePasswordReset(hash string) (model.PasswordReset, error) {
+	var reset model.PasswordReset
+	err := data.DB.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("PasswordResets"))
+		v := b.Get([]byte(hash))
+		if v == nil {
+			return model.ErrRecordNotFound
+		}
+		if err := json.Unmarshal(v, &reset); err != nil {
+			return err
+		}
+		return b.Delete([]byte(hash))
+	})
+	if err != nil {
+		return model.PasswordReset{}, err
+	}
+	return reset, nil
+}
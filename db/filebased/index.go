@@ -0,0 +1,220 @@
+package filebased
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"a21hc3NpZ25tZW50/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// Secondary indexes. Each lives in its own bucket and is written in the same
+// transaction as the records it points to, so lookups by email do not have to
+// scan and unmarshal every user or session.
+//
+// UsersByEmail maps a lower-cased email to the big-endian user ID.
+// SessionsByEmail has one key per session, the lower-cased email, a zero
+// byte and the session token; the value is the token.
+// TasksByCategory has one key per task, the big-endian category ID followed
+// by the big-endian task ID; the value is the task's key in Tasks.
+const (
+	usersByEmailBucket    = "UsersByEmail"
+	sessionsByEmailBucket = "SessionsByEmail"
+	tasksByCategoryBucket = "TasksByCategory"
+)
+
+var indexBuckets = []string{usersByEmailBucket, sessionsByEmailBucket, tasksByCategoryBucket}
+
+func emailKey(email string) []byte {
+	return []byte(strings.ToLower(email))
+}
+
+func sessionIndexPrefix(email string) []byte {
+	return append(emailKey(email), 0)
+}
+
+func sessionIndexKey(session model.Session) []byte {
+	return append(sessionIndexPrefix(session.Email), session.Token...)
+}
+
+// indexUser points user's email at its ID. An email already taken by another
+// user fails with model.ErrRecordExists.
+func indexUser(tx *bbolt.Tx, user model.User) error {
+	if user.Email == "" {
+		return nil
+	}
+	b := tx.Bucket([]byte(usersByEmailBucket))
+	if id := b.Get(emailKey(user.Email)); id != nil && btoi(id) != user.ID {
+		return model.ErrRecordExists
+	}
+	return b.Put(emailKey(user.Email), itob(user.ID))
+}
+
+// unindexUser removes user's email from the index if it still points at user.
+func unindexUser(tx *bbolt.Tx, user model.User) error {
+	if user.Email == "" {
+		return nil
+	}
+	b := tx.Bucket([]byte(usersByEmailBucket))
+	if id := b.Get(emailKey(user.Email)); id != nil && btoi(id) == user.ID {
+		return b.Delete(emailKey(user.Email))
+	}
+	return nil
+}
+
+func indexSession(tx *bbolt.Tx, session model.Session) error {
+	if session.Email == "" {
+		return nil
+	}
+	return tx.Bucket([]byte(sessionsByEmailBucket)).Put(sessionIndexKey(session), []byte(session.Token))
+}
+
+func unindexSession(tx *bbolt.Tx, session model.Session) error {
+	if session.Email == "" {
+		return nil
+	}
+	return tx.Bucket([]byte(sessionsByEmailBucket)).Delete(sessionIndexKey(session))
+}
+
+// sessionTokensByEmail returns the tokens of every session of email, in token
+// order.
+func sessionTokensByEmail(tx *bbolt.Tx, email string) [][]byte {
+	prefix := sessionIndexPrefix(email)
+
+	var tokens [][]byte
+	c := tx.Bucket([]byte(sessionsByEmailBucket)).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		tokens = append(tokens, append([]byte(nil), v...))
+	}
+	return tokens
+}
+
+// deleteSessionsByEmail removes every session of email along with its index
+// entries.
+func deleteSessionsByEmail(tx *bbolt.Tx, email string) error {
+	sessions := tx.Bucket([]byte("Sessions"))
+	index := tx.Bucket([]byte(sessionsByEmailBucket))
+	prefix := sessionIndexPrefix(email)
+
+	for _, token := range sessionTokensByEmail(tx, email) {
+		if err := sessions.Delete(token); err != nil {
+			return err
+		}
+		if err := index.Delete(append(append([]byte(nil), prefix...), token...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func taskIndexKey(task model.Task) []byte {
+	return append(itob(task.CategoryID), itob(task.ID)...)
+}
+
+func indexTask(tx *bbolt.Tx, task model.Task) error {
+	return tx.Bucket([]byte(tasksByCategoryBucket)).Put(taskIndexKey(task), itob(task.ID))
+}
+
+func unindexTask(tx *bbolt.Tx, task model.Task) error {
+	return tx.Bucket([]byte(tasksByCategoryBucket)).Delete(taskIndexKey(task))
+}
+
+// taskKeysByCategory returns the Tasks keys of every task in categoryID, in
+// task ID order.
+func taskKeysByCategory(tx *bbolt.Tx, categoryID int) [][]byte {
+	prefix := itob(categoryID)
+
+	var keys [][]byte
+	c := tx.Bucket([]byte(tasksByCategoryBucket)).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		keys = append(keys, append([]byte(nil), v...))
+	}
+	return keys
+}
+
+// RebuildIndexes recreates the indexes from the Users, Sessions and Tasks
+// buckets. It runs on its own when a database from before the indexes is
+// opened, and can be called to repair a damaged index. When several users
+// share an email, differing only in case, the one with the lowest ID keeps
+// it, as the lookup did before the index existed.
+func (data *Data) RebuildIndexes() error {
+	return data.DB.Update(rebuildIndexes)
+}
+
+func rebuildIndexes(tx *bbolt.Tx) error {
+	entries, err := indexEntries(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range indexBuckets {
+		if tx.Bucket([]byte(name)) != nil {
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		b, err := tx.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		for k, v := range entries[name] {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexEntries works out what each index bucket should hold from the records
+// it indexes. Records that cannot be decoded are left out.
+func indexEntries(tx *bbolt.Tx) (map[string]map[string][]byte, error) {
+	entries := map[string]map[string][]byte{}
+	for _, name := range indexBuckets {
+		entries[name] = map[string][]byte{}
+	}
+
+	users := entries[usersByEmailBucket]
+	err := tx.Bucket([]byte("Users")).ForEach(func(k, v []byte) error {
+		var user model.User
+		if err := json.Unmarshal(v, &user); err != nil || user.Email == "" {
+			return nil // Skip badly formatted user records
+		}
+		// Keys are visited in ID order, so the lowest ID keeps the email.
+		if _, ok := users[string(emailKey(user.Email))]; !ok {
+			users[string(emailKey(user.Email))] = append([]byte(nil), k...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Bucket([]byte("Sessions")).ForEach(func(k, v []byte) error {
+		var session model.Session
+		if err := json.Unmarshal(v, &session); err != nil || session.Email == "" {
+			return nil // Skip badly formatted session records
+		}
+		session.Token = string(k)
+		entries[sessionsByEmailBucket][string(sessionIndexKey(session))] = append([]byte(nil), k...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Bucket([]byte("Tasks")).ForEach(func(k, v []byte) error {
+		var task model.Task
+		if err := json.Unmarshal(v, &task); err != nil {
+			return nil // Skip badly formatted task records
+		}
+		entries[tasksByCategoryBucket][string(taskIndexKey(task))] = itob(task.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
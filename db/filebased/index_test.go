@@ -0,0 +1,101 @@
+package filebased
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"a21hc3NpZ25tZW50/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// The lookups by email should cost the same however many users there are:
+//
+//	go test ./db/filebased -run '^$' -bench Email
+
+var userCounts = []int{100, 1000, 10000}
+
+// seedUsers opens a fresh database holding n users with one session each.
+// They are written in a single transaction, which is much faster than
+// calling CreateUser n times.
+func seedUsers(b *testing.B, n int) *Data {
+	b.Helper()
+
+	data, err := Open(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { data.CloseDB() })
+
+	err = data.DB.Update(func(tx *bbolt.Tx) error {
+		users := tx.Bucket([]byte("Users"))
+		sessions := tx.Bucket([]byte("Sessions"))
+		for i := 1; i <= n; i++ {
+			user := model.User{ID: i, Fullname: fmt.Sprintf("user %d", i), Email: fmt.Sprintf("user%d@mail.com", i)}
+			userJSON, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+			if err := users.Put(itob(i), userJSON); err != nil {
+				return err
+			}
+			if err := indexUser(tx, user); err != nil {
+				return err
+			}
+
+			session := model.Session{ID: i, Token: fmt.Sprintf("token-%d", i), Email: user.Email}
+			sessionJSON, err := json.Marshal(session)
+			if err != nil {
+				return err
+			}
+			if err := sessions.Put([]byte(session.Token), sessionJSON); err != nil {
+				return err
+			}
+			if err := indexSession(tx, session); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkGetUserByEmail(b *testing.B) {
+	for _, n := range userCounts {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			data := seedUsers(b, n)
+			// The last user is the worst case for a scan of the bucket.
+			email := fmt.Sprintf("USER%d@mail.com", n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				user, err := data.GetUserByEmail(email)
+				if err != nil || user.ID != n {
+					b.Fatalf("GetUserByEmail(%q) = %d, %v", email, user.ID, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSessionAvailEmail(b *testing.B) {
+	for _, n := range userCounts {
+		b.Run(fmt.Sprintf("users=%d", n), func(b *testing.B) {
+			data := seedUsers(b, n)
+			email := fmt.Sprintf("user%d@mail.com", n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session, err := data.SessionAvailEmail(email)
+				if err != nil || session.ID != n {
+					b.Fatalf("SessionAvailEmail(%q) = %d, %v", email, session.ID, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package filebased
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"a21hc3NpZ25tZW50/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// quarantineBucket holds the records an integrity check took out of use, in a
+// nested bucket named after the bucket each came from and under its original
+// key, so they can still be inspected or put back by hand.
+const quarantineBucket = "Quarantine"
+
+// recordBuckets are the buckets holding records, in the order they are
+// checked. Indexes are checked separately.
+var recordBuckets = []string{"Users", "Categories", "Tasks", "Sessions", "AccessTokens", "PasswordResets", "LoginAttempts"}
+
+// idKeyBuckets are keyed by itob of the record ID.
+var idKeyBuckets = map[string]bool{"Users": true, "Categories": true, "Tasks": true}
+
+// CheckIntegrity looks for records that cannot be decoded, tasks whose user
+// or category is gone, sessions, access tokens and password resets whose user
+// is gone, and index entries that do not match the records. With quarantine
+// set the bad records are moved to the Quarantine bucket and the indexes are
+// rebuilt, all in one transaction; otherwise nothing is written.
+func (data *Data) CheckIntegrity(quarantine bool) (model.IntegrityReport, error) {
+	report := model.IntegrityReport{CheckedAt: time.Now().UTC(), Problems: []model.IntegrityProblem{}}
+
+	check := func(tx *bbolt.Tx) error {
+		bad, err := checkRecords(tx, &report)
+		if err != nil {
+			return err
+		}
+		if err := checkIndexes(tx, &report); err != nil {
+			return err
+		}
+		if !quarantine || len(report.Problems) == 0 {
+			return nil
+		}
+
+		for i, problem := range report.Problems {
+			if problem.Kind == model.ProblemIndexMismatch {
+				continue
+			}
+			if err := quarantineRecord(tx, problem.Bucket, bad[i]); err != nil {
+				return err
+			}
+			report.Problems[i].Quarantined = true
+		}
+		report.IndexesRebuilt = true
+		return rebuildIndexes(tx)
+	}
+
+	var err error
+	if quarantine {
+		err = data.DB.Update(check)
+	} else {
+		err = data.DB.View(check)
+	}
+	if err != nil {
+		return model.IntegrityReport{}, err
+	}
+	return report, nil
+}
+
+// checkRecords adds a problem to report for every bad record and returns the
+// raw key of each, by the problem's index.
+func checkRecords(tx *bbolt.Tx, report *model.IntegrityReport) (map[int][]byte, error) {
+	bad := map[int][]byte{}
+	add := func(bucket string, k []byte, kind, detail string) {
+		bad[len(report.Problems)] = append([]byte(nil), k...)
+		report.Problems = append(report.Problems, model.IntegrityProblem{
+			Bucket: bucket,
+			Key:    displayKey(bucket, k),
+			Kind:   kind,
+			Detail: detail,
+		})
+	}
+
+	userIDs := map[int]bool{}
+	emails := map[string]bool{}
+	categoryIDs := map[int]bool{}
+
+	for _, name := range recordBuckets {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			continue
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			report.Records++
+
+			var err error
+			switch name {
+			case "Users":
+				var user model.User
+				if err = json.Unmarshal(v, &user); err == nil {
+					userIDs[user.ID] = true
+					emails[strings.ToLower(user.Email)] = true
+				}
+			case "Categories":
+				var category model.Category
+				if err = json.Unmarshal(v, &category); err == nil {
+					categoryIDs[category.ID] = true
+				}
+			case "Tasks":
+				var task model.Task
+				if err = json.Unmarshal(v, &task); err == nil {
+					switch {
+					case !userIDs[task.UserID]:
+						add(name, k, model.ProblemOrphanedTask, fmt.Sprintf("user %d does not exist", task.UserID))
+					case !categoryIDs[task.CategoryID]:
+						add(name, k, model.ProblemOrphanedTask, fmt.Sprintf("category %d does not exist", task.CategoryID))
+					}
+				}
+			case "Sessions":
+				var session model.Session
+				if err = json.Unmarshal(v, &session); err == nil && !emails[strings.ToLower(session.Email)] {
+					add(name, k, model.ProblemDangling, fmt.Sprintf("no user has the email %q", session.Email))
+				}
+			case "AccessTokens":
+				var pat model.PersonalAccessToken
+				if err = json.Unmarshal(v, &pat); err == nil && !userIDs[pat.UserID] {
+					add(name, k, model.ProblemDangling, fmt.Sprintf("user %d does not exist", pat.UserID))
+				}
+			case "PasswordResets":
+				var reset model.PasswordReset
+				if err = json.Unmarshal(v, &reset); err == nil && !userIDs[reset.UserID] {
+					add(name, k, model.ProblemDangling, fmt.Sprintf("user %d does not exist", reset.UserID))
+				}
+			case "LoginAttempts":
+				var attempt model.LoginAttempt
+				err = json.Unmarshal(v, &attempt)
+			}
+			if err != nil {
+				add(name, k, model.ProblemUndecodable, err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bad, nil
+}
+
+// checkIndexes compares every index bucket with what rebuildIndexes would
+// write into it.
+func checkIndexes(tx *bbolt.Tx, report *model.IntegrityReport) error {
+	entries, err := indexEntries(tx)
+	if err != nil {
+		return err
+	}
+
+	add := func(name string, k []byte, detail string) {
+		report.Problems = append(report.Problems, model.IntegrityProblem{
+			Bucket: name,
+			Key:    displayIndexKey(name, k),
+			Kind:   model.ProblemIndexMismatch,
+			Detail: detail,
+		})
+	}
+
+	for _, name := range indexBuckets {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			add(name, nil, "index bucket is missing")
+			continue
+		}
+
+		want := entries[name]
+		err := b.ForEach(func(k, v []byte) error {
+			expected, ok := want[string(k)]
+			switch {
+			case !ok:
+				add(name, k, "stale entry")
+			case !bytes.Equal(v, expected):
+				add(name, k, "entry points at the wrong record")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k := range want {
+			if b.Get([]byte(k)) == nil {
+				add(name, []byte(k), "entry is missing")
+			}
+		}
+	}
+	return nil
+}
+
+func quarantineRecord(tx *bbolt.Tx, bucket string, k []byte) error {
+	q, err := tx.CreateBucketIfNotExists([]byte(quarantineBucket))
+	if err != nil {
+		return err
+	}
+	dst, err := q.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+
+	src := tx.Bucket([]byte(bucket))
+	if err := dst.Put(k, append([]byte(nil), src.Get(k)...)); err != nil {
+		return err
+	}
+	return src.Delete(k)
+}
+
+// displayKey renders a record key for a report. Session keys are the session
+// tokens themselves, so only a hash of them is shown.
+func displayKey(bucket string, k []byte) string {
+	switch {
+	case idKeyBuckets[bucket] && len(k) == 8:
+		return fmt.Sprint(btoi(k))
+	case bucket == "Sessions":
+		sum := sha256.Sum256(k)
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+	return string(k)
+}
+
+func displayIndexKey(name string, k []byte) string {
+	switch name {
+	case sessionsByEmailBucket:
+		if i := bytes.IndexByte(k, 0); i >= 0 {
+			return string(k[:i]) + " " + displayKey("Sessions", k[i+1:])
+		}
+	case tasksByCategoryBucket:
+		if len(k) == 16 {
+			return fmt.Sprintf("category %d task %d", btoi(k[:8]), btoi(k[8:]))
+		}
+	}
+	return string(k)
+}
@@ -0,0 +1,82 @@
+package filebased
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+// Every bucket whose records are identified by a numeric ID keys them with
+// itob, so that ForEach and cursors visit them in ID order.
+
+// itob converts an integer to a byte slice
+func itob(v int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+// btoi converts a byte slice to an integer
+func btoi(b []byte) int {
+	if len(b) < 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(b))
+}
+
+// isDecimalKey reports whether k is one of the decimal string keys Tasks and
+// Categories used to be written with. An itob key of any ID below 2^56 starts
+// with a zero byte, so the two cannot be mistaken for each other.
+func isDecimalKey(k []byte) bool {
+	if len(k) == 0 {
+		return false
+	}
+	for _, c := range k {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateKeys rewrites the Tasks and Categories records still stored under
+// decimal string keys to itob keys, then rebuilds the indexes, whose values
+// point at the old keys. Records already migrated are left alone.
+func migrateKeys(tx *bbolt.Tx) error {
+	for _, name := range []string{"Tasks", "Categories"} {
+		b := tx.Bucket([]byte(name))
+
+		// Keys are collected first because a bucket must not be changed
+		// while it is iterated.
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if isDecimalKey(k) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			id, err := strconv.Atoi(string(k))
+			if err != nil {
+				return fmt.Errorf("%s key %q: %v", name, k, err)
+			}
+			if b.Get(itob(id)) != nil {
+				return fmt.Errorf("%s key %q: record %d is stored under both encodings", name, k, id)
+			}
+			v := append([]byte(nil), b.Get(k)...)
+			if err := b.Put(itob(id), v); err != nil {
+				return err
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return rebuildIndexes(tx)
+}
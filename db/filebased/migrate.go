@@ -0,0 +1,174 @@
+package filebased
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// The Meta bucket records the schema version of the database, the number of
+// migrations that have been applied to it.
+const metaBucket = "Meta"
+
+var schemaVersionKey = []byte("schema_version")
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// binary than this one, which might not understand what it stored.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("dry run")
+
+// Migration is one change to the shape of the stored data.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx *bbolt.Tx) error
+}
+
+// migrations lists every migration, oldest first; the version of each is its
+// position counted from 1. Append new ones at the end and never change or
+// reorder one that has been released. Each runs in its own transaction
+// together with the version bump, and must cope with databases from before
+// versioning, which start at version 0 whatever shape they are in.
+var migrations = []Migration{
+	{Description: "key tasks and categories by big-endian ID", up: migrateKeys},
+	{Description: "move the task and category sequences past the stored IDs", up: migrateSequences},
+	{Description: "move the user sequence past the stored IDs", up: migrateUserSequence},
+}
+
+// LatestSchemaVersion is the schema version this binary migrates databases
+// to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+func schemaVersion(tx *bbolt.Tx) int {
+	b := tx.Bucket([]byte(metaBucket))
+	if b == nil {
+		return 0
+	}
+	return btoi(b.Get(schemaVersionKey))
+}
+
+func setSchemaVersion(tx *bbolt.Tx, version int) error {
+	return tx.Bucket([]byte(metaBucket)).Put(schemaVersionKey, itob(version))
+}
+
+// SchemaVersion returns the schema version of the database.
+func (data *Data) SchemaVersion() (int, error) {
+	var version int
+	err := data.DB.View(func(tx *bbolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+// Migrate creates any missing bucket and applies the pending migrations in
+// order, returning the ones it applied. With dryRun set everything runs in a
+// single transaction that is rolled back, so the database is left untouched
+// and the migrations returned are those that would be applied. A database
+// newer than this binary is refused with ErrSchemaTooNew before anything is
+// written.
+func Migrate(db *bbolt.DB, dryRun bool) ([]Migration, error) {
+	var current int
+	err := db.View(func(tx *bbolt.Tx) error {
+		current = schemaVersion(tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	var pending []Migration
+	for i, m := range migrations[current:] {
+		m.Version = current + i + 1
+		pending = append(pending, m)
+	}
+
+	if dryRun {
+		err := db.Update(func(tx *bbolt.Tx) error {
+			if err := ensureBuckets(tx); err != nil {
+				return err
+			}
+			for _, m := range pending {
+				if err := apply(tx, m); err != nil {
+					return err
+				}
+			}
+			return errDryRun
+		})
+		if !errors.Is(err, errDryRun) {
+			return nil, err
+		}
+		return pending, nil
+	}
+
+	if err := db.Update(ensureBuckets); err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		if err := db.Update(func(tx *bbolt.Tx) error { return apply(tx, m) }); err != nil {
+			return nil, err
+		}
+		log.Printf("Database migrated to version %d: %s", m.Version, m.Description)
+	}
+	return pending, nil
+}
+
+func apply(tx *bbolt.Tx, m Migration) error {
+	if err := m.up(tx); err != nil {
+		return fmt.Errorf("migration %d (%s): %v", m.Version, m.Description, err)
+	}
+	return setSchemaVersion(tx, m.Version)
+}
+
+// MigrateFile runs Migrate on the database at path and closes it again.
+func MigrateFile(path string, dryRun bool) ([]Migration, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	defer db.Close()
+
+	return Migrate(db, dryRun)
+}
+
+// migrateSequences sets the sequence of Tasks and Categories to the highest
+// stored ID where it lags behind. Databases from before IDs were allocated
+// from the sequence start it at zero, and the next record would otherwise
+// get an ID that is already taken.
+func migrateSequences(tx *bbolt.Tx) error {
+	return raiseSequences(tx, "Tasks", "Categories")
+}
+
+// migrateUserSequence does the same for Users, whose IDs were one higher than
+// the highest stored before they came from the sequence.
+func migrateUserSequence(tx *bbolt.Tx) error {
+	return raiseSequences(tx, "Users")
+}
+
+// raiseSequences sets the sequence of each named bucket to its highest key
+// where the sequence lags behind.
+func raiseSequences(tx *bbolt.Tx, names ...string) error {
+	for _, name := range names {
+		b := tx.Bucket([]byte(name))
+		k, _ := b.Cursor().Last()
+		if k == nil {
+			continue
+		}
+		if last := uint64(btoi(k)); last > b.Sequence() {
+			if err := b.SetSequence(last); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,908 @@
+// Package memory keeps the application's data in maps, for tests and for
+// instances whose data need not outlive the process. It behaves like the file
+// store in every way the repositories can observe: IDs are allocated the same
+// way, missing records yield model.ErrRecordNotFound and lists come back in
+// ID order. A failed call changes nothing.
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"a21hc3NpZ25tZW50/model"
+)
+
+type Data struct {
+	mu sync.RWMutex
+
+	tasks          map[int]model.Task
+	categories     map[int]model.Category
+	users          map[int]model.User
+	sessions       map[string]model.Session
+	accessTokens   map[string]model.PersonalAccessToken
+	loginAttempts  map[string]model.LoginAttempt
+	passwordResets map[string]model.PasswordReset
+
+	// sequences holds the last ID handed out for tasks, categories, sessions
+	// and access tokens, like the bucket sequences of the file store. Users
+	// get one more than the highest stored ID instead.
+	sequences map[string]int
+}
+
+func New() *Data {
+	data := &Data{}
+	data.reset()
+	return data
+}
+
+// Reset forgets every record and sequence.
+func (data *Data) Reset() error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	data.reset()
+	return nil
+}
+
+func (data *Data) reset() {
+	data.tasks = map[int]model.Task{}
+	data.categories = map[int]model.Category{}
+	data.users = map[int]model.User{}
+	data.sessions = map[string]model.Session{}
+	data.accessTokens = map[string]model.PersonalAccessToken{}
+	data.loginAttempts = map[string]model.LoginAttempt{}
+	data.passwordResets = map[string]model.PasswordReset{}
+	data.sequences = map[string]int{}
+}
+
+func (data *Data) CloseDB() error {
+	return nil
+}
+
+// nextID returns the ID a new record should get without taking it, so that a
+// call failing afterwards leaves the sequence alone. A zero requested ID is
+// the sequence's next value that taken reports free; otherwise the requested
+// ID is used if free. Requested IDs outside 1..model.MaxID are refused.
+func (data *Data) nextID(sequence string, requested int, taken func(id int) bool) (int, error) {
+	if requested == 0 {
+		for id := data.sequences[sequence] + 1; ; id++ {
+			if id > model.MaxID {
+				return 0, model.ErrIDsExhausted
+			}
+			if !taken(id) {
+				return id, nil
+			}
+		}
+	}
+	if requested < 0 || requested > model.MaxID {
+		return 0, model.ErrInvalidID
+	}
+	if taken(requested) {
+		return 0, model.ErrRecordExists
+	}
+	return requested, nil
+}
+
+// useID moves the sequence past id so later allocations cannot collide.
+func (data *Data) useID(sequence string, id int) {
+	if id > data.sequences[sequence] {
+		data.sequences[sequence] = id
+	}
+}
+
+// sortedIDs returns the keys of m in ascending order.
+func sortedIDs[V any](m map[int]V) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// sortedKeys returns the keys of m in ascending order, the order a bbolt
+// bucket is iterated in.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Records are copied on the way in and out so that callers cannot change
+// what is stored through a shared slice.
+
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string(nil), list...)
+}
+
+func copyUser(user model.User) model.User {
+	user.RecoveryCodeHashes = copyStrings(user.RecoveryCodeHashes)
+	return user
+}
+
+func copySession(session model.Session) model.Session {
+	session.UsedRefreshHashes = copyStrings(session.UsedRefreshHashes)
+	return session
+}
+
+// StoreTask inserts a new task. When task.ID is zero the next value of the
+// tasks sequence is assigned; a client-supplied ID is accepted only if it is
+// not taken yet. The task's category must exist, otherwise
+// model.ErrCategoryNotFound is returned.
+func (data *Data) StoreTask(task model.Task) (model.Task, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	id, err := data.nextID("tasks", task.ID, func(id int) bool {
+		_, ok := data.tasks[id]
+		return ok
+	})
+	if err != nil {
+		return model.Task{}, err
+	}
+	if _, ok := data.categories[task.CategoryID]; !ok {
+		return model.Task{}, model.ErrCategoryNotFound
+	}
+
+	task.ID = id
+	data.useID("tasks", id)
+	data.tasks[id] = task
+	return task, nil
+}
+
+// StoreCategory inserts a new category, allocating its ID the same way as
+// StoreTask.
+func (data *Data) StoreCategory(category model.Category) (model.Category, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	id, err := data.nextID("categories", category.ID, func(id int) bool {
+		_, ok := data.categories[id]
+		return ok
+	})
+	if err != nil {
+		return model.Category{}, err
+	}
+
+	category.ID = id
+	data.useID("categories", id)
+	data.categories[id] = category
+	return category, nil
+}
+
+// UpdateTask replaces the task stored under id. The stored task must belong to
+// task.UserID, otherwise model.ErrRecordNotFound is returned, and the new
+// category must exist, otherwise model.ErrCategoryNotFound is returned.
+func (data *Data) UpdateTask(id int, task model.Task) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if stored, ok := data.tasks[id]; !ok || stored.UserID != task.UserID {
+		return model.ErrRecordNotFound
+	}
+	if _, ok := data.categories[task.CategoryID]; !ok {
+		return model.ErrCategoryNotFound
+	}
+
+	task.ID = id
+	data.tasks[id] = task
+	return nil
+}
+
+// UpdateCategory renames the category stored under id; its owner is kept. An
+// unknown id yields model.ErrRecordNotFound; categories are only created by
+// StoreCategory, so that every ID comes from the sequence.
+func (data *Data) UpdateCategory(id int, category model.Category) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	stored, ok := data.categories[id]
+	if !ok {
+		return model.ErrRecordNotFound
+	}
+	stored.Name = category.Name
+	data.categories[id] = stored
+	return nil
+}
+
+// DeleteTask removes the task stored under id if it belongs to userID.
+func (data *Data) DeleteTask(userID, id int) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if stored, ok := data.tasks[id]; !ok || stored.UserID != userID {
+		return model.ErrRecordNotFound
+	}
+	delete(data.tasks, id)
+	return nil
+}
+
+// DeleteCategory removes category id. Its tasks are handled according to
+// mode: CategoryDeleteRestrict fails with model.ErrCategoryInUse if there are
+// any, CategoryDeleteCascade deletes them and CategoryDeleteReassign moves them
+// to category reassignTo, which must exist. An unknown id yields
+// model.ErrRecordNotFound.
+func (data *Data) DeleteCategory(id int, mode model.CategoryDeleteMode, reassignTo int) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if _, ok := data.categories[id]; !ok {
+		return model.ErrRecordNotFound
+	}
+
+	var taskIDs []int
+	for _, taskID := range sortedIDs(data.tasks) {
+		if data.tasks[taskID].CategoryID == id {
+			taskIDs = append(taskIDs, taskID)
+		}
+	}
+
+	switch mode {
+	case model.CategoryDeleteRestrict:
+		if len(taskIDs) > 0 {
+			return model.ErrCategoryInUse
+		}
+	case model.CategoryDeleteCascade:
+		for _, taskID := range taskIDs {
+			delete(data.tasks, taskID)
+		}
+	case model.CategoryDeleteReassign:
+		if _, ok := data.categories[reassignTo]; !ok || reassignTo == id {
+			return model.ErrCategoryNotFound
+		}
+		for _, taskID := range taskIDs {
+			task := data.tasks[taskID]
+			task.CategoryID = reassignTo
+			data.tasks[taskID] = task
+		}
+	default:
+		return fmt.Errorf("unknown category delete mode %q", mode)
+	}
+
+	delete(data.categories, id)
+	return nil
+}
+
+func (data *Data) GetTaskByID(id int) (*model.Task, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	task, ok := data.tasks[id]
+	if !ok {
+		return nil, model.ErrRecordNotFound
+	}
+	return &task, nil
+}
+
+func (data *Data) GetCategoryByID(id int) (*model.Category, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	category, ok := data.categories[id]
+	if !ok {
+		return nil, model.ErrRecordNotFound
+	}
+	return &category, nil
+}
+
+func (data *Data) GetTasks() ([]model.Task, error) {
+	return data.tasksWhere(func(model.Task) bool { return true }), nil
+}
+
+func (data *Data) GetTasksByUser(userID int) ([]model.Task, error) {
+	return data.tasksWhere(func(task model.Task) bool { return task.UserID == userID }), nil
+}
+
+// tasksWhere returns the tasks that match in ID order.
+func (data *Data) tasksWhere(match func(model.Task) bool) []model.Task {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	var tasks []model.Task
+	for _, id := range sortedIDs(data.tasks) {
+		if match(data.tasks[id]) {
+			tasks = append(tasks, data.tasks[id])
+		}
+	}
+	return tasks
+}
+
+func (data *Data) GetCategories() ([]model.Category, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	var categories []model.Category
+	for _, id := range sortedIDs(data.categories) {
+		categories = append(categories, data.categories[id])
+	}
+	return categories, nil
+}
+
+func (data *Data) GetTaskListByCategory(userID, categoryID int) ([]model.TaskCategory, error) {
+	category, err := data.GetCategoryByID(categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching category: %v", err)
+	}
+
+	var taskCategories []model.TaskCategory
+	for _, task := range data.tasksWhere(func(task model.Task) bool {
+		return task.CategoryID == categoryID && task.UserID == userID
+	}) {
+		taskCategories = append(taskCategories, model.TaskCategory{
+			ID:       task.ID,
+			Title:    task.Title,
+			Category: category.Name,
+		})
+	}
+	if len(taskCategories) == 0 {
+		return nil, fmt.Errorf("no tasks found for category ID: %d", categoryID)
+	}
+	return taskCategories, nil
+}
+
+// userByEmail returns the ID of the user with email, ignoring case, or 0.
+func (data *Data) userByEmail(email string) int {
+	if email == "" {
+		return 0
+	}
+	for _, id := range sortedIDs(data.users) {
+		if strings.EqualFold(data.users[id].Email, email) {
+			return id
+		}
+	}
+	return 0
+}
+
+// GetUserByEmail looks the user up ignoring case. An unknown email yields a
+// zero User and no error.
+func (data *Data) GetUserByEmail(email string) (model.User, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	id := data.userByEmail(email)
+	if id == 0 {
+		return model.User{}, nil
+	}
+	return copyUser(data.users[id]), nil
+}
+
+// CreateUser inserts user under the next ID of the users sequence, so the ID
+// of a deleted user is never handed out again. An email already taken by
+// another user fails with model.ErrRecordExists.
+func (data *Data) CreateUser(user model.User) (model.User, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if data.userByEmail(user.Email) != 0 {
+		return model.User{}, model.ErrRecordExists
+	}
+
+	id, err := data.nextID("users", 0, func(id int) bool {
+		_, ok := data.users[id]
+		return ok
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	user.ID = id
+	data.useID("users", id)
+	data.users[user.ID] = copyUser(user)
+	return user, nil
+}
+
+// GetUserByID returns the user stored under id, or model.ErrRecordNotFound.
+func (data *Data) GetUserByID(id int) (model.User, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	user, ok := data.users[id]
+	if !ok {
+		return model.User{}, model.ErrRecordNotFound
+	}
+	return copyUser(user), nil
+}
+
+// UpdateUser replaces the stored record of an existing user.
+func (data *Data) UpdateUser(user model.User) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if _, ok := data.users[user.ID]; !ok {
+		return model.ErrRecordNotFound
+	}
+	if owner := data.userByEmail(user.Email); owner != 0 && owner != user.ID {
+		return model.ErrRecordExists
+	}
+
+	data.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// DeleteUser removes user id together with everything that belongs to it:
+// tasks, sessions, access tokens, password resets, the login attempts kept
+// under "email:<address>" and "user:<id>", and the categories it created. A
+// category that tasks of other users are still in is kept without an owner
+// instead.
+func (data *Data) DeleteUser(id int) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	user, ok := data.users[id]
+	if !ok {
+		return model.ErrRecordNotFound
+	}
+	delete(data.users, id)
+
+	for taskID, task := range data.tasks {
+		if task.UserID == id {
+			delete(data.tasks, taskID)
+		}
+	}
+	data.deleteSessionsByEmail(user.Email)
+	for hash, pat := range data.accessTokens {
+		if pat.UserID == id {
+			delete(data.accessTokens, hash)
+		}
+	}
+	for hash, reset := range data.passwordResets {
+		if reset.UserID == id {
+			delete(data.passwordResets, hash)
+		}
+	}
+	delete(data.loginAttempts, "email:"+strings.ToLower(user.Email))
+	delete(data.loginAttempts, "user:"+strconv.Itoa(id))
+
+	inUse := map[int]bool{}
+	for _, task := range data.tasks {
+		inUse[task.CategoryID] = true
+	}
+	for categoryID, category := range data.categories {
+		if category.UserID != id {
+			continue
+		}
+		if inUse[categoryID] {
+			category.UserID = 0
+			data.categories[categoryID] = category
+		} else {
+			delete(data.categories, categoryID)
+		}
+	}
+	return nil
+}
+
+func (data *Data) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(0)
+}
+
+// GetUserTaskCategoryByUser is GetUserTaskCategory limited to one user.
+func (data *Data) GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(userID)
+}
+
+// userTaskCategory joins users with their tasks and categories. A userID of 0
+// includes every user.
+func (data *Data) userTaskCategory(userID int) ([]model.UserTaskCategory, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	var results []model.UserTaskCategory
+	for _, id := range sortedIDs(data.users) {
+		user := data.users[id]
+		if userID != 0 && user.ID != userID {
+			continue
+		}
+		for _, taskID := range sortedIDs(data.tasks) {
+			task := data.tasks[taskID]
+			if task.UserID != user.ID {
+				continue
+			}
+			results = append(results, model.UserTaskCategory{
+				ID:       user.ID,
+				Fullname: user.Fullname,
+				Email:    user.Email,
+				Task:     task.Title,
+				Deadline: task.Deadline,
+				Priority: task.Priority,
+				Status:   task.Status,
+				Category: data.categories[task.CategoryID].Name,
+			})
+		}
+	}
+	return results, nil
+}
+
+// AddSession stores session under its token, replacing any session already
+// stored there. A session without an ID gets the next value of the sessions
+// sequence so it can be referred to without exposing the token.
+func (data *Data) AddSession(session model.Session) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if session.ID == 0 {
+		data.sequences["sessions"]++
+		session.ID = data.sequences["sessions"]
+	}
+	data.sessions[session.Token] = copySession(session)
+	return nil
+}
+
+func (data *Data) DeleteSession(token string) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	delete(data.sessions, token)
+	return nil
+}
+
+// DeleteSessionsByEmail removes every session that belongs to email.
+func (data *Data) DeleteSessionsByEmail(email string) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	data.deleteSessionsByEmail(email)
+	return nil
+}
+
+// deleteSessionsByEmail removes the sessions of email. Sessions without an
+// email belong to nobody and are left alone, as in the file store.
+func (data *Data) deleteSessionsByEmail(email string) {
+	if email == "" {
+		return
+	}
+	for token, session := range data.sessions {
+		if strings.EqualFold(session.Email, email) {
+			delete(data.sessions, token)
+		}
+	}
+}
+
+func (data *Data) UpdateSession(session model.Session) error {
+	return data.AddSession(session) // Reuse AddSession as it will overwrite the existing entry
+}
+
+// SessionByRefreshHash finds the session whose refresh token hashes to hash.
+// reused is true when hash belongs to a refresh token that has already been
+// exchanged, i.e. the token is being replayed.
+func (data *Data) SessionByRefreshHash(hash string) (session model.Session, reused bool, err error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	for _, token := range sortedKeys(data.sessions) {
+		s := data.sessions[token]
+		if s.RefreshTokenHash == hash {
+			return copySession(s), false, nil
+		}
+		for _, used := range s.UsedRefreshHashes {
+			if used == hash {
+				return copySession(s), true, nil
+			}
+		}
+	}
+	return model.Session{}, false, model.ErrRecordNotFound
+}
+
+// RotateSession replaces the session stored under oldToken with session. It
+// fails with model.ErrRecordNotFound unless the stored session still expects
+// refreshHash, so two concurrent refreshes with the same token cannot both
+// succeed.
+func (data *Data) RotateSession(oldToken, refreshHash string, session model.Session) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	current, ok := data.sessions[oldToken]
+	if !ok || current.RefreshTokenHash != refreshHash {
+		return model.ErrRecordNotFound
+	}
+	delete(data.sessions, oldToken)
+	data.sessions[session.Token] = copySession(session)
+	return nil
+}
+
+// sessionsByEmail returns the sessions of email in token order.
+func (data *Data) sessionsByEmail(email string) []model.Session {
+	if email == "" {
+		return nil
+	}
+	var sessions []model.Session
+	for _, token := range sortedKeys(data.sessions) {
+		if strings.EqualFold(data.sessions[token].Email, email) {
+			sessions = append(sessions, copySession(data.sessions[token]))
+		}
+	}
+	return sessions
+}
+
+// SessionAvailEmail returns the session of email with the lowest token.
+func (data *Data) SessionAvailEmail(email string) (model.Session, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	sessions := data.sessionsByEmail(email)
+	if len(sessions) == 0 {
+		return model.Session{}, fmt.Errorf("no session available for email: %s", email) // No session was found for the given email
+	}
+	return sessions[0], nil
+}
+
+// SessionsByEmail returns every session that belongs to email, oldest first.
+func (data *Data) SessionsByEmail(email string) ([]model.Session, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	sessions := data.sessionsByEmail(email)
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions, nil
+}
+
+func (data *Data) SessionAvailToken(token string) (model.Session, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	session, ok := data.sessions[token]
+	if !ok {
+		return model.Session{}, fmt.Errorf("no session available for token: %s", token) // No session was found for the given token
+	}
+	return copySession(session), nil
+}
+
+// StoreAccessToken inserts a personal access token under its hash and assigns
+// it the next ID of the access tokens sequence.
+func (data *Data) StoreAccessToken(pat model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if _, ok := data.accessTokens[pat.TokenHash]; ok {
+		return model.PersonalAccessToken{}, model.ErrRecordExists
+	}
+
+	data.sequences["access_tokens"]++
+	pat.ID = data.sequences["access_tokens"]
+	data.accessTokens[pat.TokenHash] = pat
+	return pat, nil
+}
+
+func (data *Data) UpdateAccessToken(pat model.PersonalAccessToken) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	if _, ok := data.accessTokens[pat.TokenHash]; !ok {
+		return model.ErrRecordNotFound
+	}
+	data.accessTokens[pat.TokenHash] = pat
+	return nil
+}
+
+func (data *Data) AccessTokenByHash(hash string) (model.PersonalAccessToken, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	pat, ok := data.accessTokens[hash]
+	if !ok {
+		return model.PersonalAccessToken{}, model.ErrRecordNotFound
+	}
+	return pat, nil
+}
+
+// AccessTokensByUser returns the personal access tokens of userID ordered by
+// ID.
+func (data *Data) AccessTokensByUser(userID int) ([]model.PersonalAccessToken, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	var pats []model.PersonalAccessToken
+	for _, pat := range data.accessTokens {
+		if pat.UserID == userID {
+			pats = append(pats, pat)
+		}
+	}
+	sort.Slice(pats, func(i, j int) bool { return pats[i].ID < pats[j].ID })
+	return pats, nil
+}
+
+// DeleteAccessToken removes the token with the given ID if it belongs to
+// userID.
+func (data *Data) DeleteAccessToken(userID, id int) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	for hash, pat := range data.accessTokens {
+		if pat.ID == id && pat.UserID == userID {
+			delete(data.accessTokens, hash)
+			return nil
+		}
+	}
+	return model.ErrRecordNotFound
+}
+
+// LoginAttempt returns the failed login counter stored under key. A key
+// without failures yields a zero counter.
+func (data *Data) LoginAttempt(key string) (model.LoginAttempt, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	if attempt, ok := data.loginAttempts[key]; ok {
+		return attempt, nil
+	}
+	return model.LoginAttempt{Key: key}, nil
+}
+
+// RecordLoginFailure increments the counter stored under key. A counter whose
+// last failure is older than resetAfter starts again from zero.
+func (data *Data) RecordLoginFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	attempt, ok := data.loginAttempts[key]
+	if !ok {
+		attempt = model.LoginAttempt{Key: key}
+	}
+	if now.Sub(attempt.LastFailureAt) > resetAfter {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+
+	data.loginAttempts[key] = attempt
+	return attempt, nil
+}
+
+func (data *Data) DeleteLoginAttempt(key string) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	delete(data.loginAttempts, key)
+	return nil
+}
+
+// StorePasswordReset saves reset under its token hash. Any earlier reset of
+// the same user is dropped, so only the most recent link works.
+func (data *Data) StorePasswordReset(reset model.PasswordReset) error {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	for hash, r := range data.passwordResets {
+		if r.UserID == reset.UserID {
+			delete(data.passwordResets, hash)
+		}
+	}
+	data.passwordResets[reset.TokenHash] = reset
+	return nil
+}
+
+// ConsumePasswordReset removes and returns the reset stored under hash, so a
+// token can be redeemed only once.
+func (data *Data) ConsumePasswordReset(hash string) (model.PasswordReset, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	reset, ok := data.passwordResets[hash]
+	if !ok {
+		return model.PasswordReset{}, model.ErrRecordNotFound
+	}
+	delete(data.passwordResets, hash)
+	return reset, nil
+}
+
+// ExportUserData returns user userID, their tasks and the categories those
+// tasks are in.
+func (data *Data) ExportUserData(userID int) (model.User, []model.Category, []model.Task, error) {
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+
+	user, ok := data.users[userID]
+	if !ok {
+		return model.User{}, nil, nil, model.ErrRecordNotFound
+	}
+
+	var (
+		categories []model.Category
+		tasks      []model.Task
+	)
+	seen := map[int]bool{}
+	for _, id := range sortedIDs(data.tasks) {
+		task := data.tasks[id]
+		if task.UserID != userID {
+			continue
+		}
+		tasks = append(tasks, task)
+
+		if seen[task.CategoryID] {
+			continue
+		}
+		seen[task.CategoryID] = true
+		if category, ok := data.categories[task.CategoryID]; ok {
+			categories = append(categories, category)
+		}
+	}
+	return copyUser(user), categories, tasks, nil
+}
+
+// ImportUserData adds the categories and tasks of archive to user userID.
+// Archived categories are matched to existing ones by name, and created under
+// a new ID otherwise; tasks always get new IDs. With replace set the user's
+// tasks are deleted first and their full name is taken from the archive. The
+// archive must have been validated: every task's category must be in it.
+func (data *Data) ImportUserData(userID int, archive model.Archive, replace bool) (model.ImportResult, error) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	var result model.ImportResult
+	user, ok := data.users[userID]
+	if !ok {
+		return model.ImportResult{}, model.ErrRecordNotFound
+	}
+	// Checked up front so that running out of IDs halfway changes nothing.
+	if data.sequences["categories"] > model.MaxID-len(archive.Categories) || data.sequences["tasks"] > model.MaxID-len(archive.Tasks) {
+		return model.ImportResult{}, model.ErrIDsExhausted
+	}
+
+	if replace {
+		if archive.Profile.Fullname != "" {
+			user.Fullname = archive.Profile.Fullname
+			data.users[userID] = user
+		}
+		for id, task := range data.tasks {
+			if task.UserID == userID {
+				delete(data.tasks, id)
+				result.TasksRemoved++
+			}
+		}
+	}
+
+	// The lowest ID wins when several categories share a name.
+	byName := map[string]int{}
+	for _, id := range sortedIDs(data.categories) {
+		if _, ok := byName[data.categories[id].Name]; !ok {
+			byName[data.categories[id].Name] = id
+		}
+	}
+
+	categoryIDs := map[int]int{}
+	for _, category := range archive.Categories {
+		if id, ok := byName[category.Name]; ok {
+			categoryIDs[category.ID] = id
+			result.CategoriesMatched++
+			continue
+		}
+
+		id, err := data.nextID("categories", 0, func(id int) bool {
+			_, ok := data.categories[id]
+			return ok
+		})
+		if err != nil {
+			return model.ImportResult{}, err
+		}
+		data.useID("categories", id)
+		data.categories[id] = model.Category{ID: id, Name: category.Name, UserID: userID}
+		byName[category.Name] = id
+		categoryIDs[category.ID] = id
+		result.CategoriesCreated++
+	}
+
+	for _, task := range archive.Tasks {
+		id, err := data.nextID("tasks", 0, func(id int) bool {
+			_, ok := data.tasks[id]
+			return ok
+		})
+		if err != nil {
+			return model.ImportResult{}, err
+		}
+		data.useID("tasks", id)
+		task.ID = id
+		task.UserID = userID
+		task.CategoryID = categoryIDs[task.CategoryID]
+		data.tasks[task.ID] = task
+		result.TasksImported++
+	}
+	return result, nil
+}
@@ -0,0 +1,155 @@
+package sqldb
+
+import (
+	"database/sql"
+
+	"a21hc3NpZ25tZW50/model"
+)
+
+// ExportUserData reads user userID, their tasks and the categories those
+// tasks are in, all from the same transaction.
+func (data *Data) ExportUserData(userID int) (model.User, []model.Category, []model.Task, error) {
+	var (
+		user       model.User
+		categories []model.Category
+		tasks      []model.Task
+	)
+	err := data.view(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, userID))
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		tasks, err = scanTasks(tx.Query(`SELECT `+taskColumns+` FROM tasks WHERE user_id = $1 ORDER BY id`, userID))
+		if err != nil {
+			return err
+		}
+
+		// Categories are listed in the order their first task comes in.
+		// Tasks from before categories were checked may point nowhere.
+		seen := map[int]bool{}
+		for _, task := range tasks {
+			if seen[task.CategoryID] {
+				continue
+			}
+			seen[task.CategoryID] = true
+
+			var category model.Category
+			err := tx.QueryRow(`SELECT id, name FROM categories WHERE id = $1`, task.CategoryID).Scan(&category.ID, &category.Name)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			categories = append(categories, category)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.User{}, nil, nil, err
+	}
+	return user, categories, tasks, nil
+}
+
+// ImportUserData adds the categories and tasks of archive to user userID in a
+// single transaction, so a failed import changes nothing. Archived
+// categories are matched to existing ones by name, and created under a new
+// ID otherwise; tasks always get new IDs. With replace set the user's tasks
+// are deleted first and their full name is taken from the archive. The
+// archive must have been validated: every task's category must be in it.
+func (data *Data) ImportUserData(userID int, archive model.Archive, replace bool) (model.ImportResult, error) {
+	var result model.ImportResult
+	err := data.update(func(tx *sql.Tx) error {
+		found, err := exists(tx, `SELECT 1 FROM users WHERE id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrRecordNotFound
+		}
+
+		if replace {
+			if archive.Profile.Fullname != "" {
+				_, err := tx.Exec(`UPDATE users SET fullname = $1 WHERE id = $2`, archive.Profile.Fullname, userID)
+				if err != nil {
+					return err
+				}
+			}
+
+			deleted, err := tx.Exec(`DELETE FROM tasks WHERE user_id = $1`, userID)
+			if err != nil {
+				return err
+			}
+			n, err := deleted.RowsAffected()
+			if err != nil {
+				return err
+			}
+			result.TasksRemoved = int(n)
+		}
+
+		// The lowest ID wins when several categories share a name.
+		byName := map[string]int{}
+		rows, err := tx.Query(`SELECT id, name FROM categories ORDER BY id`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var category model.Category
+			if err := rows.Scan(&category.ID, &category.Name); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, ok := byName[category.Name]; !ok {
+				byName[category.Name] = category.ID
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		categoryIDs := map[int]int{}
+		for _, category := range archive.Categories {
+			if id, ok := byName[category.Name]; ok {
+				categoryIDs[category.ID] = id
+				result.CategoriesMatched++
+				continue
+			}
+
+			id, err := allocateID(tx, "categories", 0)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO categories (id, name, user_id) VALUES ($1, $2, $3)`, id, category.Name, userID); err != nil {
+				return err
+			}
+			byName[category.Name] = id
+			categoryIDs[category.ID] = id
+			result.CategoriesCreated++
+		}
+
+		for _, task := range archive.Tasks {
+			id, err := allocateID(tx, "tasks", 0)
+			if err != nil {
+				return err
+			}
+			task.ID = id
+			task.UserID = userID
+			task.CategoryID = categoryIDs[task.CategoryID]
+			if err := putTask(tx, task); err != nil {
+				return err
+			}
+			result.TasksImported++
+		}
+		return nil
+	})
+	if err != nil {
+		return model.ImportResult{}, err
+	}
+	return result, nil
+}
@@ -0,0 +1,197 @@
+package sqldb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer
+// binary than this one, which might not understand what it stored.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Migration is one change to the schema, a list of statements run in a
+// single transaction together with its row in schema_migrations.
+type Migration struct {
+	Version     int
+	Description string
+	statements  []string
+}
+
+// migrations lists every migration, oldest first; the version of each is its
+// position counted from 1. Append new ones at the end and never change or
+// reorder one that has been released. The statements must run unchanged on
+// SQLite and PostgreSQL: timestamps are RFC 3339 text and IDs are allocated
+// from the sequences table rather than by the database.
+var migrations = []Migration{
+	{
+		Description: "create users, categories, tasks and sessions",
+		statements: []string{
+			`CREATE TABLE sequences (
+				name  TEXT PRIMARY KEY,
+				value BIGINT NOT NULL
+			)`,
+			`INSERT INTO sequences (name, value) VALUES ('categories', 0), ('tasks', 0), ('sessions', 0)`,
+			`CREATE TABLE users (
+				id                   BIGINT PRIMARY KEY,
+				fullname             TEXT NOT NULL,
+				email                TEXT NOT NULL,
+				password             TEXT NOT NULL,
+				role                 TEXT NOT NULL,
+				created_at           TEXT NOT NULL,
+				updated_at           TEXT NOT NULL,
+				pending_verification BOOLEAN NOT NULL,
+				totp_secret          TEXT NOT NULL,
+				totp_enabled         BOOLEAN NOT NULL,
+				totp_last_step       BIGINT NOT NULL,
+				recovery_code_hashes TEXT NOT NULL,
+				oidc_subject         TEXT NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX users_email ON users (lower(email)) WHERE email <> ''`,
+			`CREATE TABLE categories (
+				id   BIGINT PRIMARY KEY,
+				name TEXT NOT NULL
+			)`,
+			`CREATE TABLE tasks (
+				id          BIGINT PRIMARY KEY,
+				title       TEXT NOT NULL,
+				deadline    TEXT NOT NULL,
+				priority    BIGINT NOT NULL,
+				status      TEXT NOT NULL,
+				category_id BIGINT NOT NULL,
+				user_id     BIGINT NOT NULL
+			)`,
+			`CREATE INDEX tasks_category ON tasks (category_id, id)`,
+			`CREATE INDEX tasks_user ON tasks (user_id, id)`,
+			`CREATE TABLE sessions (
+				token               TEXT PRIMARY KEY,
+				id                  BIGINT NOT NULL,
+				email               TEXT NOT NULL,
+				expiry              TEXT NOT NULL,
+				created_at          TEXT NOT NULL,
+				last_seen_at        TEXT NOT NULL,
+				user_agent          TEXT NOT NULL,
+				ip                  TEXT NOT NULL,
+				refresh_token_hash  TEXT NOT NULL,
+				used_refresh_hashes TEXT NOT NULL
+			)`,
+			`CREATE INDEX sessions_email ON sessions (lower(email), token)`,
+			`CREATE INDEX sessions_refresh_token_hash ON sessions (refresh_token_hash)`,
+		},
+	},
+	{
+		Description: "create access tokens, login attempts and password resets",
+		statements: []string{
+			`INSERT INTO sequences (name, value) VALUES ('access_tokens', 0)`,
+			`CREATE TABLE access_tokens (
+				token_hash   TEXT PRIMARY KEY,
+				id           BIGINT NOT NULL,
+				user_id      BIGINT NOT NULL,
+				email        TEXT NOT NULL,
+				name         TEXT NOT NULL,
+				scope        TEXT NOT NULL,
+				created_at   TEXT NOT NULL,
+				expires_at   TEXT NOT NULL,
+				last_used_at TEXT NOT NULL
+			)`,
+			`CREATE INDEX access_tokens_user ON access_tokens (user_id, id)`,
+			`CREATE TABLE login_attempts (
+				attempt_key     TEXT PRIMARY KEY,
+				failures        BIGINT NOT NULL,
+				last_failure_at TEXT NOT NULL
+			)`,
+			`CREATE TABLE password_resets (
+				token_hash TEXT PRIMARY KEY,
+				user_id    BIGINT NOT NULL,
+				created_at TEXT NOT NULL,
+				expires_at TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		Description: "record the owner of each category",
+		statements: []string{
+			`ALTER TABLE categories ADD COLUMN user_id BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Description: "allocate user IDs from a sequence",
+		statements: []string{
+			`INSERT INTO sequences (name, value) SELECT 'users', COALESCE(MAX(id), 0) FROM users`,
+		},
+	},
+}
+
+// LatestSchemaVersion is the schema version this binary migrates databases
+// to.
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the schema version of the database.
+func (data *Data) SchemaVersion() (int, error) {
+	return schemaVersion(data.DB)
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Migrate creates the schema_migrations table if needed and applies the
+// pending migrations in order, returning the ones it applied. A database
+// newer than this binary is refused with ErrSchemaTooNew before anything is
+// written.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     BIGINT PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %v", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w: database is at version %d, this binary supports up to %d", ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+
+	var applied []Migration
+	for i := current; i < len(migrations); i++ {
+		m := migrations[i]
+		m.Version = i + 1
+		if err := apply(db, m); err != nil {
+			return nil, fmt.Errorf("migration %d (%s): %v", m.Version, m.Description, err)
+		}
+		log.Printf("Database migrated to version %d: %s", m.Version, m.Description)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func apply(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, description, applied_at) VALUES ($1, $2, $3)`,
+		m.Version, m.Description, formatTime(time.Now().UTC()))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,351 @@
+// Package sqldb keeps the application's data in a SQL database through
+// database/sql. It stores the same records as the file store with the same
+// semantics: IDs are allocated the same way, missing records yield
+// model.ErrRecordNotFound and lists come back in ID order.
+//
+// Every statement runs unchanged on PostgreSQL (github.com/lib/pq, driver
+// "postgres") and SQLite (modernc.org/sqlite, driver "sqlite"). The binary
+// only links the PostgreSQL driver; SQLite is used by the tests.
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"a21hc3NpZ25tZW50/model"
+)
+
+type Data struct {
+	DB *sql.DB
+}
+
+// Open connects to the database dsn with driver and applies the pending
+// schema migrations. A database written by a newer binary is refused with
+// ErrSchemaTooNew.
+func Open(driver, dsn string) (*Data, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Data{DB: db}, nil
+}
+
+// DSN builds a PostgreSQL key/value connection string from cred. lib/pq's
+// default sslmode applies.
+func DSN(cred model.Credential) string {
+	params := []string{
+		"host=" + quoteDSN(cred.Host),
+		fmt.Sprintf("port=%d", cred.Port),
+		"user=" + quoteDSN(cred.Username),
+		"password=" + quoteDSN(cred.Password),
+		"dbname=" + quoteDSN(cred.DatabaseName),
+	}
+	if cred.Schema != "" {
+		params = append(params, "search_path="+quoteDSN(cred.Schema))
+	}
+	return strings.Join(params, " ")
+}
+
+// quoteDSN quotes a key/value connection string value as libpq expects.
+func quoteDSN(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
+
+func (data *Data) CloseDB() error {
+	return data.DB.Close()
+}
+
+// update runs fn in a transaction that is committed if fn succeeds and
+// rolled back otherwise.
+func (data *Data) update(fn func(tx *sql.Tx) error) error {
+	tx, err := data.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// view runs fn in a transaction that is always rolled back, so several
+// queries see the same state.
+func (data *Data) view(fn func(tx *sql.Tx) error) error {
+	tx, err := data.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return fn(tx)
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// nextSequence takes the next value of the named sequence, the counterpart
+// of a bucket sequence in the file store.
+func nextSequence(tx *sql.Tx, name string) (int, error) {
+	if _, err := tx.Exec(`UPDATE sequences SET value = value + 1 WHERE name = $1`, name); err != nil {
+		return 0, err
+	}
+	var value int
+	err := tx.QueryRow(`SELECT value FROM sequences WHERE name = $1`, name).Scan(&value)
+	return value, err
+}
+
+// allocateID returns the ID a new row of table should get. A zero requested
+// ID takes the table's next free sequence value; otherwise the requested ID
+// is used if free and the sequence is moved past it so later allocations
+// cannot collide. Requested IDs outside 1..model.MaxID are refused.
+func allocateID(tx *sql.Tx, table string, requested int) (int, error) {
+	if requested == 0 {
+		// Rows written before the sequence was kept may sit ahead of it;
+		// skip them rather than fail on the primary key.
+		for {
+			id, err := nextSequence(tx, table)
+			if err != nil {
+				return 0, err
+			}
+			if id > model.MaxID {
+				return 0, model.ErrIDsExhausted
+			}
+			taken, err := exists(tx, `SELECT 1 FROM `+table+` WHERE id = $1`, id)
+			if err != nil {
+				return 0, err
+			}
+			if !taken {
+				return id, nil
+			}
+		}
+	}
+
+	if requested < 0 || requested > model.MaxID {
+		return 0, model.ErrInvalidID
+	}
+	taken, err := exists(tx, `SELECT 1 FROM `+table+` WHERE id = $1`, requested)
+	if err != nil {
+		return 0, err
+	}
+	if taken {
+		return 0, model.ErrRecordExists
+	}
+	_, err = tx.Exec(`UPDATE sequences SET value = $1 WHERE name = $2 AND value < $1`, requested, table)
+	if err != nil {
+		return 0, err
+	}
+	return requested, nil
+}
+
+// exists reports whether query returns a row.
+func exists(q queryer, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := q.QueryRow(query, args...).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Timestamps are stored as RFC 3339 text, the same form the file store
+// writes them in JSON, so they round-trip exactly on every database.
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+type timeColumn struct{ t *time.Time }
+
+func (c timeColumn) Scan(src interface{}) error {
+	s, err := textValue(src)
+	if err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*c.t = t
+	return nil
+}
+
+// String lists such as recovery code hashes are stored as JSON arrays; an
+// empty list is stored as "[]" and read back as nil.
+
+func formatStrings(list []string) string {
+	if len(list) == 0 {
+		return "[]"
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+type stringsColumn struct{ list *[]string }
+
+func (c stringsColumn) Scan(src interface{}) error {
+	s, err := textValue(src)
+	if err != nil {
+		return err
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		list = nil
+	}
+	*c.list = list
+	return nil
+}
+
+func textValue(src interface{}) (string, error) {
+	switch v := src.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return "", fmt.Errorf("unexpected %T in a text column", src)
+}
+
+const taskColumns = "id, title, deadline, priority, status, category_id, user_id"
+
+func scanTask(row scanner) (model.Task, error) {
+	var task model.Task
+	err := row.Scan(&task.ID, &task.Title, &task.Deadline, &task.Priority, &task.Status, &task.CategoryID, &task.UserID)
+	return task, err
+}
+
+func scanTasks(rows *sql.Rows, err error) ([]model.Task, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []model.Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func putTask(tx *sql.Tx, task model.Task) error {
+	_, err := tx.Exec(`INSERT INTO tasks (`+taskColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, deadline = excluded.deadline,
+			priority = excluded.priority, status = excluded.status,
+			category_id = excluded.category_id, user_id = excluded.user_id`,
+		task.ID, task.Title, task.Deadline, task.Priority, task.Status, task.CategoryID, task.UserID)
+	return err
+}
+
+func categoryExists(q queryer, id int) (bool, error) {
+	return exists(q, `SELECT 1 FROM categories WHERE id = $1`, id)
+}
+
+const userColumns = `id, fullname, email, password, role, created_at, updated_at, pending_verification,
+	totp_secret, totp_enabled, totp_last_step, recovery_code_hashes, oidc_subject`
+
+func scanUser(row scanner) (model.User, error) {
+	var user model.User
+	err := row.Scan(&user.ID, &user.Fullname, &user.Email, &user.Password, &user.Role,
+		timeColumn{&user.CreatedAt}, timeColumn{&user.UpdatedAt}, &user.PendingVerification,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, stringsColumn{&user.RecoveryCodeHashes},
+		&user.OIDCSubject)
+	return user, err
+}
+
+func userArgs(user model.User) []interface{} {
+	return []interface{}{user.ID, user.Fullname, user.Email, user.Password, user.Role,
+		formatTime(user.CreatedAt), formatTime(user.UpdatedAt), user.PendingVerification,
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, formatStrings(user.RecoveryCodeHashes),
+		user.OIDCSubject}
+}
+
+// emailTaken reports whether a user other than userID has email, ignoring
+// case. The unique index on lower(email) enforces the same, but checking
+// first gives model.ErrRecordExists on every driver.
+func emailTaken(tx *sql.Tx, email string, userID int) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	return exists(tx, `SELECT 1 FROM users WHERE lower(email) = $1 AND id <> $2`, strings.ToLower(email), userID)
+}
+
+const sessionColumns = `token, id, email, expiry, created_at, last_seen_at, user_agent, ip,
+	refresh_token_hash, used_refresh_hashes`
+
+func scanSession(row scanner) (model.Session, error) {
+	var session model.Session
+	err := row.Scan(&session.Token, &session.ID, &session.Email, timeColumn{&session.Expiry},
+		timeColumn{&session.CreatedAt}, timeColumn{&session.LastSeenAt}, &session.UserAgent, &session.IP,
+		&session.RefreshTokenHash, stringsColumn{&session.UsedRefreshHashes})
+	return session, err
+}
+
+func scanSessions(rows *sql.Rows, err error) ([]model.Session, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []model.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func putSession(tx *sql.Tx, session model.Session) error {
+	_, err := tx.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (token) DO UPDATE SET id = excluded.id, email = excluded.email, expiry = excluded.expiry,
+			created_at = excluded.created_at, last_seen_at = excluded.last_seen_at,
+			user_agent = excluded.user_agent, ip = excluded.ip,
+			refresh_token_hash = excluded.refresh_token_hash, used_refresh_hashes = excluded.used_refresh_hashes`,
+		session.Token, session.ID, session.Email, formatTime(session.Expiry), formatTime(session.CreatedAt),
+		formatTime(session.LastSeenAt), session.UserAgent, session.IP, session.RefreshTokenHash,
+		formatStrings(session.UsedRefreshHashes))
+	return err
+}
+
+const accessTokenColumns = "token_hash, id, user_id, email, name, scope, created_at, expires_at, last_used_at"
+
+func scanAccessToken(row scanner) (model.PersonalAccessToken, error) {
+	var pat model.PersonalAccessToken
+	err := row.Scan(&pat.TokenHash, &pat.ID, &pat.UserID, &pat.Email, &pat.Name, &pat.Scope,
+		timeColumn{&pat.CreatedAt}, timeColumn{&pat.ExpiresAt}, timeColumn{&pat.LastUsedAt})
+	return pat, err
+}
@@ -0,0 +1,713 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"a21hc3NpZ25tZW50/model"
+)
+
+// StoreTask inserts a new task. When task.ID is zero the next value of the
+// tasks sequence is assigned; a client-supplied ID is accepted only if it is
+// not taken yet. The task's category must exist, otherwise
+// model.ErrCategoryNotFound is returned.
+func (data *Data) StoreTask(task model.Task) (model.Task, error) {
+	err := data.update(func(tx *sql.Tx) error {
+		id, err := allocateID(tx, "tasks", task.ID)
+		if err != nil {
+			return err
+		}
+		task.ID = id
+
+		ok, err := categoryExists(tx, task.CategoryID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return model.ErrCategoryNotFound
+		}
+		return putTask(tx, task)
+	})
+	if err != nil {
+		return model.Task{}, err
+	}
+	return task, nil
+}
+
+// StoreCategory inserts a new category, allocating its ID the same way as
+// StoreTask.
+func (data *Data) StoreCategory(category model.Category) (model.Category, error) {
+	err := data.update(func(tx *sql.Tx) error {
+		id, err := allocateID(tx, "categories", category.ID)
+		if err != nil {
+			return err
+		}
+		category.ID = id
+
+		_, err = tx.Exec(`INSERT INTO categories (id, name, user_id) VALUES ($1, $2, $3)`, category.ID, category.Name, category.UserID)
+		return err
+	})
+	if err != nil {
+		return model.Category{}, err
+	}
+	return category, nil
+}
+
+// UpdateTask replaces the task stored under id. The stored task must belong to
+// task.UserID, otherwise model.ErrRecordNotFound is returned, and the new
+// category must exist, otherwise model.ErrCategoryNotFound is returned.
+func (data *Data) UpdateTask(id int, task model.Task) error {
+	task.ID = id
+	return data.update(func(tx *sql.Tx) error {
+		if _, err := checkTaskOwner(tx, id, task.UserID); err != nil {
+			return err
+		}
+		ok, err := categoryExists(tx, task.CategoryID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return model.ErrCategoryNotFound
+		}
+		return putTask(tx, task)
+	})
+}
+
+// UpdateCategory renames the category stored under id; its owner is kept. An
+// unknown id yields model.ErrRecordNotFound; categories are only created by
+// StoreCategory, so that every ID comes from the sequence.
+func (data *Data) UpdateCategory(id int, category model.Category) error {
+	result, err := data.DB.Exec(`UPDATE categories SET name = $2 WHERE id = $1`, id, category.Name)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// DeleteTask removes the task stored under id if it belongs to userID.
+func (data *Data) DeleteTask(userID, id int) error {
+	return data.update(func(tx *sql.Tx) error {
+		if _, err := checkTaskOwner(tx, id, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM tasks WHERE id = $1`, id)
+		return err
+	})
+}
+
+// checkTaskOwner reads task id. It reports model.ErrRecordNotFound when the
+// task is missing or owned by another user, so callers cannot probe for
+// foreign IDs.
+func checkTaskOwner(tx *sql.Tx, id, userID int) (model.Task, error) {
+	task, err := scanTask(tx.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return model.Task{}, model.ErrRecordNotFound
+	}
+	if err != nil {
+		return model.Task{}, err
+	}
+	if task.UserID != userID {
+		return model.Task{}, model.ErrRecordNotFound
+	}
+	return task, nil
+}
+
+// DeleteCategory removes category id. Its tasks are handled according to
+// mode: CategoryDeleteRestrict fails with model.ErrCategoryInUse if there are
+// any, CategoryDeleteCascade deletes them and CategoryDeleteReassign moves them
+// to category reassignTo, which must exist. An unknown id yields
+// model.ErrRecordNotFound.
+func (data *Data) DeleteCategory(id int, mode model.CategoryDeleteMode, reassignTo int) error {
+	return data.update(func(tx *sql.Tx) error {
+		ok, err := categoryExists(tx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return model.ErrRecordNotFound
+		}
+
+		switch mode {
+		case model.CategoryDeleteRestrict:
+			inUse, err := exists(tx, `SELECT 1 FROM tasks WHERE category_id = $1`, id)
+			if err != nil {
+				return err
+			}
+			if inUse {
+				return model.ErrCategoryInUse
+			}
+		case model.CategoryDeleteCascade:
+			if _, err := tx.Exec(`DELETE FROM tasks WHERE category_id = $1`, id); err != nil {
+				return err
+			}
+		case model.CategoryDeleteReassign:
+			if reassignTo == id {
+				return model.ErrCategoryNotFound
+			}
+			ok, err := categoryExists(tx, reassignTo)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return model.ErrCategoryNotFound
+			}
+			if _, err := tx.Exec(`UPDATE tasks SET category_id = $1 WHERE category_id = $2`, reassignTo, id); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown category delete mode %q", mode)
+		}
+
+		_, err = tx.Exec(`DELETE FROM categories WHERE id = $1`, id)
+		return err
+	})
+}
+
+func (data *Data) GetTaskByID(id int) (*model.Task, error) {
+	task, err := scanTask(data.DB.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, model.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (data *Data) GetCategoryByID(id int) (*model.Category, error) {
+	var category model.Category
+	err := data.DB.QueryRow(`SELECT id, name, user_id FROM categories WHERE id = $1`, id).Scan(&category.ID, &category.Name, &category.UserID)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+func (data *Data) GetTasks() ([]model.Task, error) {
+	tasks, err := scanTasks(data.DB.Query(`SELECT ` + taskColumns + ` FROM tasks ORDER BY id`))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tasks: %v", err)
+	}
+	return tasks, nil
+}
+
+func (data *Data) GetTasksByUser(userID int) ([]model.Task, error) {
+	tasks, err := scanTasks(data.DB.Query(`SELECT `+taskColumns+` FROM tasks WHERE user_id = $1 ORDER BY id`, userID))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tasks: %v", err)
+	}
+	return tasks, nil
+}
+
+func (data *Data) GetCategories() ([]model.Category, error) {
+	rows, err := data.DB.Query(`SELECT id, name, user_id FROM categories ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching categories: %v", err)
+	}
+	defer rows.Close()
+
+	var categories []model.Category
+	for rows.Next() {
+		var category model.Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.UserID); err != nil {
+			return nil, fmt.Errorf("error fetching categories: %v", err)
+		}
+		categories = append(categories, category)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching categories: %v", err)
+	}
+	return categories, nil
+}
+
+func (data *Data) GetTaskListByCategory(userID, categoryID int) ([]model.TaskCategory, error) {
+	category, err := data.GetCategoryByID(categoryID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching category: %v", err)
+	}
+
+	tasks, err := scanTasks(data.DB.Query(`SELECT `+taskColumns+` FROM tasks
+		WHERE category_id = $1 AND user_id = $2 ORDER BY id`, categoryID, userID))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tasks for category %d: %v", categoryID, err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("no tasks found for category ID: %d", categoryID)
+	}
+
+	taskCategories := make([]model.TaskCategory, 0, len(tasks))
+	for _, task := range tasks {
+		taskCategories = append(taskCategories, model.TaskCategory{
+			ID:       task.ID,
+			Title:    task.Title,
+			Category: category.Name,
+		})
+	}
+	return taskCategories, nil
+}
+
+// GetUserByEmail looks the user up ignoring case. An unknown email yields a
+// zero User and no error.
+func (data *Data) GetUserByEmail(email string) (model.User, error) {
+	if email == "" {
+		return model.User{}, nil
+	}
+	user, err := scanUser(data.DB.QueryRow(`SELECT `+userColumns+` FROM users
+		WHERE lower(email) = $1 ORDER BY id LIMIT 1`, strings.ToLower(email)))
+	if err == sql.ErrNoRows {
+		return model.User{}, nil
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// CreateUser inserts user under the next ID of the users sequence, so the ID
+// of a deleted user is never handed out again. An email already taken by
+// another user fails with model.ErrRecordExists.
+func (data *Data) CreateUser(user model.User) (model.User, error) {
+	err := data.update(func(tx *sql.Tx) error {
+		taken, err := emailTaken(tx, user.Email, 0)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return model.ErrRecordExists
+		}
+
+		user.ID, err = allocateID(tx, "users", 0)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO users (`+userColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`, userArgs(user)...)
+		return err
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// GetUserByID returns the user stored under id, or model.ErrRecordNotFound.
+func (data *Data) GetUserByID(id int) (model.User, error) {
+	user, err := scanUser(data.DB.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return model.User{}, model.ErrRecordNotFound
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// UpdateUser replaces the stored record of an existing user.
+func (data *Data) UpdateUser(user model.User) error {
+	return data.update(func(tx *sql.Tx) error {
+		found, err := exists(tx, `SELECT 1 FROM users WHERE id = $1`, user.ID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrRecordNotFound
+		}
+		taken, err := emailTaken(tx, user.Email, user.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return model.ErrRecordExists
+		}
+
+		_, err = tx.Exec(`UPDATE users SET fullname = $2, email = $3, password = $4, role = $5,
+			created_at = $6, updated_at = $7, pending_verification = $8, totp_secret = $9,
+			totp_enabled = $10, totp_last_step = $11, recovery_code_hashes = $12, oidc_subject = $13
+			WHERE id = $1`, userArgs(user)...)
+		return err
+	})
+}
+
+// requireRow turns a statement that changed nothing into
+// model.ErrRecordNotFound.
+func requireRow(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return model.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes user id together with everything that belongs to it:
+// tasks, sessions, access tokens, password resets, the login attempts kept
+// under "email:<address>" and "user:<id>", and the categories it created. A
+// category that tasks of other users are still in is kept without an owner
+// instead. It all happens in one transaction, so a failure leaves the user
+// intact.
+func (data *Data) DeleteUser(id int) error {
+	return data.update(func(tx *sql.Tx) error {
+		user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		statements := []string{
+			`DELETE FROM users WHERE id = $1`,
+			`DELETE FROM tasks WHERE user_id = $1`,
+			`DELETE FROM access_tokens WHERE user_id = $1`,
+			`DELETE FROM password_resets WHERE user_id = $1`,
+			`DELETE FROM categories WHERE user_id = $1
+				AND NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.category_id = categories.id)`,
+			`UPDATE categories SET user_id = 0 WHERE user_id = $1`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement, id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`DELETE FROM login_attempts WHERE attempt_key IN ($1, $2)`,
+			"email:"+strings.ToLower(user.Email), "user:"+strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		return deleteSessionsByEmail(tx, user.Email)
+	})
+}
+
+func (data *Data) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(0)
+}
+
+// GetUserTaskCategoryByUser is GetUserTaskCategory limited to one user.
+func (data *Data) GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error) {
+	return data.userTaskCategory(userID)
+}
+
+// userTaskCategory joins users with their tasks and categories. A userID of 0
+// includes every user.
+func (data *Data) userTaskCategory(userID int) ([]model.UserTaskCategory, error) {
+	rows, err := data.DB.Query(`SELECT u.id, u.fullname, u.email, t.title, t.deadline, t.priority, t.status,
+			COALESCE(c.name, '')
+		FROM users u
+		JOIN tasks t ON t.user_id = u.id
+		LEFT JOIN categories c ON c.id = t.category_id
+		WHERE $1 = 0 OR u.id = $1
+		ORDER BY u.id, t.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []model.UserTaskCategory
+	for rows.Next() {
+		var r model.UserTaskCategory
+		if err := rows.Scan(&r.ID, &r.Fullname, &r.Email, &r.Task, &r.Deadline, &r.Priority, &r.Status, &r.Category); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AddSession stores session under its token, replacing any session already
+// stored there. A session without an ID gets the next value of the sessions
+// sequence so it can be referred to without exposing the token.
+func (data *Data) AddSession(session model.Session) error {
+	return data.update(func(tx *sql.Tx) error {
+		if session.ID == 0 {
+			id, err := nextSequence(tx, "sessions")
+			if err != nil {
+				return err
+			}
+			session.ID = id
+		}
+		return putSession(tx, session)
+	})
+}
+
+func (data *Data) DeleteSession(token string) error {
+	_, err := data.DB.Exec(`DELETE FROM sessions WHERE token = $1`, token)
+	return err
+}
+
+// DeleteSessionsByEmail removes every session that belongs to email.
+func (data *Data) DeleteSessionsByEmail(email string) error {
+	return data.update(func(tx *sql.Tx) error {
+		return deleteSessionsByEmail(tx, email)
+	})
+}
+
+// deleteSessionsByEmail removes the sessions of email. Sessions without an
+// email belong to nobody and are left alone, as in the file store.
+func deleteSessionsByEmail(tx *sql.Tx, email string) error {
+	if email == "" {
+		return nil
+	}
+	_, err := tx.Exec(`DELETE FROM sessions WHERE lower(email) = $1`, strings.ToLower(email))
+	return err
+}
+
+func (data *Data) UpdateSession(session model.Session) error {
+	return data.AddSession(session) // Reuse AddSession as it will overwrite the existing entry
+}
+
+// SessionByRefreshHash finds the session whose refresh token hashes to hash.
+// reused is true when hash belongs to a refresh token that has already been
+// exchanged, i.e. the token is being replayed.
+func (data *Data) SessionByRefreshHash(hash string) (session model.Session, reused bool, err error) {
+	// The LIKE only narrows the candidates down; membership of the used list
+	// is checked below.
+	sessions, err := scanSessions(data.DB.Query(`SELECT `+sessionColumns+` FROM sessions
+		WHERE refresh_token_hash = $1 OR used_refresh_hashes LIKE $2 ORDER BY token`, hash, "%"+hash+"%"))
+	if err != nil {
+		return model.Session{}, false, err
+	}
+
+	for _, s := range sessions {
+		if s.RefreshTokenHash == hash {
+			return s, false, nil
+		}
+		for _, used := range s.UsedRefreshHashes {
+			if used == hash {
+				return s, true, nil
+			}
+		}
+	}
+	return model.Session{}, false, model.ErrRecordNotFound
+}
+
+// RotateSession replaces the session stored under oldToken with session in a
+// single transaction. It fails with model.ErrRecordNotFound unless the stored
+// row still expects refreshHash, so two concurrent refreshes with the same
+// token cannot both succeed.
+func (data *Data) RotateSession(oldToken, refreshHash string, session model.Session) error {
+	return data.update(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM sessions WHERE token = $1 AND refresh_token_hash = $2`, oldToken, refreshHash)
+		if err != nil {
+			return err
+		}
+		if err := requireRow(result); err != nil {
+			return err
+		}
+		return putSession(tx, session)
+	})
+}
+
+// SessionAvailEmail returns the session of email with the lowest token.
+func (data *Data) SessionAvailEmail(email string) (model.Session, error) {
+	session, err := scanSession(data.DB.QueryRow(`SELECT `+sessionColumns+` FROM sessions
+		WHERE lower(email) = $1 AND email <> '' ORDER BY token LIMIT 1`, strings.ToLower(email)))
+	if err == sql.ErrNoRows {
+		return model.Session{}, fmt.Errorf("no session available for email: %s", email) // No session was found for the given email
+	}
+	if err != nil {
+		return model.Session{}, err
+	}
+	return session, nil
+}
+
+// SessionsByEmail returns every session that belongs to email, oldest first.
+func (data *Data) SessionsByEmail(email string) ([]model.Session, error) {
+	return scanSessions(data.DB.Query(`SELECT `+sessionColumns+` FROM sessions
+		WHERE lower(email) = $1 AND email <> '' ORDER BY id`, strings.ToLower(email)))
+}
+
+func (data *Data) SessionAvailToken(token string) (model.Session, error) {
+	session, err := scanSession(data.DB.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token = $1`, token))
+	if err == sql.ErrNoRows {
+		return model.Session{}, fmt.Errorf("no session available for token: %s", token) // No session was found for the given token
+	}
+	if err != nil {
+		return model.Session{}, err
+	}
+	return session, nil
+}
+
+// StoreAccessToken inserts a personal access token under its hash and assigns
+// it the next ID of the access_tokens sequence.
+func (data *Data) StoreAccessToken(pat model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	err := data.update(func(tx *sql.Tx) error {
+		taken, err := exists(tx, `SELECT 1 FROM access_tokens WHERE token_hash = $1`, pat.TokenHash)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return model.ErrRecordExists
+		}
+
+		id, err := nextSequence(tx, "access_tokens")
+		if err != nil {
+			return err
+		}
+		pat.ID = id
+
+		_, err = tx.Exec(`INSERT INTO access_tokens (`+accessTokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			pat.TokenHash, pat.ID, pat.UserID, pat.Email, pat.Name, pat.Scope,
+			formatTime(pat.CreatedAt), formatTime(pat.ExpiresAt), formatTime(pat.LastUsedAt))
+		return err
+	})
+	if err != nil {
+		return model.PersonalAccessToken{}, err
+	}
+	return pat, nil
+}
+
+func (data *Data) UpdateAccessToken(pat model.PersonalAccessToken) error {
+	result, err := data.DB.Exec(`UPDATE access_tokens SET id = $2, user_id = $3, email = $4, name = $5, scope = $6,
+		created_at = $7, expires_at = $8, last_used_at = $9 WHERE token_hash = $1`,
+		pat.TokenHash, pat.ID, pat.UserID, pat.Email, pat.Name, pat.Scope,
+		formatTime(pat.CreatedAt), formatTime(pat.ExpiresAt), formatTime(pat.LastUsedAt))
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+func (data *Data) AccessTokenByHash(hash string) (model.PersonalAccessToken, error) {
+	pat, err := scanAccessToken(data.DB.QueryRow(`SELECT `+accessTokenColumns+` FROM access_tokens WHERE token_hash = $1`, hash))
+	if err == sql.ErrNoRows {
+		return model.PersonalAccessToken{}, model.ErrRecordNotFound
+	}
+	if err != nil {
+		return model.PersonalAccessToken{}, err
+	}
+	return pat, nil
+}
+
+// AccessTokensByUser returns the personal access tokens of userID ordered by
+// ID.
+func (data *Data) AccessTokensByUser(userID int) ([]model.PersonalAccessToken, error) {
+	rows, err := data.DB.Query(`SELECT `+accessTokenColumns+` FROM access_tokens WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching access tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var pats []model.PersonalAccessToken
+	for rows.Next() {
+		pat, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching access tokens: %v", err)
+		}
+		pats = append(pats, pat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching access tokens: %v", err)
+	}
+	return pats, nil
+}
+
+// DeleteAccessToken removes the token with the given ID if it belongs to
+// userID.
+func (data *Data) DeleteAccessToken(userID, id int) error {
+	result, err := data.DB.Exec(`DELETE FROM access_tokens WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	return requireRow(result)
+}
+
+// LoginAttempt returns the failed login counter stored under key. A key
+// without failures yields a zero counter.
+func (data *Data) LoginAttempt(key string) (model.LoginAttempt, error) {
+	attempt, err := loginAttempt(data.DB, key)
+	if err != nil {
+		return model.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+func loginAttempt(q queryer, key string) (model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Key: key}
+	err := q.QueryRow(`SELECT failures, last_failure_at FROM login_attempts WHERE attempt_key = $1`, key).
+		Scan(&attempt.Failures, timeColumn{&attempt.LastFailureAt})
+	if err != nil && err != sql.ErrNoRows {
+		return model.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+// RecordLoginFailure increments the counter stored under key. A counter whose
+// last failure is older than resetAfter starts again from zero.
+func (data *Data) RecordLoginFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error) {
+	var attempt model.LoginAttempt
+	err := data.update(func(tx *sql.Tx) error {
+		var err error
+		attempt, err = loginAttempt(tx, key)
+		if err != nil {
+			return err
+		}
+
+		if now.Sub(attempt.LastFailureAt) > resetAfter {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailureAt = now
+
+		_, err = tx.Exec(`INSERT INTO login_attempts (attempt_key, failures, last_failure_at) VALUES ($1, $2, $3)
+			ON CONFLICT (attempt_key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at`,
+			key, attempt.Failures, formatTime(attempt.LastFailureAt))
+		return err
+	})
+	if err != nil {
+		return model.LoginAttempt{}, err
+	}
+	return attempt, nil
+}
+
+func (data *Data) DeleteLoginAttempt(key string) error {
+	_, err := data.DB.Exec(`DELETE FROM login_attempts WHERE attempt_key = $1`, key)
+	return err
+}
+
+// StorePasswordReset saves reset under its token hash. Any earlier reset of
+// the same user is dropped, so only the most recent link works.
+func (data *Data) StorePasswordReset(reset model.PasswordReset) error {
+	return data.update(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 OR token_hash = $2`, reset.UserID, reset.TokenHash)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO password_resets (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)`,
+			reset.TokenHash, reset.UserID, formatTime(reset.CreatedAt), formatTime(reset.ExpiresAt))
+		return err
+	})
+}
+
+// ConsumePasswordReset removes and returns the reset stored under hash, so a
+// token can be redeemed only once.
+func (data *Data) ConsumePasswordReset(hash string) (model.PasswordReset, error) {
+	var reset model.PasswordReset
+	err := data.update(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT token_hash, user_id, created_at, expires_at FROM password_resets WHERE token_hash = $1`, hash).
+			Scan(&reset.TokenHash, &reset.UserID, timeColumn{&reset.CreatedAt}, timeColumn{&reset.ExpiresAt})
+		if err == sql.ErrNoRows {
+			return model.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM password_resets WHERE token_hash = $1`, hash)
+		return err
+	})
+	if err != nil {
+		return model.PasswordReset{}, err
+	}
+	return reset, nil
+}
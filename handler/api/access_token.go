@@ -0,0 +1,94 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenAPI interface {
+	CreateToken(c *gin.Context)
+	ListTokens(c *gin.Context)
+	RevokeToken(c *gin.Context)
+}
+
+type accessTokenAPI struct {
+	accessTokenService service.AccessTokenService
+}
+
+func NewAccessTokenAPI(accessTokenService service.AccessTokenService) *accessTokenAPI {
+	return &accessTokenAPI{accessTokenService}
+}
+
+func (a *accessTokenAPI) CreateToken(c *gin.Context) {
+	// A leaked token must not be able to mint new ones, so only a logged-in
+	// session may create tokens.
+	if !sessionOnly(c, "create access tokens") {
+		return
+	}
+
+	var request model.AccessTokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	created, err := a.accessTokenService.Create(userIDFromContext(c), c.GetString("email"), request)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidScope) || errors.Is(err, service.ErrExpiryInPast) {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// sessionOnly answers requests authenticated with an access token with 403
+// and reports whether the request may go on. action completes the sentence
+// "access tokens cannot ...".
+func sessionOnly(c *gin.Context, action string) bool {
+	if c.GetString("auth_method") == "access_token" {
+		c.JSON(http.StatusForbidden, model.NewErrorResponse("access tokens cannot "+action))
+		return false
+	}
+	return true
+}
+
+func (a *accessTokenAPI) ListTokens(c *gin.Context) {
+	tokens, err := a.accessTokenService.List(userIDFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if tokens == nil {
+		tokens = []model.PersonalAccessToken{}
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (a *accessTokenAPI) RevokeToken(c *gin.Context) {
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid token ID"))
+		return
+	}
+
+	if err := a.accessTokenService.Revoke(userIDFromContext(c), tokenID); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("access token revoked"))
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxArchiveSize bounds the size of an uploaded archive.
+const maxArchiveSize = 10 << 20
+
+type ArchiveAPI interface {
+	Export(c *gin.Context)
+	Import(c *gin.Context)
+}
+
+type archiveAPI struct {
+	archiveService service.ArchiveService
+}
+
+func NewArchiveAPI(archiveService service.ArchiveService) *archiveAPI {
+	return &archiveAPI{archiveService}
+}
+
+// Export sends the caller's profile, tasks and categories as a zip archive.
+func (a *archiveAPI) Export(c *gin.Context) {
+	archive, err := a.archiveService.Export(userIDFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := service.WriteArchive(&buf, archive); err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	name := fmt.Sprintf("task-tracker-export-%s.zip", archive.ExportedAt.Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// Import reads an archive from the request body, either the zip file from
+// Export or its archive.json alone, and adds it to the caller's data. The mode
+// query parameter is "merge" (the default) or "replace".
+func (a *archiveAPI) Import(c *gin.Context) {
+	mode := model.ImportMode(c.DefaultQuery("mode", string(model.ImportMerge)))
+	if !mode.Valid() {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("mode must be merge or replace"))
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxArchiveSize))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, model.NewErrorResponse("archive is too large"))
+		return
+	}
+
+	archive, err := service.ReadArchive(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	result, err := a.archiveService.Import(userIDFromContext(c), archive, mode)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidArchive) {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
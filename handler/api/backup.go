@@ -0,0 +1,46 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/service"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BackupAPI interface {
+	Download(c *gin.Context)
+}
+
+type backupAPI struct {
+	backupService service.BackupService
+}
+
+func NewBackupAPI(backupService service.BackupService) *backupAPI {
+	return &backupAPI{backupService}
+}
+
+// Download streams a snapshot of the whole database, gzipped when the gzip
+// query parameter is "true".
+func (b *backupAPI) Download(c *gin.Context) {
+	compress := c.Query("gzip") == "true"
+
+	name := "backup-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	contentType := "application/octet-stream"
+	if compress {
+		name += ".gz"
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	c.Status(http.StatusOK)
+
+	// The status is sent with the first bytes, so a failure part way can
+	// only cut the download short.
+	if err := b.backupService.Write(c.Writer, compress); err != nil {
+		log.Printf("Backup download failed: %v", err)
+		c.Abort()
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"a21hc3NpZ25tZW50/model"
 	"a21hc3NpZ25tZW50/service"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -31,13 +32,19 @@ func (ct *categoryAPI) AddCategory(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
 		return
 	}
+	newCategory.UserID = userIDFromContext(c)
 
-	if err := ct.categoryService.Store(&newCategory); err != nil {
+	createdCategory, err := ct.categoryService.Store(&newCategory)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordExists) {
+			c.JSON(http.StatusConflict, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, model.SuccessResponse{Message: "add category success"})
+	c.JSON(http.StatusCreated, createdCategory)
 }
 
 func (ct *categoryAPI) UpdateCategory(c *gin.Context) {
@@ -54,6 +61,10 @@ func (ct *categoryAPI) UpdateCategory(c *gin.Context) {
 	}
 
 	if err := ct.categoryService.Update(categoryID, updatedCategory); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "category update failed"})
 		return
 	}
@@ -68,8 +79,34 @@ func (ct *categoryAPI) DeleteCategory(c *gin.Context) {
 		return
 	}
 
-	if err := ct.categoryService.Delete(categoryID); err != nil {
-		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+	// Without a mode a category that still has tasks is kept, so nothing is
+	// lost by accident.
+	mode := model.CategoryDeleteMode(c.DefaultQuery("mode", string(model.CategoryDeleteRestrict)))
+	if !mode.Valid() {
+		c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "mode must be restrict, cascade or reassign"})
+		return
+	}
+
+	var reassignTo int
+	if mode == model.CategoryDeleteReassign {
+		reassignTo, err = strconv.Atoi(c.Query("reassign_to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "invalid reassign_to category ID"})
+			return
+		}
+	}
+
+	if err := ct.categoryService.Delete(categoryID, mode, reassignTo); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, model.ErrCategoryInUse):
+			c.JSON(http.StatusConflict, model.ErrorResponse{Error: err.Error()})
+		case errors.Is(err, model.ErrCategoryNotFound):
+			c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
+		}
 		return
 	}
 
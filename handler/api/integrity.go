@@ -0,0 +1,42 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type IntegrityAPI interface {
+	Check(c *gin.Context)
+	Quarantine(c *gin.Context)
+}
+
+type integrityAPI struct {
+	integrityService service.IntegrityService
+}
+
+func NewIntegrityAPI(integrityService service.IntegrityService) *integrityAPI {
+	return &integrityAPI{integrityService}
+}
+
+func (i *integrityAPI) Check(c *gin.Context) {
+	report, err := i.integrityService.Check()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (i *integrityAPI) Quarantine(c *gin.Context) {
+	report, err := i.integrityService.Quarantine()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
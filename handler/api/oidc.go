@@ -0,0 +1,106 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/middleware"
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCAPI interface {
+	Login(c *gin.Context)
+	Callback(c *gin.Context)
+}
+
+type oidcAPI struct {
+	oidcService service.OIDCService
+}
+
+func NewOIDCAPI(oidcService service.OIDCService) *oidcAPI {
+	return &oidcAPI{oidcService}
+}
+
+// oidcStateCookie ties the provider's callback to the browser that started
+// the login, so nobody can complete a login into their own account in
+// someone else's browser.
+const oidcStateCookie = "oidc_state"
+
+// loginChallengeCookie hands the web client the challenge of a single sign-on
+// that still needs the account's second factor. It is scoped to the page that
+// asks for the code, so it never travels with other requests.
+const loginChallengeCookie = "login_challenge"
+
+func (o *oidcAPI) Login(c *gin.Context) {
+	authURL, state, err := o.oidcService.Begin()
+	if err != nil {
+		log.Println("Error starting single sign-on:", err)
+		c.JSON(http.StatusBadGateway, model.NewErrorResponse("identity provider is unavailable"))
+		return
+	}
+
+	// Lax, because the cookie has to come along on the provider's redirect
+	// back to the callback.
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/v1/user/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (o *oidcAPI) Callback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, model.NewErrorResponse("single sign-on failed: "+providerError))
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/api/v1/user/oidc", "", true, true)
+	if state == "" || cookieState != state {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(service.ErrInvalidSSOState.Error()))
+		return
+	}
+
+	tokens, err := o.oidcService.Complete(state, c.Query("code"), c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		var secondFactor *service.TwoFactorRequiredError
+		switch {
+		case errors.As(err, &secondFactor):
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     loginChallengeCookie,
+				Value:    secondFactor.Challenge,
+				Path:     "/client/login/2fa",
+				MaxAge:   300,
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			c.Redirect(http.StatusSeeOther, "/client/login/2fa")
+		case errors.Is(err, service.ErrInvalidSSOState):
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		case errors.Is(err, service.ErrSSOEmailUnverified),
+			errors.Is(err, service.ErrSSOAccountMismatch),
+			errors.Is(err, service.ErrInvalidEmail):
+			c.JSON(http.StatusForbidden, model.NewErrorResponse(err.Error()))
+		default:
+			log.Println("Error completing single sign-on:", err)
+			c.JSON(http.StatusUnauthorized, model.NewErrorResponse("single sign-on failed"))
+		}
+		return
+	}
+
+	// The browser arrives here from the provider's site, and Strict cookies
+	// set now would be left off the redirect to the dashboard. The session
+	// is a browser one, so it gets the cookies of the web login.
+	middleware.SetWebSession(c, tokens, http.SameSiteLaxMode)
+	c.Redirect(http.StatusSeeOther, "/client/dashboard")
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordResetAPI interface {
+	ForgotPassword(c *gin.Context)
+	ResetPassword(c *gin.Context)
+}
+
+type passwordResetAPI struct {
+	passwordResetService service.PasswordResetService
+}
+
+func NewPasswordResetAPI(passwordResetService service.PasswordResetService) *passwordResetAPI {
+	return &passwordResetAPI{passwordResetService}
+}
+
+func (p *passwordResetAPI) ForgotPassword(c *gin.Context) {
+	var request model.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := p.passwordResetService.RequestReset(request.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("if the email is registered, a reset link has been sent"))
+}
+
+func (p *passwordResetAPI) ResetPassword(c *gin.Context) {
+	var request model.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := p.passwordResetService.Reset(request.Token, request.Password); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("password updated"))
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"a21hc3NpZ25tZW50/model"
 	"a21hc3NpZ25tZW50/service"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -26,6 +27,17 @@ func NewTaskAPI(taskRepo service.TaskService) *taskAPI {
 	return &taskAPI{taskRepo}
 }
 
+// userIDFromContext returns the ID of the caller that middleware.Auth stored
+// in the gin context.
+func userIDFromContext(c *gin.Context) int {
+	if temp, ok := c.Get("user_id"); ok {
+		if userID, ok := temp.(int); ok {
+			return userID
+		}
+	}
+	return 0
+}
+
 func (ta *taskAPI) AddTask(c *gin.Context) {
 	var newTask model.Task
 	if err := c.ShouldBindJSON(&newTask); err != nil {
@@ -33,13 +45,21 @@ func (ta *taskAPI) AddTask(c *gin.Context) {
 		return
 	}
 
-	err := ta.taskService.Store(&newTask)
+	createdTask, err := ta.taskService.Store(userIDFromContext(c), &newTask)
 	if err != nil {
+		if errors.Is(err, model.ErrRecordExists) {
+			c.JSON(http.StatusConflict, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, model.ErrCategoryNotFound) {
+			c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, model.SuccessResponse{Message: "add task success"})
+	c.JSON(http.StatusCreated, createdTask)
 }
 
 func (ta *taskAPI) UpdateTask(c *gin.Context) {
@@ -55,7 +75,15 @@ func (ta *taskAPI) UpdateTask(c *gin.Context) {
 		return
 	}
 
-	if err := ta.taskService.Update(taskID, &updatedTask); err != nil {
+	if err := ta.taskService.Update(userIDFromContext(c), taskID, &updatedTask); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+			return
+		}
+		if errors.Is(err, model.ErrCategoryNotFound) {
+			c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -70,7 +98,11 @@ func (ta *taskAPI) DeleteTask(c *gin.Context) {
 		return
 	}
 
-	if err := ta.taskService.Delete(taskID); err != nil {
+	if err := ta.taskService.Delete(userIDFromContext(c), taskID); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "delete task failed"})
 		return
 	}
@@ -85,8 +117,12 @@ func (ta *taskAPI) GetTaskByID(c *gin.Context) {
 		return
 	}
 
-	task, err := ta.taskService.GetByID(taskID)
+	task, err := ta.taskService.GetByID(userIDFromContext(c), taskID)
 	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.ErrorResponse{Error: err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
 	}
@@ -95,7 +131,7 @@ func (ta *taskAPI) GetTaskByID(c *gin.Context) {
 }
 
 func (ta *taskAPI) GetTaskList(c *gin.Context) {
-	tasks, err := ta.taskService.GetList()
+	tasks, err := ta.taskService.GetList(userIDFromContext(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
@@ -111,7 +147,7 @@ func (ta *taskAPI) GetTaskListByCategory(c *gin.Context) {
 		return
 	}
 
-	tasks, err := ta.taskService.GetTaskCategory(categoryID)
+	tasks, err := ta.taskService.GetTaskCategory(userIDFromContext(c), categoryID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
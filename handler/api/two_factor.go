@@ -0,0 +1,106 @@
+package api
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorAPI interface {
+	Enroll(c *gin.Context)
+	Confirm(c *gin.Context)
+	Disable(c *gin.Context)
+}
+
+type twoFactorAPI struct {
+	twoFactorService service.TwoFactorService
+}
+
+func NewTwoFactorAPI(twoFactorService service.TwoFactorService) *twoFactorAPI {
+	return &twoFactorAPI{twoFactorService}
+}
+
+func (t *twoFactorAPI) Enroll(c *gin.Context) {
+	// Two-factor settings guard the login itself, so a script's token must
+	// not change them.
+	if !sessionOnly(c, "change two-factor settings") {
+		return
+	}
+
+	enrollment, err := t.twoFactorService.Enroll(userIDFromContext(c))
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorEnabled) {
+			c.JSON(http.StatusConflict, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (t *twoFactorAPI) Confirm(c *gin.Context) {
+	if !sessionOnly(c, "change two-factor settings") {
+		return
+	}
+
+	var request model.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	codes, err := t.twoFactorService.Confirm(userIDFromContext(c), request.Code)
+	if err != nil {
+		var locked *service.LoginLockedError
+		switch {
+		case errors.As(err, &locked):
+			loginError(c, err)
+		case errors.Is(err, service.ErrTwoFactorEnabled):
+			c.JSON(http.StatusConflict, model.NewErrorResponse(err.Error()))
+		case errors.Is(err, service.ErrTwoFactorNotEnrolled), errors.Is(err, service.ErrInvalidTwoFactor):
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.RecoveryCodes{
+		Message:       "two-factor login enabled, store these recovery codes somewhere safe",
+		RecoveryCodes: codes,
+	})
+}
+
+func (t *twoFactorAPI) Disable(c *gin.Context) {
+	if !sessionOnly(c, "change two-factor settings") {
+		return
+	}
+
+	var request model.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := t.twoFactorService.Disable(userIDFromContext(c), request.Code); err != nil {
+		var locked *service.LoginLockedError
+		switch {
+		case errors.As(err, &locked):
+			loginError(c, err)
+		case errors.Is(err, service.ErrTwoFactorNotEnabled):
+			c.JSON(http.StatusConflict, model.NewErrorResponse(err.Error()))
+		case errors.Is(err, service.ErrInvalidTwoFactor):
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("two-factor login disabled"))
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"a21hc3NpZ25tZW50/model"
 	"a21hc3NpZ25tZW50/service"
+	"errors"
+	"math"
 	"net/http"
-	"time"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,15 +14,31 @@ import (
 type UserAPI interface {
 	Register(c *gin.Context)
 	Login(c *gin.Context)
+	LoginSecondFactor(c *gin.Context)
+	Refresh(c *gin.Context)
+	Logout(c *gin.Context)
+	LogoutAll(c *gin.Context)
+	ListSessions(c *gin.Context)
+	RevokeSession(c *gin.Context)
 	GetUserTaskCategory(c *gin.Context)
+	GetOwnTaskCategory(c *gin.Context)
+	GetProfile(c *gin.Context)
+	UpdateProfile(c *gin.Context)
+	ChangePassword(c *gin.Context)
+	DeleteAccount(c *gin.Context)
+	SetRole(c *gin.Context)
+	Unlock(c *gin.Context)
+	VerifyEmail(c *gin.Context)
+	ResendVerification(c *gin.Context)
 }
 
 type userAPI struct {
-	userService service.UserService
+	userService    service.UserService
+	sessionService service.SessionService
 }
 
-func NewUserAPI(userService service.UserService) *userAPI {
-	return &userAPI{userService}
+func NewUserAPI(userService service.UserService, sessionService service.SessionService) *userAPI {
+	return &userAPI{userService, sessionService}
 }
 
 func (u *userAPI) Register(c *gin.Context) {
@@ -44,11 +62,18 @@ func (u *userAPI) Register(c *gin.Context) {
 
 	recordUser, err := u.userService.Register(&recordUser)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		switch {
+		case errors.Is(err, service.ErrInvalidEmail):
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		case errors.Is(err, service.ErrEmailExists):
+			c.JSON(http.StatusConflict, model.NewErrorResponse(err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		}
 		return
 	}
 
-	c.JSON(http.StatusCreated, model.NewSuccessResponse("register success"))
+	c.JSON(http.StatusCreated, model.NewSuccessResponse("register success, check your email to verify your account"))
 }
 
 func (u *userAPI) Login(c *gin.Context) {
@@ -69,23 +94,162 @@ func (u *userAPI) Login(c *gin.Context) {
 		Password: loginRequest.Password,
 	}
 
-	token, err := u.userService.Login(&user)
+	tokens, err := u.userService.Login(&user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		var secondFactor *service.TwoFactorRequiredError
+		if errors.As(err, &secondFactor) {
+			c.JSON(http.StatusAccepted, model.TwoFactorChallenge{Message: err.Error(), Challenge: secondFactor.Challenge})
+			return
+		}
+		loginError(c, err)
+		return
+	}
+
+	setTokenCookies(c, tokens)
+	c.JSON(http.StatusOK, model.LoginResponse{Message: "login success", TokenPair: tokens})
+}
+
+func (u *userAPI) LoginSecondFactor(c *gin.Context) {
+	var request model.TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	tokens, err := u.userService.LoginSecondFactor(request.Challenge, request.Code, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
+		loginError(c, err)
+		return
+	}
+
+	setTokenCookies(c, tokens)
+	c.JSON(http.StatusOK, model.LoginResponse{Message: "login success", TokenPair: tokens})
+}
+
+// loginError answers a failed login step.
+func loginError(c *gin.Context, err error) {
+	var locked *service.LoginLockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, model.NewErrorResponse(err.Error()))
+	case errors.Is(err, service.ErrInvalidCredentials),
+		errors.Is(err, service.ErrInvalidChallenge),
+		errors.Is(err, service.ErrInvalidTwoFactor):
 		c.JSON(http.StatusUnauthorized, model.NewErrorResponse(err.Error()))
+	case errors.Is(err, service.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, model.NewErrorResponse(err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+	}
+}
+
+func (u *userAPI) Refresh(c *gin.Context) {
+	var request model.RefreshRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid decode json"))
+			return
+		}
+	}
+	if request.RefreshToken == "" {
+		request.RefreshToken, _ = c.Cookie("refresh_token")
+	}
+	if request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("refresh token is empty"))
+		return
+	}
+
+	tokens, err := u.userService.Refresh(request.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
 		return
 	}
 
-	cookie := &http.Cookie{
+	setTokenCookies(c, tokens)
+	c.JSON(http.StatusOK, model.LoginResponse{Message: "refresh success", TokenPair: tokens})
+}
+
+// setTokenCookies stores the access token for every path and the refresh
+// token only for the refresh endpoint, each expiring with its token.
+func setTokenCookies(c *gin.Context, tokens model.TokenPair) {
+	http.SetCookie(c.Writer, &http.Cookie{
 		Name:     "session_token",
-		Value:    *token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		Expires:  tokens.ExpiresAt,
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    tokens.RefreshToken,
+		Path:     "/api/v1/user/refresh",
+		Expires:  tokens.RefreshExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearTokenCookies expires both cookies written by setTokenCookies.
+func clearTokenCookies(c *gin.Context) {
+	c.SetCookie("session_token", "", -1, "/", "", false, true)
+	c.SetCookie("refresh_token", "", -1, "/api/v1/user/refresh", "", false, true)
+}
+
+func (u *userAPI) Logout(c *gin.Context) {
+	if err := u.sessionService.Revoke(c.GetString("session_token")); err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	clearTokenCookies(c)
+	c.JSON(http.StatusOK, model.NewSuccessResponse("logout success"))
+}
+
+func (u *userAPI) LogoutAll(c *gin.Context) {
+	if err := u.sessionService.RevokeAll(c.GetString("email")); err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	clearTokenCookies(c)
+	c.JSON(http.StatusOK, model.NewSuccessResponse("logout from all devices success"))
+}
+
+func (u *userAPI) ListSessions(c *gin.Context) {
+	sessions, err := u.sessionService.ListSessions(c.GetString("email"), c.GetString("session_token"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
 	}
-	http.SetCookie(c.Writer, cookie)
 
-	c.JSON(http.StatusOK, gin.H{"message": "login success"})
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (u *userAPI) RevokeSession(c *gin.Context) {
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid session ID"))
+		return
+	}
+
+	if err := u.sessionService.RevokeByID(c.GetString("email"), sessionID); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("session revoked"))
 }
 
 func (u *userAPI) GetUserTaskCategory(c *gin.Context) {
@@ -97,3 +261,197 @@ func (u *userAPI) GetUserTaskCategory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, userTaskCategory)
 }
+
+func (u *userAPI) GetOwnTaskCategory(c *gin.Context) {
+	userTaskCategory, err := u.userService.GetUserTaskCategoryByUser(userIDFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if userTaskCategory == nil {
+		userTaskCategory = []model.UserTaskCategory{}
+	}
+	c.JSON(http.StatusOK, userTaskCategory)
+}
+
+func (u *userAPI) GetProfile(c *gin.Context) {
+	user, err := u.userService.GetByID(userIDFromContext(c))
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+func (u *userAPI) UpdateProfile(c *gin.Context) {
+	if !sessionOnly(c, "change the profile") {
+		return
+	}
+
+	var request model.ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	user, err := u.userService.UpdateProfile(userIDFromContext(c), request, c.GetString("session_token"))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+
+	if user.Email != c.GetString("email") {
+		clearTokenCookies(c)
+	}
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+func (u *userAPI) ChangePassword(c *gin.Context) {
+	if !sessionOnly(c, "change the password") {
+		return
+	}
+
+	var request model.PasswordChangeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	err := u.userService.ChangePassword(userIDFromContext(c), request.CurrentPassword, request.NewPassword, c.GetString("session_token"))
+	if err != nil {
+		profileError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("password changed, other sessions have been signed out"))
+}
+
+func (u *userAPI) DeleteAccount(c *gin.Context) {
+	if !sessionOnly(c, "delete the account") {
+		return
+	}
+
+	var request model.DeleteAccountRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid decode json"))
+			return
+		}
+	}
+
+	if err := u.userService.DeleteAccount(userIDFromContext(c), request.Password, c.GetString("session_token")); err != nil {
+		profileError(c, err)
+		return
+	}
+
+	clearTokenCookies(c)
+	c.JSON(http.StatusOK, model.NewSuccessResponse("account deleted"))
+}
+
+// profileError answers a failed change to the logged-in user's account.
+func profileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidEmail):
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+	case errors.Is(err, service.ErrWrongPassword),
+		errors.Is(err, service.ErrLoginNotFresh):
+		c.JSON(http.StatusForbidden, model.NewErrorResponse(err.Error()))
+	case errors.Is(err, service.ErrEmailExists):
+		c.JSON(http.StatusConflict, model.NewErrorResponse(err.Error()))
+	case errors.Is(err, model.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+	}
+}
+
+func (u *userAPI) SetRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid user ID"))
+		return
+	}
+
+	var request model.RoleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := u.userService.SetRole(userID, request.Role); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRole):
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		case errors.Is(err, model.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("role updated"))
+}
+
+func (u *userAPI) Unlock(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse("invalid user ID"))
+		return
+	}
+
+	if err := u.userService.Unlock(userID); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("account unlocked"))
+}
+
+func (u *userAPI) VerifyEmail(c *gin.Context) {
+	var request model.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := u.userService.VerifyEmail(request.Token); err != nil {
+		if errors.Is(err, service.ErrInvalidVerification) {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("email verified"))
+}
+
+func (u *userAPI) ResendVerification(c *gin.Context) {
+	var request model.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+		return
+	}
+
+	if err := u.userService.ResendVerification(request.Email); err != nil {
+		if errors.Is(err, service.ErrInvalidEmail) {
+			c.JSON(http.StatusBadRequest, model.NewErrorResponse(err.Error()))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, model.NewErrorResponse("error internal server"))
+		return
+	}
+
+	c.JSON(http.StatusOK, model.NewSuccessResponse("if the account is awaiting verification, a new link has been sent"))
+}
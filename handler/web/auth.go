@@ -2,6 +2,8 @@ package web
 
 import (
 	"a21hc3NpZ25tZW50/client"
+	"a21hc3NpZ25tZW50/config"
+	"a21hc3NpZ25tZW50/middleware"
 	"a21hc3NpZ25tZW50/service"
 	"embed"
 	"net/http"
@@ -14,9 +16,19 @@ import (
 type AuthWeb interface {
 	Login(c *gin.Context)
 	LoginProcess(c *gin.Context)
+	LoginSecondFactor(c *gin.Context)
+	LoginSecondFactorProcess(c *gin.Context)
 	Register(c *gin.Context)
 	RegisterProcess(c *gin.Context)
 	Logout(c *gin.Context)
+	LogoutAll(c *gin.Context)
+	ForgotPassword(c *gin.Context)
+	ForgotPasswordProcess(c *gin.Context)
+	ResetPassword(c *gin.Context)
+	ResetPasswordProcess(c *gin.Context)
+	VerifyEmail(c *gin.Context)
+	ResendVerification(c *gin.Context)
+	ResendVerificationProcess(c *gin.Context)
 }
 
 type authWeb struct {
@@ -39,7 +51,9 @@ func (a *authWeb) Login(c *gin.Context) {
 		return
 	}
 
-	err = tmpl.Execute(c.Writer, nil)
+	err = tmpl.Execute(c.Writer, map[string]interface{}{
+		"sso": config.OIDC().Issuer != "",
+	})
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 	}
@@ -49,28 +63,74 @@ func (a *authWeb) LoginProcess(c *gin.Context) {
 	email := c.Request.FormValue("email")
 	password := c.Request.FormValue("password")
 
-	status, err := a.userClient.Login(email, password)
+	status, tokens, challenge, err := a.userClient.Login(email, password, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 		return
 	}
 
-	session, err := a.sessionService.GetSessionByEmail(email)
+	if status == 200 {
+		middleware.SetWebSession(c, tokens, http.SameSiteLaxMode)
+		c.Redirect(http.StatusSeeOther, "/client/dashboard")
+	} else if status == http.StatusAccepted {
+		a.secondFactorForm(c, challenge)
+	} else if status == http.StatusForbidden {
+		c.Redirect(http.StatusSeeOther, "/client/verify/resend")
+	} else if status == http.StatusTooManyRequests {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=too many failed login attempts, try again later")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/client/login")
+	}
+}
+
+// secondFactorForm asks for the code that finishes a two-factor login.
+func (a *authWeb) secondFactorForm(c *gin.Context, challenge string) {
+	var filepath = path.Join("views", "auth", "login_2fa.html")
+	var header = path.Join("views", "general", "header.html")
+
+	var tmpl, err = template.ParseFS(a.embed, filepath, header)
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 		return
 	}
 
-	if status == 200 {
-		http.SetCookie(c.Writer, &http.Cookie{
-			Name:   "session_token",
-			Value:  session.Token,
-			Path:   "/",
-			MaxAge: 31536000,
-			Domain: "",
-		})
+	err = tmpl.Execute(c.Writer, map[string]interface{}{
+		"challenge": challenge,
+	})
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+	}
+}
 
+// LoginSecondFactor asks for the code of a single sign-on that landed on an
+// account with two-factor login. The callback leaves the challenge in a
+// cookie rather than in the URL.
+func (a *authWeb) LoginSecondFactor(c *gin.Context) {
+	challenge, err := c.Cookie("login_challenge")
+	if err != nil || challenge == "" {
+		c.Redirect(http.StatusSeeOther, "/client/login")
+		return
+	}
+	c.SetCookie("login_challenge", "", -1, "/client/login/2fa", "", true, true)
+
+	a.secondFactorForm(c, challenge)
+}
+
+func (a *authWeb) LoginSecondFactorProcess(c *gin.Context) {
+	challenge := c.Request.FormValue("challenge")
+	code := c.Request.FormValue("code")
+
+	status, tokens, err := a.userClient.LoginSecondFactor(challenge, code, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	if status == 200 {
+		middleware.SetWebSession(c, tokens, http.SameSiteLaxMode)
 		c.Redirect(http.StatusSeeOther, "/client/dashboard")
+	} else if status == http.StatusTooManyRequests {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=too many failed login attempts, try again later")
 	} else {
 		c.Redirect(http.StatusSeeOther, "/client/login")
 	}
@@ -111,6 +171,134 @@ func (a *authWeb) RegisterProcess(c *gin.Context) {
 }
 
 func (a *authWeb) Logout(c *gin.Context) {
-	c.SetCookie("session_token", "", -1, "/", "", false, false)
+	if err := a.sessionService.Revoke(c.GetString("session_token")); err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	middleware.ClearWebSession(c)
 	c.Redirect(http.StatusSeeOther, "/client/dashboard")
 }
+
+func (a *authWeb) LogoutAll(c *gin.Context) {
+	if err := a.sessionService.RevokeAll(c.GetString("email")); err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	middleware.ClearWebSession(c)
+	c.Redirect(http.StatusSeeOther, "/client/dashboard")
+}
+
+func (a *authWeb) ForgotPassword(c *gin.Context) {
+	var filepath = path.Join("views", "auth", "forgot_password.html")
+	var header = path.Join("views", "general", "header.html")
+
+	var tmpl, err = template.ParseFS(a.embed, filepath, header)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	err = tmpl.Execute(c.Writer, nil)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+	}
+}
+
+func (a *authWeb) ForgotPasswordProcess(c *gin.Context) {
+	email := c.Request.FormValue("email")
+
+	status, err := a.userClient.ForgotPassword(email)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	if status == 200 {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=success&message=If the email is registered, a reset link has been sent")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=Password reset failed!")
+	}
+}
+
+func (a *authWeb) ResetPassword(c *gin.Context) {
+	var filepath = path.Join("views", "auth", "reset_password.html")
+	var header = path.Join("views", "general", "header.html")
+
+	var tmpl, err = template.ParseFS(a.embed, filepath, header)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	err = tmpl.Execute(c.Writer, map[string]interface{}{
+		"token": c.Query("token"),
+	})
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+	}
+}
+
+func (a *authWeb) ResetPasswordProcess(c *gin.Context) {
+	token := c.Request.FormValue("token")
+	password := c.Request.FormValue("password")
+
+	status, err := a.userClient.ResetPassword(token, password)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	if status == 200 {
+		c.Redirect(http.StatusSeeOther, "/client/login")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=The reset link is invalid or has expired")
+	}
+}
+
+func (a *authWeb) VerifyEmail(c *gin.Context) {
+	status, err := a.userClient.VerifyEmail(c.Query("token"))
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	if status == 200 {
+		c.Redirect(http.StatusSeeOther, "/client/login")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=The verification link is invalid or has expired")
+	}
+}
+
+func (a *authWeb) ResendVerification(c *gin.Context) {
+	var filepath = path.Join("views", "auth", "resend_verification.html")
+	var header = path.Join("views", "general", "header.html")
+
+	var tmpl, err = template.ParseFS(a.embed, filepath, header)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	err = tmpl.Execute(c.Writer, nil)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+	}
+}
+
+func (a *authWeb) ResendVerificationProcess(c *gin.Context) {
+	email := c.Request.FormValue("email")
+
+	status, err := a.userClient.ResendVerification(email)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
+		return
+	}
+
+	if status == 200 {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=success&message=If the account is awaiting verification, a new link has been sent")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message=Invalid email address")
+	}
+}
@@ -3,7 +3,6 @@ package web
 import (
 	"a21hc3NpZ25tZW50/client"
 	"a21hc3NpZ25tZW50/model"
-	"a21hc3NpZ25tZW50/service"
 	"embed"
 	"net/http"
 	"path"
@@ -18,12 +17,11 @@ type CategoryWeb interface {
 
 type categoryWeb struct {
 	categoryClient client.CategoryClient
-	sessionService service.SessionService
 	embed          embed.FS
 }
 
-func NewCategoryWeb(categoryClient client.CategoryClient, sessionService service.SessionService, embed embed.FS) *categoryWeb {
-	return &categoryWeb{categoryClient, sessionService, embed}
+func NewCategoryWeb(categoryClient client.CategoryClient, embed embed.FS) *categoryWeb {
+	return &categoryWeb{categoryClient, embed}
 }
 
 func (c *categoryWeb) Category(ctx *gin.Context) {
@@ -34,13 +32,9 @@ func (c *categoryWeb) Category(ctx *gin.Context) {
 		}
 	}
 
-	session, err := c.sessionService.GetSessionByEmail(email)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
-		return
-	}
+	token := ctx.GetString("session_token")
 
-	categories, err := c.categoryClient.CategoryList(session.Token)
+	categories, err := c.categoryClient.CategoryList(token)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: err.Error()})
 		return
@@ -2,7 +2,7 @@ package web
 
 import (
 	"a21hc3NpZ25tZW50/client"
-	"a21hc3NpZ25tZW50/service"
+	"a21hc3NpZ25tZW50/model"
 	"embed"
 	"net/http"
 	"path"
@@ -16,13 +16,12 @@ type DashboardWeb interface {
 }
 
 type dashboardWeb struct {
-	userClient     client.UserClient
-	sessionService service.SessionService
-	embed          embed.FS
+	userClient client.UserClient
+	embed      embed.FS
 }
 
-func NewDashboardWeb(userClient client.UserClient, sessionService service.SessionService, embed embed.FS) *dashboardWeb {
-	return &dashboardWeb{userClient, sessionService, embed}
+func NewDashboardWeb(userClient client.UserClient, embed embed.FS) *dashboardWeb {
+	return &dashboardWeb{userClient, embed}
 }
 
 func (d *dashboardWeb) Dashboard(c *gin.Context) {
@@ -33,13 +32,16 @@ func (d *dashboardWeb) Dashboard(c *gin.Context) {
 		}
 	}
 
-	session, err := d.sessionService.GetSessionByEmail(email)
-	if err != nil {
-		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
-		return
+	token := c.GetString("session_token")
+
+	// Admins get the overview of every user; the API enforces this, the role
+	// from the session token only decides which view to ask for.
+	getUserTaskCategory := d.userClient.GetOwnTaskCategory
+	if c.GetString("role") == model.RoleAdmin {
+		getUserTaskCategory = d.userClient.GetUserTaskCategory
 	}
 
-	userTaskCategories, err := d.userClient.GetUserTaskCategory(session.Token)
+	userTaskCategories, err := getUserTaskCategory(token)
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 		return
@@ -3,7 +3,6 @@ package web
 import (
 	"a21hc3NpZ25tZW50/client"
 	"a21hc3NpZ25tZW50/model"
-	"a21hc3NpZ25tZW50/service"
 	"embed"
 	"net/http"
 	"path"
@@ -19,13 +18,12 @@ type TaskWeb interface {
 }
 
 type taskWeb struct {
-	taskClient     client.TaskClient
-	sessionService service.SessionService
-	embed          embed.FS
+	taskClient client.TaskClient
+	embed      embed.FS
 }
 
-func NewTaskWeb(taskClient client.TaskClient, sessionService service.SessionService, embed embed.FS) *taskWeb {
-	return &taskWeb{taskClient, sessionService, embed}
+func NewTaskWeb(taskClient client.TaskClient, embed embed.FS) *taskWeb {
+	return &taskWeb{taskClient, embed}
 }
 
 func (t *taskWeb) TaskPage(c *gin.Context) {
@@ -36,13 +34,9 @@ func (t *taskWeb) TaskPage(c *gin.Context) {
 		}
 	}
 
-	session, err := t.sessionService.GetSessionByEmail(email)
-	if err != nil {
-		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
-		return
-	}
+	token := c.GetString("session_token")
 
-	tasks, err := t.taskClient.TaskList(session.Token)
+	tasks, err := t.taskClient.TaskList(token)
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 		return
@@ -75,32 +69,19 @@ func (t *taskWeb) TaskPage(c *gin.Context) {
 }
 
 func (t *taskWeb) TaskAddProcess(c *gin.Context) {
-	var email string
-	if temp, ok := c.Get("email"); ok {
-		if contextData, ok := temp.(string); ok {
-			email = contextData
-		}
-	}
-
-	session, err := t.sessionService.GetSessionByEmail(email)
-	if err != nil {
-		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
-		return
-	}
+	token := c.GetString("session_token")
 
 	priority, _ := strconv.Atoi(c.Request.FormValue("priority"))
 	categoryID, _ := strconv.Atoi(c.Request.FormValue("category_id"))
-	userID, _ := strconv.Atoi(c.Request.FormValue("user_id"))
 	task := model.Task{
 		Title:      c.Request.FormValue("title"),
 		Deadline:   c.Request.FormValue("deadline"),
 		Priority:   priority,
 		Status:     c.Request.FormValue("status"),
 		CategoryID: categoryID,
-		UserID:     userID,
 	}
 
-	status, err := t.taskClient.AddTask(session.Token, task)
+	status, err := t.taskClient.AddTask(token, task)
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/client/modal?status=error&message="+err.Error())
 		return
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+type logMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewLogMailer writes every message to w instead of delivering it, or to the
+// standard logger if w is nil. It is meant for development and tests.
+func NewLogMailer(w io.Writer, from string) *logMailer {
+	return &logMailer{w: w, from: from}
+}
+
+func (l *logMailer) Send(msg Message) error {
+	raw := formatMessage(l.from, msg)
+	if l.w == nil {
+		log.Printf("mail to %s:\n%s", msg.To, raw)
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(append(raw, "\r\n\r\n"...))
+	return err
+}
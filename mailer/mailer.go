@@ -0,0 +1,37 @@
+// Package mailer delivers the emails the application sends, such as password
+// reset links.
+package mailer
+
+import (
+	"a21hc3NpZ25tZW50/config"
+	"fmt"
+	"os"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the Mailer selected by cfg: SMTP when a host is configured, a
+// log of messages otherwise.
+func New(cfg config.MailConfig) (Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	}
+
+	if cfg.LogFile != "" {
+		f, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("open mail log: %v", err)
+		}
+		return NewLogMailer(f, cfg.From), nil
+	}
+
+	return NewLogMailer(nil, cfg.From), nil
+}
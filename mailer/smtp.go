@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer sends mail through the SMTP server at host:port. PLAIN
+// authentication is used when username is set; net/smtp only allows it over
+// TLS or to localhost.
+func NewSMTPMailer(host, port, username, password, from string) *smtpMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{net.JoinHostPort(host, port), auth, from}
+}
+
+func (s *smtpMailer) Send(msg Message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, formatMessage(s.from, msg))
+}
+
+// stripNewlines keeps header values on one line so they cannot inject
+// further headers.
+var stripNewlines = strings.NewReplacer("\r", "", "\n", "")
+
+// formatMessage renders msg as a plain text RFC 5322 message.
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + stripNewlines.Replace(from) + "\r\n")
+	b.WriteString("To: " + stripNewlines.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + stripNewlines.Replace(msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
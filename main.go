@@ -2,15 +2,23 @@ package main
 
 import (
 	"a21hc3NpZ25tZW50/client"
+	"a21hc3NpZ25tZW50/config"
 	"a21hc3NpZ25tZW50/db/filebased"
+	"a21hc3NpZ25tZW50/db/memory"
+	"a21hc3NpZ25tZW50/db/sqldb"
 	"a21hc3NpZ25tZW50/handler/api"
 	"a21hc3NpZ25tZW50/handler/web"
+	"a21hc3NpZ25tZW50/mailer"
 	"a21hc3NpZ25tZW50/middleware"
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/oidc"
 	repo "a21hc3NpZ25tZW50/repository"
 	"a21hc3NpZ25tZW50/service"
 	"embed"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -21,9 +29,16 @@ import (
 )
 
 type APIHandler struct {
-	UserAPIHandler     api.UserAPI
-	CategoryAPIHandler api.CategoryAPI
-	TaskAPIHandler     api.TaskAPI
+	UserAPIHandler          api.UserAPI
+	CategoryAPIHandler      api.CategoryAPI
+	TaskAPIHandler          api.TaskAPI
+	AccessTokenAPIHandler   api.AccessTokenAPI
+	PasswordResetAPIHandler api.PasswordResetAPI
+	TwoFactorAPIHandler     api.TwoFactorAPI
+	OIDCAPIHandler          api.OIDCAPI
+	BackupAPIHandler        api.BackupAPI
+	ArchiveAPIHandler       api.ArchiveAPI
+	IntegrityAPIHandler     api.IntegrityAPI
 }
 
 type ClientHandler struct {
@@ -39,6 +54,20 @@ type ClientHandler struct {
 var Resources embed.FS
 
 func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	storageConfig, err := serverStorage(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	gin.SetMode(gin.ReleaseMode) //release
 
 	wg := sync.WaitGroup{}
@@ -58,14 +87,13 @@ func main() {
 		}))
 		router.Use(gin.Recovery())
 
-		filebasedDb, err := filebased.InitDB()
-
+		db, err := OpenStorage(storageConfig)
 		if err != nil {
 			panic(err)
 		}
 
-		router = RunServer(router, filebasedDb)
-		router = RunClient(router, Resources, filebasedDb)
+		router = RunServer(router, db)
+		router = RunClient(router, Resources, db)
 
 		PORT := "8080"
 		fmt.Printf("Server is running on port %v\n\n`http://localhost:%v`", PORT, PORT)
@@ -79,24 +107,108 @@ func main() {
 	wg.Wait()
 }
 
-func RunServer(gin *gin.Engine, filebasedDb *filebased.Data) *gin.Engine {
-	userRepo := repo.NewUserRepo(filebasedDb)
-	sessionRepo := repo.NewSessionsRepo(filebasedDb)
-	categoryRepo := repo.NewCategoryRepo(filebasedDb)
-	taskRepo := repo.NewTaskRepo(filebasedDb)
+// OpenStorage opens the database cfg selects and brings its schema up to
+// date.
+func OpenStorage(cfg config.StorageConfig) (repo.Storage, error) {
+	switch cfg.Backend {
+	case config.StorageSQL:
+		dsn := cfg.URL
+		if dsn == "" {
+			dsn = sqldb.DSN(cfg.Credential)
+		}
+		return sqldb.Open(cfg.Driver, dsn)
+	case config.StorageFile:
+		return filebased.Open(cfg.Path)
+	case config.StorageMemory:
+		return memory.New(), nil
+	}
+	return nil, fmt.Errorf("unknown storage %q", cfg.Backend)
+}
+
+func RunServer(gin *gin.Engine, db repo.Storage) *gin.Engine {
+	// The login lockout is kept per client IP, so X-Forwarded-For is only
+	// believed from the proxies named in TRUSTED_PROXIES.
+	if err := gin.SetTrustedProxies(config.TrustedProxies()); err != nil {
+		panic(err)
+	}
+
+	jwtConfig, err := config.JWT()
+	if err != nil {
+		panic(err)
+	}
+	tokenService := service.NewTokenService(jwtConfig)
+
+	userRepo := repo.NewUserRepo(db)
+	sessionRepo := repo.NewSessionsRepo(db)
+	categoryRepo := repo.NewCategoryRepo(db)
+	taskRepo := repo.NewTaskRepo(db)
+	accessTokenRepo := repo.NewAccessTokenRepo(db)
+	loginAttemptRepo := repo.NewLoginAttemptRepo(db)
+	passwordResetRepo := repo.NewPasswordResetRepo(db)
+	archiveRepo := repo.NewArchiveRepo(db)
+
+	mail, err := mailer.New(config.Mail())
+	if err != nil {
+		panic(err)
+	}
 
-	userService := service.NewUserService(userRepo, sessionRepo)
+	userService := service.NewUserService(userRepo, sessionRepo, loginAttemptRepo, accessTokenRepo, tokenService, mail)
+	sessionService := service.NewSessionService(sessionRepo)
+	accessTokenService := service.NewAccessTokenService(accessTokenRepo)
 	categoryService := service.NewCategoryService(categoryRepo)
 	taskService := service.NewTaskService(taskRepo)
+	passwordResetService := service.NewPasswordResetService(userRepo, passwordResetRepo, sessionRepo, loginAttemptRepo, mail)
+	twoFactorService := service.NewTwoFactorService(userRepo, loginAttemptRepo)
+	archiveService := service.NewArchiveService(archiveRepo)
 
-	userAPIHandler := api.NewUserAPI(userService)
+	if admin := config.Admin(); admin.Email != "" {
+		if err := userService.EnsureAdmin(admin.Fullname, admin.Email, admin.Password); err != nil {
+			panic(err)
+		}
+	}
+
+	userAPIHandler := api.NewUserAPI(userService, sessionService)
 	categoryAPIHandler := api.NewCategoryAPI(categoryService)
 	taskAPIHandler := api.NewTaskAPI(taskService)
+	accessTokenAPIHandler := api.NewAccessTokenAPI(accessTokenService)
+	passwordResetAPIHandler := api.NewPasswordResetAPI(passwordResetService)
+	twoFactorAPIHandler := api.NewTwoFactorAPI(twoFactorService)
+	archiveAPIHandler := api.NewArchiveAPI(archiveService)
 
 	apiHandler := APIHandler{
-		UserAPIHandler:     userAPIHandler,
-		CategoryAPIHandler: categoryAPIHandler,
-		TaskAPIHandler:     taskAPIHandler,
+		UserAPIHandler:          userAPIHandler,
+		CategoryAPIHandler:      categoryAPIHandler,
+		TaskAPIHandler:          taskAPIHandler,
+		AccessTokenAPIHandler:   accessTokenAPIHandler,
+		PasswordResetAPIHandler: passwordResetAPIHandler,
+		TwoFactorAPIHandler:     twoFactorAPIHandler,
+		ArchiveAPIHandler:       archiveAPIHandler,
+	}
+
+	// Snapshots and integrity checks are only offered by storage that
+	// supports them; a SQL database has its own tools for both.
+	if snapshotter, ok := db.(repo.Snapshotter); ok {
+		backupService := service.NewBackupService(repo.NewBackupRepo(snapshotter))
+
+		backupConfig, err := config.Backup()
+		if err != nil {
+			panic(err)
+		}
+		if backupConfig.Dir != "" {
+			backupService.Schedule(backupConfig)
+		}
+
+		apiHandler.BackupAPIHandler = api.NewBackupAPI(backupService)
+	}
+	if checker, ok := db.(repo.IntegrityChecker); ok {
+		integrityService := service.NewIntegrityService(repo.NewIntegrityRepo(checker))
+		apiHandler.IntegrityAPIHandler = api.NewIntegrityAPI(integrityService)
+	}
+
+	// Single sign-on is only offered when an identity provider is configured.
+	if oidcConfig := config.OIDC(); oidcConfig.Issuer != "" {
+		oidcService := service.NewOIDCService(oidc.NewProvider(oidcConfig), userService)
+		apiHandler.OIDCAPIHandler = api.NewOIDCAPI(oidcService)
 	}
 
 	version := gin.Group("/api/v1")
@@ -104,15 +216,40 @@ func RunServer(gin *gin.Engine, filebasedDb *filebased.Data) *gin.Engine {
 		user := version.Group("/user")
 		{
 			user.POST("/login", apiHandler.UserAPIHandler.Login)
+			user.POST("/login/2fa", apiHandler.UserAPIHandler.LoginSecondFactor)
 			user.POST("/register", apiHandler.UserAPIHandler.Register)
-
-			user.Use(middleware.Auth()) // endpoints that require tokens from this endpoint group
-			user.GET("/tasks", apiHandler.UserAPIHandler.GetUserTaskCategory)
+			user.POST("/refresh", apiHandler.UserAPIHandler.Refresh)
+			user.POST("/verify", apiHandler.UserAPIHandler.VerifyEmail)
+			user.POST("/verify/resend", apiHandler.UserAPIHandler.ResendVerification)
+			user.POST("/password/forgot", apiHandler.PasswordResetAPIHandler.ForgotPassword)
+			user.POST("/password/reset", apiHandler.PasswordResetAPIHandler.ResetPassword)
+			if apiHandler.OIDCAPIHandler != nil {
+				user.GET("/oidc/login", apiHandler.OIDCAPIHandler.Login)
+				user.GET("/oidc/callback", apiHandler.OIDCAPIHandler.Callback)
+			}
+
+			user.Use(middleware.Auth(tokenService, sessionService, accessTokenService)) // endpoints that require tokens from this endpoint group
+			user.GET("/tasks", middleware.RequireRole(userService, model.RoleAdmin), apiHandler.UserAPIHandler.GetUserTaskCategory)
+			user.GET("/tasks/me", apiHandler.UserAPIHandler.GetOwnTaskCategory)
+			user.GET("/me", apiHandler.UserAPIHandler.GetProfile)
+			user.PUT("/me", apiHandler.UserAPIHandler.UpdateProfile)
+			user.DELETE("/me", apiHandler.UserAPIHandler.DeleteAccount)
+			user.PUT("/me/password", apiHandler.UserAPIHandler.ChangePassword)
+			user.POST("/logout", apiHandler.UserAPIHandler.Logout)
+			user.POST("/logout/all", apiHandler.UserAPIHandler.LogoutAll)
+			user.GET("/sessions", apiHandler.UserAPIHandler.ListSessions)
+			user.DELETE("/sessions/:id", apiHandler.UserAPIHandler.RevokeSession)
+			user.POST("/tokens", apiHandler.AccessTokenAPIHandler.CreateToken)
+			user.GET("/tokens", apiHandler.AccessTokenAPIHandler.ListTokens)
+			user.DELETE("/tokens/:id", apiHandler.AccessTokenAPIHandler.RevokeToken)
+			user.POST("/2fa/enroll", apiHandler.TwoFactorAPIHandler.Enroll)
+			user.POST("/2fa/confirm", apiHandler.TwoFactorAPIHandler.Confirm)
+			user.POST("/2fa/disable", apiHandler.TwoFactorAPIHandler.Disable)
 		}
 
 		task := version.Group("/task")
 		{
-			task.Use(middleware.Auth()) // endpoints that require tokens from this endpoint group
+			task.Use(middleware.Auth(tokenService, sessionService, accessTokenService)) // endpoints that require tokens from this endpoint group
 			task.POST("/add", apiHandler.TaskAPIHandler.AddTask)
 			task.GET("/get/:id", apiHandler.TaskAPIHandler.GetTaskByID)
 			task.PUT("/update/:id", apiHandler.TaskAPIHandler.UpdateTask)
@@ -123,20 +260,59 @@ func RunServer(gin *gin.Engine, filebasedDb *filebased.Data) *gin.Engine {
 
 		category := version.Group("/category")
 		{
-			category.Use(middleware.Auth()) // endpoints that require tokens from this endpoint group
-			category.POST("/add", apiHandler.CategoryAPIHandler.AddCategory)
+			category.Use(middleware.Auth(tokenService, sessionService, accessTokenService)) // endpoints that require tokens from this endpoint group
+			category.POST("/add", middleware.RequireRole(userService, model.RoleAdmin), apiHandler.CategoryAPIHandler.AddCategory)
 			category.GET("/get/:id", apiHandler.CategoryAPIHandler.GetCategoryByID)
-			category.PUT("/update/:id", apiHandler.CategoryAPIHandler.UpdateCategory)
-			category.DELETE("/delete/:id", apiHandler.CategoryAPIHandler.DeleteCategory)
+			category.PUT("/update/:id", middleware.RequireRole(userService, model.RoleAdmin), apiHandler.CategoryAPIHandler.UpdateCategory)
+			category.DELETE("/delete/:id", middleware.RequireRole(userService, model.RoleAdmin), apiHandler.CategoryAPIHandler.DeleteCategory)
 			category.GET("/list", apiHandler.CategoryAPIHandler.GetCategoryList)
 		}
+
+		archive := version.Group("")
+		{
+			archive.Use(middleware.Auth(tokenService, sessionService, accessTokenService)) // endpoints that require tokens from this endpoint group
+			archive.GET("/export", apiHandler.ArchiveAPIHandler.Export)
+			archive.POST("/import", apiHandler.ArchiveAPIHandler.Import)
+		}
+
+		admin := version.Group("/admin")
+		{
+			admin.Use(middleware.Auth(tokenService, sessionService, accessTokenService), middleware.RequireRole(userService, model.RoleAdmin))
+			admin.PUT("/users/:id/role", apiHandler.UserAPIHandler.SetRole)
+			admin.DELETE("/users/:id/lockout", apiHandler.UserAPIHandler.Unlock)
+			if apiHandler.BackupAPIHandler != nil {
+				admin.GET("/backup", apiHandler.BackupAPIHandler.Download)
+			}
+			if apiHandler.IntegrityAPIHandler != nil {
+				admin.GET("/integrity", apiHandler.IntegrityAPIHandler.Check)
+				admin.POST("/integrity/quarantine", apiHandler.IntegrityAPIHandler.Quarantine)
+			}
+		}
 	}
 
 	return gin
 }
 
-func RunClient(gin *gin.Engine, embed embed.FS, filebasedDb *filebased.Data) *gin.Engine {
-	sessionRepo := repo.NewSessionsRepo(filebasedDb)
+func RunClient(gin *gin.Engine, embed embed.FS, db repo.Storage) *gin.Engine {
+	jwtConfig, err := config.JWT()
+	if err != nil {
+		panic(err)
+	}
+	tokenService := service.NewTokenService(jwtConfig)
+
+	userRepo := repo.NewUserRepo(db)
+	sessionRepo := repo.NewSessionsRepo(db)
+	loginAttemptRepo := repo.NewLoginAttemptRepo(db)
+	accessTokenRepo := repo.NewAccessTokenRepo(db)
+
+	mail, err := mailer.New(config.Mail())
+	if err != nil {
+		panic(err)
+	}
+
+	// The pages refresh an expired access token themselves, which needs the
+	// user service rather than the API.
+	userService := service.NewUserService(userRepo, sessionRepo, loginAttemptRepo, accessTokenRepo, tokenService, mail)
 	sessionService := service.NewSessionService(sessionRepo)
 
 	userClient := client.NewUserClient()
@@ -146,9 +322,9 @@ func RunClient(gin *gin.Engine, embed embed.FS, filebasedDb *filebased.Data) *gi
 	authWeb := web.NewAuthWeb(userClient, sessionService, embed)
 	modalWeb := web.NewModalWeb(embed)
 	homeWeb := web.NewHomeWeb(embed)
-	dashboardWeb := web.NewDashboardWeb(userClient, sessionService, embed)
-	taskWeb := web.NewTaskWeb(taskClient, sessionService, embed)
-	categoryWeb := web.NewCategoryWeb(categoryClient, sessionService, embed)
+	dashboardWeb := web.NewDashboardWeb(userClient, embed)
+	taskWeb := web.NewTaskWeb(taskClient, embed)
+	categoryWeb := web.NewCategoryWeb(categoryClient, embed)
 
 	client := ClientHandler{
 		authWeb, homeWeb, dashboardWeb, taskWeb, categoryWeb, modalWeb,
@@ -162,16 +338,26 @@ func RunClient(gin *gin.Engine, embed embed.FS, filebasedDb *filebased.Data) *gi
 	{
 		user.GET("/login", client.AuthWeb.Login)
 		user.POST("/login/process", client.AuthWeb.LoginProcess)
+		user.GET("/login/2fa", client.AuthWeb.LoginSecondFactor)
+		user.POST("/login/2fa/process", client.AuthWeb.LoginSecondFactorProcess)
 		user.GET("/register", client.AuthWeb.Register)
 		user.POST("/register/process", client.AuthWeb.RegisterProcess)
-
-		user.Use(middleware.Auth()) // endpoints that require tokens from this endpoint group
+		user.GET("/password/forgot", client.AuthWeb.ForgotPassword)
+		user.POST("/password/forgot/process", client.AuthWeb.ForgotPasswordProcess)
+		user.GET("/password/reset", client.AuthWeb.ResetPassword)
+		user.POST("/password/reset/process", client.AuthWeb.ResetPasswordProcess)
+		user.GET("/verify", client.AuthWeb.VerifyEmail)
+		user.GET("/verify/resend", client.AuthWeb.ResendVerification)
+		user.POST("/verify/resend/process", client.AuthWeb.ResendVerificationProcess)
+
+		user.Use(middleware.WebAuth(tokenService, sessionService, userService)) // endpoints that require tokens from this endpoint group
 		user.GET("/logout", client.AuthWeb.Logout)
+		user.GET("/logout/all", client.AuthWeb.LogoutAll)
 	}
 
 	main := gin.Group("/client")
 	{
-		main.Use(middleware.Auth()) // endpoints that require tokens from this endpoint group
+		main.Use(middleware.WebAuth(tokenService, sessionService, userService)) // endpoints that require tokens from this endpoint group
 		main.GET("/dashboard", client.DashboardWeb.Dashboard)
 		main.GET("/task", client.TaskWeb.TaskPage)
 		user.POST("/task/add/process", client.TaskWeb.TaskAddProcess)
@@ -2,20 +2,43 @@ package main_test
 
 import (
 	main "a21hc3NpZ25tZW50"
+	"a21hc3NpZ25tZW50/config"
 	"a21hc3NpZ25tZW50/db/filebased"
+	"a21hc3NpZ25tZW50/db/memory"
+	"a21hc3NpZ25tZW50/db/sqldb"
+	"a21hc3NpZ25tZW50/mailer"
 	"a21hc3NpZ25tZW50/middleware"
 	"a21hc3NpZ25tZW50/model"
 	repo "a21hc3NpZ25tZW50/repository"
 	"a21hc3NpZ25tZW50/service"
+	"archive/zip"
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -23,6 +46,8 @@ import (
 	"github.com/golang-jwt/jwt"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"go.etcd.io/bbolt"
+	_ "modernc.org/sqlite"
 )
 
 var html string
@@ -67,6 +92,222 @@ func SetCookie(mux *gin.Engine) *http.Cookie {
 	return cookie
 }
 
+// mailbox is a mailer.Mailer that keeps every message it is given.
+type mailbox struct {
+	messages []mailer.Message
+}
+
+func (m *mailbox) Send(msg mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// heldMailer is a mailer.Mailer that holds every message until release is
+// closed and then hands it to sent.
+type heldMailer struct {
+	release chan struct{}
+	sent    chan mailer.Message
+}
+
+func (m heldMailer) Send(msg mailer.Message) error {
+	<-m.release
+	m.sent <- msg
+	return nil
+}
+
+// linkToken returns the token query parameter of the first link to path in
+// body.
+func linkToken(body, path string) string {
+	i := strings.Index(body, path+"?token=")
+	Expect(i).NotTo(Equal(-1))
+	u, err := url.Parse(strings.Fields(body[i:])[0])
+	Expect(err).ShouldNot(HaveOccurred())
+	return u.Query().Get("token")
+}
+
+// totpAt computes the RFC 6238 code an authenticator app shows for the base32
+// secret at t.
+func totpAt(secret string, t time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(t.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[19] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
+
+// startSMTPStandIn runs a minimal SMTP server on localhost that accepts every
+// message and hands its DATA section to the returned channel.
+func startSMTPStandIn() (host, port string, messages <-chan string, stop func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				text := textproto.NewConn(conn)
+				text.PrintfLine("220 localhost ESMTP stand-in")
+				for {
+					line, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					switch verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); verb {
+					case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+						text.PrintfLine("250 OK")
+					case "DATA":
+						text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+						body, err := text.ReadDotBytes()
+						if err != nil {
+							return
+						}
+						received <- string(body)
+						text.PrintfLine("250 OK")
+					case "QUIT":
+						text.PrintfLine("221 Bye")
+						return
+					default:
+						text.PrintfLine("502 Command not implemented")
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	host, port, err = net.SplitHostPort(listener.Addr().String())
+	Expect(err).ShouldNot(HaveOccurred())
+	return host, port, received, func() { listener.Close() }
+}
+
+// mockIssuer is a minimal OpenID Connect provider for the single sign-on
+// specs. Instead of showing a login page it hands out codes through
+// authorize, for whatever ID token claims the spec asks for.
+type mockIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func startMockIssuer(clientID string) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	m := &mockIssuer{key: key, clientID: clientID, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "mock",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		auth, ok := m.codes[r.PostFormValue("code")]
+		delete(m.codes, r.PostFormValue("code"))
+		m.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+			r.PostFormValue("client_id") != m.clientID ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, auth.claims)
+		token.Header["kid"] = "mock"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// authorize plays the user logging in at the provider: it reads the request
+// the application redirected to and returns the code and state the provider
+// would redirect back with. claims are added to the ID token.
+func (m *mockIssuer) authorize(location string, claims jwt.MapClaims) (code, state string) {
+	u, err := url.Parse(location)
+	Expect(err).ShouldNot(HaveOccurred())
+	Expect(u.Scheme + "://" + u.Host + u.Path).To(Equal(m.server.URL + "/authorize"))
+
+	query := u.Query()
+	Expect(query.Get("response_type")).To(Equal("code"))
+	Expect(query.Get("client_id")).To(Equal(m.clientID))
+	Expect(query.Get("code_challenge_method")).To(Equal("S256"))
+	Expect(query.Get("scope")).To(ContainSubstring("openid"))
+
+	idClaims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   m.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for k, v := range claims {
+		idClaims[k] = v
+	}
+
+	code = fmt.Sprintf("code-%d", time.Now().UnixNano())
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), claims: idClaims}
+	m.mu.Unlock()
+
+	return code, query.Get("state")
+}
+
+// openSQLite opens the SQL store on a SQLite file. The SQL store is tested
+// against SQLite; every statement it runs is also valid on PostgreSQL.
+func openSQLite(path string) (*sqldb.Data, error) {
+	return sqldb.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_txlock=immediate")
+}
+
+// testStore is a storage backend as the specs see it. Every backend can also
+// list all tasks, which lets specs look past the per-user queries.
+type testStore interface {
+	repo.Storage
+	GetTasks() ([]model.Task, error)
+}
+
 var _ = Describe("Task Tracker Plus", Ordered, func() {
 	var apiServer *gin.Engine
 
@@ -74,41 +315,73 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 	var sessionRepo repo.SessionRepository
 	var categoryRepo repo.CategoryRepository
 	var taskRepo repo.TaskRepository
+	var accessTokenRepo repo.AccessTokenRepository
+	var loginAttemptRepo repo.LoginAttemptRepository
 
+	var tokenService service.TokenService
 	var userService service.UserService
 	var sessionService service.SessionService
 	var categoryService service.CategoryService
 	var taskService service.TaskService
+	var accessTokenService service.AccessTokenService
 
 	var insertCategories []model.Category
 	var insertTasks []model.Task
 	var expectedUserTask []model.UserTaskCategory
 
+	var store testStore
 	var filebasedDb *filebased.Data
+	var outbox *mailbox
 
 	var err error
 
+	// registerVerified registers a member and confirms the email straight
+	// away, as if the emailed link had been followed.
+	registerVerified := func(fullname, email, password string) model.User {
+		user, err := userService.Register(&model.User{Fullname: fullname, Email: email, Password: password})
+		Expect(err).ShouldNot(HaveOccurred())
+		token, err := tokenService.IssueEmailVerification(user.ID, user.Email)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(userService.VerifyEmail(token)).Should(Succeed())
+		return user
+	}
+
 	BeforeEach(func() {
 		gin.SetMode(gin.ReleaseMode) //release
 
-		os.Remove("file.db")
+		// Specs labelled "file" look inside the bbolt file and get a fresh
+		// one of their own; the rest run against the memory store.
+		filebasedDb = nil
+		if slices.Contains(CurrentSpecReport().Labels(), "file") {
+			filebasedDb, err = filebased.Open(filepath.Join(GinkgoT().TempDir(), "file.db"))
+			Expect(err).ShouldNot(HaveOccurred())
+			DeferCleanup(filebasedDb.CloseDB)
+			store = filebasedDb
+		} else {
+			store = memory.New()
+		}
+
+		userRepo = repo.NewUserRepo(store)
+		sessionRepo = repo.NewSessionsRepo(store)
+		categoryRepo = repo.NewCategoryRepo(store)
+		taskRepo = repo.NewTaskRepo(store)
+		accessTokenRepo = repo.NewAccessTokenRepo(store)
+		loginAttemptRepo = repo.NewLoginAttemptRepo(store)
 
-		filebasedDb, err = filebased.InitDB()
+		outbox = &mailbox{}
 
-		userRepo = repo.NewUserRepo(filebasedDb)
-		sessionRepo = repo.NewSessionsRepo(filebasedDb)
-		categoryRepo = repo.NewCategoryRepo(filebasedDb)
-		taskRepo = repo.NewTaskRepo(filebasedDb)
+		jwtConfig, jwtErr := config.JWT()
+		Expect(jwtErr).ShouldNot(HaveOccurred())
+		tokenService = service.NewTokenService(jwtConfig)
 
-		userService = service.NewUserService(userRepo, sessionRepo)
+		userService = service.NewUserService(userRepo, sessionRepo, loginAttemptRepo, accessTokenRepo, tokenService, outbox)
 		sessionService = service.NewSessionService(sessionRepo)
 		categoryService = service.NewCategoryService(categoryRepo)
 		taskService = service.NewTaskService(taskRepo)
-
-		Expect(err).ShouldNot(HaveOccurred())
+		accessTokenService = service.NewAccessTokenService(accessTokenRepo)
 
 		apiServer = gin.New()
-		apiServer = main.RunServer(apiServer, filebasedDb)
+		apiServer = main.RunServer(apiServer, store)
 
 		expectedUserTask = []model.UserTaskCategory{
 			{
@@ -143,7 +416,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		}
 
 		for _, v := range insertCategories {
-			err := categoryRepo.Store(&v)
+			_, err := categoryRepo.Store(&v)
 			Expect(err).ShouldNot(HaveOccurred())
 		}
 
@@ -196,7 +469,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		}
 
 		for _, v := range insertTasks {
-			err := taskRepo.Store(&v)
+			_, err := taskRepo.Store(&v)
 			Expect(err).ShouldNot(HaveOccurred())
 		}
 
@@ -214,6 +487,13 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		apiServer.ServeHTTP(w, r)
 
 		Expect(w.Result().StatusCode).To(Equal(http.StatusCreated))
+
+		verificationToken, err := tokenService.IssueEmailVerification(1, "test@mail.com")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(userService.VerifyEmail(verificationToken)).Should(Succeed())
+
+		// The test user manages the global categories, so make it an admin.
+		Expect(userService.SetRole(1, model.RoleAdmin)).Should(Succeed())
 	})
 
 	Describe("Auth Middleware", func() {
@@ -230,12 +510,16 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		When("valid token is provided", func() {
 			It("should set user Email in context and call next middleware", func() {
 				claims := &model.Claims{Email: "aditira@gmail.com"}
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-				signedToken, _ := token.SignedString(model.JwtKey)
+				signedToken, expiresAt, _ := tokenService.Issue(claims)
+				Expect(sessionRepo.AddSessions(model.Session{
+					Token:  signedToken,
+					Email:  "aditira@gmail.com",
+					Expiry: expiresAt,
+				})).Should(Succeed())
 				req, _ := http.NewRequest(http.MethodGet, "/", nil)
 				req.AddCookie(&http.Cookie{Name: "session_token", Value: signedToken})
 
-				router.Use(middleware.Auth())
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
 				router.GET("/", func(ctx *gin.Context) {
 					Email := ctx.MustGet("email").(string)
 					Expect(Email).To(Equal("aditira@gmail.com"))
@@ -246,14 +530,72 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 			})
 		})
 
+		When("the token is validly signed but has no server-side session", func() {
+			It("should return unauthorized error response", func() {
+				signedToken, _, _ := tokenService.Issue(&model.Claims{Email: "aditira@gmail.com"})
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: signedToken})
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+				router.GET("/", func(ctx *gin.Context) {})
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		When("the server-side session has expired", func() {
+			It("should return unauthorized error response and drop the session", func() {
+				signedToken, _, _ := tokenService.Issue(&model.Claims{Email: "aditira@gmail.com"})
+				Expect(sessionRepo.AddSessions(model.Session{
+					Token:  signedToken,
+					Email:  "aditira@gmail.com",
+					Expiry: time.Now().Add(-time.Minute),
+				})).Should(Succeed())
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: signedToken})
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+				router.GET("/", func(ctx *gin.Context) {})
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+				_, err := sessionRepo.SessionAvailToken(signedToken)
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		When("a valid bearer token is provided", func() {
+			It("should authenticate the request without a cookie", func() {
+				signedToken, expiresAt, _ := tokenService.Issue(&model.Claims{UserID: 3, Email: "aditira@gmail.com"})
+				Expect(sessionRepo.AddSessions(model.Session{
+					Token:  signedToken,
+					Email:  "aditira@gmail.com",
+					Expiry: expiresAt,
+				})).Should(Succeed())
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+signedToken)
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+				router.GET("/", func(ctx *gin.Context) {
+					Expect(ctx.MustGet("user_id")).To(Equal(3))
+				})
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+			})
+		})
+
 		When("session token is missing", func() {
 			It("should return unauthorized error response", func() {
 				req, _ := http.NewRequest(http.MethodGet, "/", nil)
 
-				router.Use(middleware.Auth())
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
 
 				router.ServeHTTP(w, req)
-				Expect(w.Code).To(Equal(http.StatusSeeOther))
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+				Expect(w.Header().Get("Content-Type")).To(ContainSubstring("application/json"))
 			})
 		})
 
@@ -262,16 +604,172 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 				req, _ := http.NewRequest(http.MethodGet, "/", nil)
 				req.AddCookie(&http.Cookie{Name: "session_token", Value: "invalid_token"})
 
-				router.Use(middleware.Auth())
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		When("the bearer token is invalid", func() {
+			It("should return unauthorized error response", func() {
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer invalid_token")
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		When("a read-only access token is used for a write request", func() {
+			It("should return forbidden error response", func() {
+				created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci", Scope: model.ScopeRead})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+				router.GET("/", func(ctx *gin.Context) {
+					Expect(ctx.MustGet("user_id")).To(Equal(1))
+					Expect(ctx.GetString("auth_method")).To(Equal("access_token"))
+				})
+				router.POST("/", func(ctx *gin.Context) {})
+
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+created.Token)
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
+
+				w = httptest.NewRecorder()
+				req, _ = http.NewRequest(http.MethodPost, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+created.Token)
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		When("an expired access token is used", func() {
+			It("should return unauthorized error response", func() {
+				created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci"})
+				Expect(err).ShouldNot(HaveOccurred())
+				created.PersonalAccessToken.ExpiresAt = time.Now().Add(-time.Minute)
+				Expect(accessTokenRepo.Update(created.PersonalAccessToken)).Should(Succeed())
+
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+created.Token)
+
+				router.Use(middleware.Auth(tokenService, sessionService, accessTokenService))
+				router.GET("/", func(ctx *gin.Context) {})
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		When("a web page is requested without credentials", func() {
+			It("should redirect to the login page", func() {
+				req, _ := http.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Content-Type", "application/json")
+
+				router.Use(middleware.WebAuth(tokenService, sessionService, userService))
+
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusSeeOther))
+				Expect(w.Header().Get("Location")).To(Equal("/client/login"))
+			})
+		})
+
+		When("a web page is requested after the access token has expired", func() {
+			It("should refresh the session from the refresh cookie", func() {
+				shortConfig, err := config.LoadJWTConfig("web:web-secret", "", "1s")
+				Expect(err).ShouldNot(HaveOccurred())
+				shortTokens := service.NewTokenService(shortConfig)
+				shortUsers := service.NewUserService(userRepo, sessionRepo, loginAttemptRepo, accessTokenRepo, shortTokens, outbox)
+
+				login, err := shortUsers.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+				Expect(err).ShouldNot(HaveOccurred())
+				time.Sleep(2 * time.Second)
+
+				var seen string
+				router.Use(middleware.WebAuth(shortTokens, sessionService, shortUsers))
+				router.GET("/client/dashboard", func(ctx *gin.Context) { seen = ctx.GetString("session_token") })
+
+				req, _ := http.NewRequest(http.MethodGet, "/client/dashboard", nil)
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: login.AccessToken})
+				req.AddCookie(&http.Cookie{Name: "refresh_token", Value: login.RefreshToken})
+				router.ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(http.StatusOK))
 
+				cookies := map[string]*http.Cookie{}
+				for _, c := range w.Result().Cookies() {
+					cookies[c.Name] = c
+				}
+				Expect(cookies).To(HaveKey("session_token"))
+				Expect(cookies["session_token"].Value).To(Equal(seen))
+				Expect(seen).NotTo(Equal(login.AccessToken))
+				Expect(cookies).To(HaveKey("refresh_token"))
+				Expect(cookies["refresh_token"].Path).To(Equal("/client"))
+				Expect(cookies["refresh_token"].HttpOnly).To(BeTrue())
+
+				// The rotated refresh token was spent, so replaying it logs
+				// the browser out.
+				w = httptest.NewRecorder()
 				router.ServeHTTP(w, req)
-				Expect(w.Code).To(Equal(http.StatusBadRequest))
+				Expect(w.Code).To(Equal(http.StatusSeeOther))
+				Expect(w.Header().Get("Location")).To(Equal("/client/login"))
+			})
+		})
+	})
+
+	Describe("Token Service", func() {
+		var oldKeys, rotatedKeys, retiredKeys config.JWTConfig
+
+		BeforeEach(func() {
+			var err error
+			oldKeys, err = config.LoadJWTConfig("2024-01:first-secret", "", "")
+			Expect(err).ShouldNot(HaveOccurred())
+			rotatedKeys, err = config.LoadJWTConfig("2024-01:first-secret,2024-06:second-secret", "2024-06", "")
+			Expect(err).ShouldNot(HaveOccurred())
+			retiredKeys, err = config.LoadJWTConfig("2024-06:second-secret", "", "1h")
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		When("issuing a token", func() {
+			It("should stamp the active key id and the configured lifetime", func() {
+				signedToken, expiresAt, err := service.NewTokenService(retiredKeys).Issue(&model.Claims{Email: "a@mail.com"})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(expiresAt).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+				parsed, _, err := new(jwt.Parser).ParseUnverified(signedToken, &model.Claims{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(parsed.Header["kid"]).To(Equal("2024-06"))
+			})
+		})
+
+		When("the signing key has been rotated", func() {
+			It("should keep verifying tokens signed with a key that is still configured", func() {
+				signedToken, _, err := service.NewTokenService(oldKeys).Issue(&model.Claims{UserID: 7, Email: "a@mail.com"})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				claims, err := service.NewTokenService(rotatedKeys).Parse(signedToken)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(claims.UserID).To(Equal(7))
+
+				_, err = service.NewTokenService(retiredKeys).Parse(signedToken)
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		When("the active key id is not configured", func() {
+			It("should refuse the configuration", func() {
+				_, err := config.LoadJWTConfig("2024-01:first-secret", "2024-06", "")
+				Expect(err).Should(HaveOccurred())
 			})
 		})
 	})
 
 	Describe("Repository", func() {
-		Describe("Sessions repository", func() {
+		Describe("Sessions repository", Label("file"), func() {
 			When("add session data to sessions table database postgres", func() {
 				It("should save data session to sessions table database postgres", func() {
 					session := model.Session{
@@ -365,6 +863,26 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 				})
 			})
 
+			When("resetting the database", func() {
+				It("should forget sessions along with every other record", func() {
+					Expect(sessionRepo.AddSessions(model.Session{Token: "reset-token", Email: "test@mail.com", Expiry: time.Now().Add(time.Hour)})).Should(Succeed())
+					Expect(filebasedDb.Reset()).Should(Succeed())
+
+					_, err := sessionRepo.SessionAvailToken("reset-token")
+					Expect(err).Should(HaveOccurred())
+					sessions, err := sessionRepo.SessionsByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(sessions).To(BeEmpty())
+					user, err := userRepo.GetUserByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.ID).To(BeZero())
+
+					category, err := filebasedDb.StoreCategory(model.Category{Name: "Fresh"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(1))
+				})
+			})
+
 			When("check session availability with token", func() {
 				It("return data session with target token", func() {
 					_, err := sessionRepo.SessionAvailToken("cc03dbea-4085-47ba-86fe-020f5d01a9d8")
@@ -404,7 +922,8 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					Expect(err).ShouldNot(HaveOccurred())
 					Expect(resUser.Fullname).To(Equal(expectUser.Fullname))
 					Expect(resUser.Email).To(Equal(expectUser.Email))
-					Expect(resUser.Password).To(Equal(expectUser.Password))
+					Expect(resUser.Password).NotTo(Equal(expectUser.Password))
+					Expect(resUser.Password).To(HavePrefix("$argon2id$v=19$m=65536,t=3,p=2$"))
 				})
 			})
 
@@ -415,6 +934,61 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					Expect(resUserTask).To(Equal(expectedUserTask))
 				})
 			})
+
+			When("changing and deleting users", func() {
+				It("should keep the email index in step", func() {
+					user, err := userRepo.CreateUser(model.User{Fullname: "indexed", Email: "indexed@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+
+					_, err = userRepo.CreateUser(model.User{Fullname: "copy", Email: "INDEXED@mail.com"})
+					Expect(err).To(MatchError(model.ErrRecordExists))
+
+					user.Email = "moved@mail.com"
+					Expect(userRepo.UpdateUser(user)).Should(Succeed())
+
+					old, err := userRepo.GetUserByEmail("indexed@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(old.ID).To(BeZero())
+
+					moved, err := userRepo.GetUserByEmail("Moved@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(moved.ID).To(Equal(user.ID))
+
+					Expect(userRepo.DeleteUser(user.ID)).Should(Succeed())
+					gone, err := userRepo.GetUserByEmail("moved@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(gone.ID).To(BeZero())
+				})
+			})
+
+			When("the email indexes are lost", Label("file"), func() {
+				It("should find users and sessions again after a rebuild", func() {
+					SetCookie(apiServer)
+
+					Expect(filebasedDb.DB.Update(func(tx *bbolt.Tx) error {
+						Expect(tx.DeleteBucket([]byte("UsersByEmail"))).Should(Succeed())
+						Expect(tx.DeleteBucket([]byte("SessionsByEmail"))).Should(Succeed())
+						_, err := tx.CreateBucket([]byte("UsersByEmail"))
+						Expect(err).ShouldNot(HaveOccurred())
+						_, err = tx.CreateBucket([]byte("SessionsByEmail"))
+						return err
+					})).Should(Succeed())
+
+					lost, err := userRepo.GetUserByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(lost.ID).To(BeZero())
+
+					Expect(filebasedDb.RebuildIndexes()).Should(Succeed())
+
+					user, err := userRepo.GetUserByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.ID).To(Equal(1))
+
+					sessions, err := sessionRepo.SessionsByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(sessions).To(HaveLen(1))
+				})
+			})
 		})
 
 		Describe("Task", func() {
@@ -437,12 +1011,53 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 
 			When("deleting a category with a valid category ID", func() {
 				It("should delete the category from the database without returning an error", func() {
-					err = categoryRepo.Delete(2)
+					err = categoryRepo.Delete(2, model.CategoryDeleteCascade, 0)
 					Expect(err).ShouldNot(HaveOccurred())
 
 					result, err := categoryRepo.GetByID(2)
 					Expect(err.Error()).To(Equal("record not found"))
 					Expect(result).To(BeNil())
+
+					_, err = taskRepo.GetByID(1, 2)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+				})
+			})
+
+			When("deleting a category that still has tasks", func() {
+				It("should refuse in restrict mode and keep everything", func() {
+					err := categoryRepo.Delete(1, model.CategoryDeleteRestrict, 0)
+					Expect(err).To(MatchError(model.ErrCategoryInUse))
+
+					_, err = categoryRepo.GetByID(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = taskRepo.GetByID(2, 1)
+					Expect(err).ShouldNot(HaveOccurred())
+				})
+
+				It("should move the tasks in reassign mode", func() {
+					err := categoryRepo.Delete(1, model.CategoryDeleteReassign, 1)
+					Expect(err).To(MatchError(model.ErrCategoryNotFound))
+					err = categoryRepo.Delete(1, model.CategoryDeleteReassign, 99)
+					Expect(err).To(MatchError(model.ErrCategoryNotFound))
+
+					Expect(categoryRepo.Delete(1, model.CategoryDeleteReassign, 5)).Should(Succeed())
+
+					task, err := taskRepo.GetByID(2, 1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(task.CategoryID).To(Equal(5))
+
+					moved, err := taskRepo.GetTaskCategory(2, 5)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(moved).To(Equal([]model.TaskCategory{{ID: 1, Title: "Task 1", Category: "Category 5"}}))
+					_, err = taskRepo.GetTaskCategory(2, 1)
+					Expect(err).Should(HaveOccurred())
+				})
+			})
+
+			When("deleting a category that does not exist", func() {
+				It("should return record not found", func() {
+					err := categoryRepo.Delete(99, model.CategoryDeleteCascade, 0)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
 				})
 			})
 
@@ -469,10 +1084,10 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 						CategoryID: 1,
 						Status:     "In Progress",
 					}
-					err = taskRepo.Update(newTask.ID, &newTask)
+					err = taskRepo.Update(2, newTask.ID, &newTask)
 					Expect(err).ShouldNot(HaveOccurred())
 
-					result, err := taskRepo.GetByID(1)
+					result, err := taskRepo.GetByID(2, 1)
 					Expect(err).ShouldNot(HaveOccurred())
 					Expect(result.Title).To(Equal(newTask.Title))
 					Expect(result.Deadline).To(Equal(newTask.Deadline))
@@ -484,64 +1099,672 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 
 			When("deleting a task with a valid task ID from the database", func() {
 				It("should delete the task without any errors", func() {
-					err = taskRepo.Delete(2)
+					err = taskRepo.Delete(1, 2)
 					Expect(err).ShouldNot(HaveOccurred())
 
-					result, err := taskRepo.GetByID(2)
+					result, err := taskRepo.GetByID(1, 2)
 					Expect(err.Error()).To(Equal("record not found"))
 					Expect(result).To(BeNil())
 				})
 			})
 
 			When("retrieving the list of tasks from the database", func() {
-				It("should return the list of tasks without any errors", func() {
-					results, err := taskRepo.GetList()
+				It("should return only the tasks owned by the given user", func() {
+					results, err := taskRepo.GetList(1)
 					Expect(err).ShouldNot(HaveOccurred())
-					Expect(results).To(HaveLen(5))
+					Expect(results).To(HaveLen(2))
+
+					Expect(results).To(Equal([]model.Task{insertTasks[1], insertTasks[4]}))
+				})
+			})
+
+			When("accessing a task owned by another user", func() {
+				It("should report it as not found and leave it untouched", func() {
+					_, err := taskRepo.GetByID(1, 1)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					err = taskRepo.Update(1, 1, &model.Task{Title: "Hijacked"})
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					err = taskRepo.Delete(1, 1)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					result, err := taskRepo.GetByID(2, 1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.Title).To(Equal("Task 1"))
+				})
+			})
+
+			When("storing a task without an ID", func() {
+				It("should allocate the next ID and return the created task", func() {
+					created, err := taskRepo.Store(&model.Task{Title: "Task 6", CategoryID: 1, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(created.ID).To(Equal(6))
+
+					created, err = taskRepo.Store(&model.Task{Title: "Task 7", CategoryID: 1, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(created.ID).To(Equal(7))
+
+					result, err := taskRepo.GetByID(1, 6)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.Title).To(Equal("Task 6"))
+				})
+			})
+
+			When("storing a task with an ID that already exists", func() {
+				It("should reject it without replacing the existing task", func() {
+					_, err := taskRepo.Store(&model.Task{ID: 1, Title: "Duplicate"})
+					Expect(err).To(MatchError(model.ErrRecordExists))
 
-					Expect(results).To(Equal(insertTasks))
+					result, err := taskRepo.GetByID(2, 1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result.Title).To(Equal("Task 1"))
 				})
 			})
 
 			When("retrieving the list of tasks for a specific category from the database", func() {
 				It("should return the list of tasks for the specified category without any errors", func() {
-					taskCategory, err := taskRepo.GetTaskCategory(1)
+					taskCategory, err := taskRepo.GetTaskCategory(2, 1)
 					Expect(err).ShouldNot(HaveOccurred())
 
 					Expect(taskCategory).To(Equal([]model.TaskCategory{
 						{ID: 1, Title: "Task 1", Category: "Category 1"},
-						{ID: 3, Title: "Task 3", Category: "Category 1"},
-						{ID: 4, Title: "Task 4", Category: "Category 1"},
 					}))
 				})
 			})
 
+			When("a task refers to a category that does not exist", func() {
+				It("should be neither stored nor updated", func() {
+					_, err := taskRepo.Store(&model.Task{Title: "Orphan", CategoryID: 99, UserID: 1})
+					Expect(err).To(MatchError(model.ErrCategoryNotFound))
+
+					err = taskRepo.Update(1, 2, &model.Task{Title: "Orphan", CategoryID: 99})
+					Expect(err).To(MatchError(model.ErrCategoryNotFound))
+
+					task, err := taskRepo.GetByID(1, 2)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(task.CategoryID).To(Equal(2))
+				})
+			})
+
 		})
-	})
 
-	Describe("Service", func() {
-		Describe("Session Service", func() {
-			Describe("GetSessionByEmail", func() {
-				When("retrieving user session by email from session repository", func() {
-					It("should return the expected user session", func() {
-						session := model.Session{
-							Token:  "cc03dbea-4085-47ba-86fe-020f5d01a9d8",
-							Email:  "aditira@gmail.com",
-							Expiry: time.Now().Add(5 * time.Hour),
+		Describe("Storage schema", func() {
+			When("opening a database written with decimal task and category keys", func() {
+				It("should rewrite them to ordered binary keys once", func() {
+					path := filepath.Join(GinkgoT().TempDir(), "legacy.db")
+					legacy, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(legacy.DB.Update(func(tx *bbolt.Tx) error {
+						// Databases from before schema versions had no Meta bucket.
+						Expect(tx.DeleteBucket([]byte("Meta"))).Should(Succeed())
+						for _, id := range []int{1, 2, 10} {
+							v, _ := json.Marshal(model.Category{ID: id, Name: fmt.Sprintf("Category %d", id)})
+							Expect(tx.Bucket([]byte("Categories")).Put([]byte(fmt.Sprint(id)), v)).Should(Succeed())
+							v, _ = json.Marshal(model.Task{ID: id, Title: fmt.Sprintf("Task %d", id), CategoryID: 10, UserID: 1})
+							Expect(tx.Bucket([]byte("Tasks")).Put([]byte(fmt.Sprint(id)), v)).Should(Succeed())
 						}
-						err = sessionRepo.AddSessions(session)
+						// User IDs were one higher than the highest stored
+						// and left the sequence at zero.
+						key := make([]byte, 8)
+						binary.BigEndian.PutUint64(key, 7)
+						v, _ := json.Marshal(model.User{ID: 7, Email: "legacy@mail.com"})
+						Expect(tx.Bucket([]byte("Users")).Put(key, v)).Should(Succeed())
+						return nil
+					})).Should(Succeed())
+					Expect(legacy.CloseDB()).Should(Succeed())
+
+					pending, err := filebased.MigrateFile(path, true)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(pending).To(HaveLen(filebased.LatestSchemaVersion()))
+
+					for i := 0; i < 2; i++ {
+						migrated, err := filebased.Open(path)
 						Expect(err).ShouldNot(HaveOccurred())
 
-						res, err := sessionService.GetSessionByEmail("aditira@gmail.com")
+						tasks, err := migrated.GetTasks()
 						Expect(err).ShouldNot(HaveOccurred())
-						Expect(res.Token).To(Equal(session.Token))
-						Expect(res.Email).To(Equal(session.Email))
-					})
-				})
-			})
-		})
+						var ids []int
+						for _, task := range tasks {
+							ids = append(ids, task.ID)
+						}
+						Expect(ids).To(Equal([]int{1, 2, 10}))
+
+						categories, err := migrated.GetCategories()
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(categories).To(HaveLen(3))
+						Expect(categories[2].ID).To(Equal(10))
+
+						listed, err := migrated.GetTaskListByCategory(1, 10)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(listed).To(HaveLen(3))
+
+						Expect(migrated.DB.View(func(tx *bbolt.Tx) error {
+							return tx.Bucket([]byte("Tasks")).ForEach(func(k, v []byte) error {
+								Expect(k).To(HaveLen(8))
+								return nil
+							})
+						})).Should(Succeed())
+						version, err := migrated.SchemaVersion()
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(version).To(Equal(filebased.LatestSchemaVersion()))
+						Expect(migrated.CloseDB()).Should(Succeed())
+					}
+
+					// New records must not land on the IDs already taken.
+					migrated, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					defer migrated.CloseDB()
+					category, err := migrated.StoreCategory(model.Category{Name: "Category 11"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(11))
+					task, err := migrated.StoreTask(model.Task{Title: "Task 11", CategoryID: 1, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(task.ID).To(Equal(11))
+					user, err := migrated.CreateUser(model.User{Email: "new@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.ID).To(Equal(8))
+					first, err := migrated.GetCategoryByID(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(first.Name).To(Equal("Category 1"))
+				})
+			})
+
+			When("a dry run finds pending migrations", func() {
+				It("should leave the database untouched", func() {
+					path := filepath.Join(GinkgoT().TempDir(), "legacy.db")
+					legacy, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(legacy.DB.Update(func(tx *bbolt.Tx) error {
+						Expect(tx.DeleteBucket([]byte("Meta"))).Should(Succeed())
+						v, _ := json.Marshal(model.Category{ID: 7, Name: "Category 7"})
+						return tx.Bucket([]byte("Categories")).Put([]byte("7"), v)
+					})).Should(Succeed())
+					Expect(legacy.CloseDB()).Should(Succeed())
+
+					for i := 0; i < 2; i++ {
+						pending, err := filebased.MigrateFile(path, true)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(pending).NotTo(BeEmpty())
+						Expect(pending[0].Version).To(Equal(1))
+					}
+
+					db, err := bbolt.Open(path, 0600, nil)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(db.View(func(tx *bbolt.Tx) error {
+						Expect(tx.Bucket([]byte("Meta"))).To(BeNil())
+						Expect(tx.Bucket([]byte("Categories")).Get([]byte("7"))).NotTo(BeNil())
+						return nil
+					})).Should(Succeed())
+					Expect(db.Close()).Should(Succeed())
+				})
+			})
+
+			When("the database was migrated by a newer binary", func() {
+				It("should refuse to open it", func() {
+					path := filepath.Join(GinkgoT().TempDir(), "newer.db")
+					data, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(data.DB.Update(func(tx *bbolt.Tx) error {
+						next := make([]byte, 8)
+						binary.BigEndian.PutUint64(next, uint64(filebased.LatestSchemaVersion()+1))
+						return tx.Bucket([]byte("Meta")).Put([]byte("schema_version"), next)
+					})).Should(Succeed())
+					Expect(data.CloseDB()).Should(Succeed())
+
+					_, err = filebased.Open(path)
+					Expect(err).To(MatchError(filebased.ErrSchemaTooNew))
+					_, err = filebased.MigrateFile(path, true)
+					Expect(err).To(MatchError(filebased.ErrSchemaTooNew))
+				})
+			})
+		})
+
+		Describe("Integrity check", Label("file"), func() {
+			// problems lists the problems of report as "bucket key kind".
+			problems := func(report model.IntegrityReport) []string {
+				var found []string
+				for _, p := range report.Problems {
+					found = append(found, fmt.Sprintf("%s %s %s", p.Bucket, p.Key, p.Kind))
+				}
+				return found
+			}
+
+			When("the database holds bad records", func() {
+				It("should report them and move them to quarantine on request", func() {
+					Expect(sessionRepo.AddSessions(model.Session{Token: "ghost-token", Email: "ghost@mail.com", Expiry: time.Now().Add(time.Hour)})).Should(Succeed())
+					Expect(filebasedDb.DB.Update(func(tx *bbolt.Tx) error {
+						key := make([]byte, 8)
+						binary.BigEndian.PutUint64(key, 50)
+						Expect(tx.Bucket([]byte("Categories")).Put(key, []byte("{not json"))).Should(Succeed())
+						return tx.Bucket([]byte("UsersByEmail")).Delete([]byte("test@mail.com"))
+					})).Should(Succeed())
+
+					report, err := filebasedDb.CheckIntegrity(false)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(problems(report)).To(ConsistOf(
+						"Categories 50 undecodable",
+						"Tasks 1 orphaned_task",
+						"Tasks 3 orphaned_task",
+						"Tasks 4 orphaned_task",
+						MatchRegexp(`^Sessions sha256:[0-9a-f]{16} dangling$`),
+						"UsersByEmail test@mail.com index_mismatch",
+					))
+					tasks, err := filebasedDb.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(5))
+
+					report, err = filebasedDb.CheckIntegrity(true)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(report.IndexesRebuilt).To(BeTrue())
+					for _, p := range report.Problems {
+						Expect(p.Quarantined).To(Equal(p.Kind != model.ProblemIndexMismatch))
+					}
+
+					tasks, err = filebasedDb.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(Equal([]model.Task{insertTasks[1], insertTasks[4]}))
+					user, err := userRepo.GetUserByEmail("test@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.ID).To(Equal(1))
+					Expect(filebasedDb.DB.View(func(tx *bbolt.Tx) error {
+						Expect(tx.Bucket([]byte("Quarantine")).Bucket([]byte("Sessions")).Get([]byte("ghost-token"))).NotTo(BeNil())
+						return nil
+					})).Should(Succeed())
+
+					report, err = filebasedDb.CheckIntegrity(false)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(report.Problems).To(BeEmpty())
+				})
+			})
+
+			When("bad records have not been quarantined yet", func() {
+				It("should leave them out of the lists instead of failing", func() {
+					Expect(filebasedDb.DB.Update(func(tx *bbolt.Tx) error {
+						key := make([]byte, 8)
+						binary.BigEndian.PutUint64(key, 2)
+						Expect(tx.Bucket([]byte("Categories")).Put(key, []byte("{not json"))).Should(Succeed())
+						binary.BigEndian.PutUint64(key, 60)
+						Expect(tx.Bucket([]byte("Tasks")).Put(key, []byte("{not json"))).Should(Succeed())
+						return tx.Bucket([]byte("Users")).Put(key, []byte("{not json"))
+					})).Should(Succeed())
+
+					tasks, err := filebasedDb.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(5))
+					categories, err := filebasedDb.GetCategories()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(categories).To(HaveLen(4))
+
+					results, err := filebasedDb.GetUserTaskCategory()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(results).To(HaveLen(2))
+					Expect([]string{results[0].Category, results[1].Category}).To(Equal([]string{"", "Category 3"}))
+
+					r := httptest.NewRequest("GET", "/api/v1/user/tasks/me", nil)
+					r.AddCookie(SetCookie(apiServer))
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					Expect(w.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		Describe("Backups", Label("file"), func() {
+			When("restoring a snapshot", func() {
+				It("should keep the replaced file and refuse bad snapshots", func() {
+					var snapshot bytes.Buffer
+					Expect(filebasedDb.Backup(&snapshot, true)).Should(Succeed())
+
+					path := filepath.Join(GinkgoT().TempDir(), "target.db")
+					target, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					err = filebased.Restore(path, bytes.NewReader(snapshot.Bytes()))
+					Expect(err).To(MatchError(ContainSubstring("in use")))
+
+					Expect(target.CloseDB()).Should(Succeed())
+					Expect(filebased.Restore(path, bytes.NewReader(snapshot.Bytes()))).Should(Succeed())
+					Expect(path + ".before-restore").To(BeAnExistingFile())
+
+					restored, err := filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					tasks, err := restored.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(len(insertTasks)))
+					Expect(restored.CloseDB()).Should(Succeed())
+
+					err = filebased.Restore(path, strings.NewReader("not a database"))
+					Expect(err).To(MatchError(filebased.ErrInvalidSnapshot))
+					restored, err = filebased.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(restored.CloseDB()).Should(Succeed())
+				})
+			})
+
+			When("backing up to a directory", func() {
+				It("should keep only the newest backups", func() {
+					dir := GinkgoT().TempDir()
+					for _, name := range []string{"backup-20200101T000000.000Z.db", "backup-20200102T000000.000Z.db.gz", "backup-20200103T000000.000Z.db", "notes.txt"} {
+						Expect(os.WriteFile(filepath.Join(dir, name), nil, 0600)).Should(Succeed())
+					}
+
+					path, err := filebasedDb.BackupToDir(dir, 2, true)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					entries, err := os.ReadDir(dir)
+					Expect(err).ShouldNot(HaveOccurred())
+					var names []string
+					for _, entry := range entries {
+						names = append(names, entry.Name())
+					}
+					Expect(names).To(ConsistOf("backup-20200103T000000.000Z.db", filepath.Base(path), "notes.txt"))
+
+					f, err := os.Open(path)
+					Expect(err).ShouldNot(HaveOccurred())
+					defer f.Close()
+					restored := filepath.Join(GinkgoT().TempDir(), "restored.db")
+					Expect(filebased.Restore(restored, f)).Should(Succeed())
+					Expect(filebased.ValidateSnapshot(restored)).Should(Succeed())
+				})
+			})
+		})
+
+		Describe("SQL storage", func() {
+			var sqlData *sqldb.Data
+
+			BeforeEach(func() {
+				sqlData, err = openSQLite(filepath.Join(GinkgoT().TempDir(), "sql.db"))
+				Expect(err).ShouldNot(HaveOccurred())
+				DeferCleanup(sqlData.CloseDB)
+			})
+
+			When("opening a database", func() {
+				It("should migrate it once and refuse one from a newer binary", func() {
+					version, err := sqlData.SchemaVersion()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(version).To(Equal(sqldb.LatestSchemaVersion()))
+
+					applied, err := sqldb.Migrate(sqlData.DB)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(applied).To(BeEmpty())
+
+					_, err = sqlData.DB.Exec(`INSERT INTO schema_migrations (version, description, applied_at) VALUES ($1, $2, $3)`,
+						sqldb.LatestSchemaVersion()+1, "from the future", time.Now().Format(time.RFC3339))
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = sqldb.Migrate(sqlData.DB)
+					Expect(err).To(MatchError(sqldb.ErrSchemaTooNew))
+				})
+			})
+
+			When("the server runs on it", func() {
+				It("should serve the API and leave out the file store's admin tools", func() {
+					sqlUserService := service.NewUserService(repo.NewUserRepo(sqlData), repo.NewSessionsRepo(sqlData),
+						repo.NewLoginAttemptRepo(sqlData), repo.NewAccessTokenRepo(sqlData), tokenService, &mailbox{})
+					user, err := sqlUserService.Register(&model.User{Fullname: "test", Email: "test@mail.com", Password: "testing123"})
+					Expect(err).ShouldNot(HaveOccurred())
+					token, err := tokenService.IssueEmailVerification(user.ID, user.Email)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(sqlUserService.VerifyEmail(token)).Should(Succeed())
+					Expect(sqlUserService.SetRole(user.ID, model.RoleAdmin)).Should(Succeed())
+
+					server := main.RunServer(gin.New(), sqlData)
+					cookie := SetCookie(server)
+					Expect(cookie).NotTo(BeNil())
+
+					send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+						reqBody, _ := json.Marshal(body)
+						w := httptest.NewRecorder()
+						r := httptest.NewRequest(method, path, bytes.NewReader(reqBody))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(cookie)
+						server.ServeHTTP(w, r)
+						return w
+					}
+
+					Expect(send("POST", "/api/v1/category/add", model.Category{Name: "Work"}).Code).To(Equal(http.StatusCreated))
+					Expect(send("POST", "/api/v1/task/add", model.Task{Title: "Report", CategoryID: 1}).Code).To(Equal(http.StatusCreated))
+
+					w := send("GET", "/api/v1/task/list", nil)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var tasks []model.Task
+					Expect(json.Unmarshal(w.Body.Bytes(), &tasks)).Should(Succeed())
+					Expect(tasks).To(Equal([]model.Task{{ID: 1, Title: "Report", CategoryID: 1, UserID: user.ID}}))
+
+					Expect(send("GET", "/api/v1/admin/backup", nil).Code).To(Equal(http.StatusNotFound))
+					Expect(send("GET", "/api/v1/admin/integrity", nil).Code).To(Equal(http.StatusNotFound))
+				})
+			})
+		})
+	})
+
+	Describe("Service", func() {
+		Describe("Session Service", func() {
+			Describe("GetSessionByEmail", func() {
+				When("retrieving user session by email from session repository", func() {
+					It("should return the expected user session", func() {
+						session := model.Session{
+							Token:  "cc03dbea-4085-47ba-86fe-020f5d01a9d8",
+							Email:  "aditira@gmail.com",
+							Expiry: time.Now().Add(5 * time.Hour),
+						}
+						err = sessionRepo.AddSessions(session)
+						Expect(err).ShouldNot(HaveOccurred())
+
+						res, err := sessionService.GetSessionByEmail("aditira@gmail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(res.Token).To(Equal(session.Token))
+						Expect(res.Email).To(Equal(session.Email))
+					})
+				})
+			})
+		})
+
+		Describe("User Service", func() {
+			Describe("Register", func() {
+				When("the email has mixed case and spaces", func() {
+					It("should store it normalized and reject the same address again", func() {
+						user, err := userService.Register(&model.User{Fullname: "member", Email: "  Member@Mail.COM ", Password: "member123"})
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.Email).To(Equal("member@mail.com"))
+						Expect(user.PendingVerification).To(BeTrue())
+
+						_, err = userService.Register(&model.User{Fullname: "member", Email: "member@mail.com", Password: "member123"})
+						Expect(err).To(MatchError(service.ErrEmailExists))
+					})
+				})
+
+				When("the email is malformed", func() {
+					It("should return ErrInvalidEmail", func() {
+						for _, email := range []string{"not-an-email", "a@b", "Name <a@b.com>", "a@b.com, c@d.com"} {
+							_, err := userService.Register(&model.User{Fullname: "x", Email: email, Password: "member123"})
+							Expect(err).To(MatchError(service.ErrInvalidEmail), email)
+						}
+					})
+				})
+			})
+
+			When("failed logins carry a different X-Forwarded-For each time", func() {
+				It("should only believe the header from a trusted proxy", func() {
+					login := func(remoteAddr, forwardedFor, email, password string) int {
+						body, _ := json.Marshal(model.UserLogin{Email: email, Password: password})
+						r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						r.Header.Set("X-Forwarded-For", forwardedFor)
+						r.RemoteAddr = remoteAddr
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						return w.Code
+					}
+
+					// Through the loopback hop the header names the client.
+					for i := 0; i < 20; i++ {
+						Expect(login("127.0.0.1:4000", "198.51.100.7", fmt.Sprintf("guess%d@mail.com", i), "wrong")).To(Equal(http.StatusUnauthorized))
+					}
+					Expect(login("127.0.0.1:4000", "198.51.100.7", "test@mail.com", "testing123")).To(Equal(http.StatusTooManyRequests))
+					Expect(login("127.0.0.1:4000", "198.51.100.8", "test@mail.com", "testing123")).To(Equal(http.StatusOK))
+
+					// From anywhere else it is ignored.
+					for i := 0; i < 20; i++ {
+						Expect(login("203.0.113.9:4000", fmt.Sprintf("10.0.0.%d", i), fmt.Sprintf("spoof%d@mail.com", i), "wrong")).To(Equal(http.StatusUnauthorized))
+					}
+					Expect(login("203.0.113.9:4000", "10.0.0.99", "test@mail.com", "testing123")).To(Equal(http.StatusTooManyRequests))
+				})
+			})
+
+			Describe("Email verification", func() {
+				When("a new account logs in before following the link", func() {
+					It("should refuse the login until the emailed link is used", func() {
+						_, err := userService.Register(&model.User{Fullname: "member", Email: "member@mail.com", Password: "member123"})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						_, err = userService.Login(&model.User{Email: "member@mail.com", Password: "member123"}, "", "")
+						Expect(err).To(MatchError(service.ErrEmailNotVerified))
+
+						Expect(outbox.messages).NotTo(BeEmpty())
+						msg := outbox.messages[len(outbox.messages)-1]
+						Expect(msg.To).To(Equal("member@mail.com"))
+						Expect(userService.VerifyEmail(linkToken(msg.Body, "/client/verify"))).Should(Succeed())
+
+						_, err = userService.Login(&model.User{Email: "MEMBER@mail.com", Password: "member123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("a verification token was issued for another address", func() {
+					It("should reject it", func() {
+						user, err := userService.Register(&model.User{Fullname: "member", Email: "member@mail.com", Password: "member123"})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						token, err := tokenService.IssueEmailVerification(user.ID, "other@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(userService.VerifyEmail(token)).To(MatchError(service.ErrInvalidVerification))
+					})
+				})
+
+				When("tokens are used for the wrong purpose", func() {
+					It("should keep access and verification tokens apart", func() {
+						verification, err := tokenService.IssueEmailVerification(1, "test@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						_, err = tokenService.Parse(verification)
+						Expect(err).Should(HaveOccurred())
+
+						access, _, err := tokenService.Issue(&model.Claims{UserID: 1, Email: "test@mail.com"})
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(userService.VerifyEmail(access)).To(MatchError(service.ErrInvalidVerification))
+					})
+				})
+
+				When("a new link is requested", func() {
+					It("should only mail accounts awaiting verification", func() {
+						_, err := userService.Register(&model.User{Fullname: "member", Email: "member@mail.com", Password: "member123"})
+						Expect(err).ShouldNot(HaveOccurred())
+						sent := len(outbox.messages)
+
+						Expect(userService.ResendVerification("Member@mail.com")).Should(Succeed())
+						Expect(outbox.messages).To(HaveLen(sent + 1))
+
+						Expect(userService.ResendVerification("test@mail.com")).Should(Succeed())
+						Expect(userService.ResendVerification("nobody@mail.com")).Should(Succeed())
+						Expect(outbox.messages).To(HaveLen(sent + 1))
+					})
+				})
+			})
+
+			Describe("EnsureAdmin", func() {
+				When("the admin account does not exist", func() {
+					It("should create it with the admin role", func() {
+						Expect(userService.EnsureAdmin("Root", "root@mail.com", "rootpass123")).Should(Succeed())
+
+						admin, err := userRepo.GetUserByEmail("root@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(admin.Role).To(Equal(model.RoleAdmin))
+
+						_, err = userService.Login(&model.User{Email: "root@mail.com", Password: "rootpass123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("the account exists as a member", func() {
+					It("should promote it and keep its password", func() {
+						registerVerified("member", "member@mail.com", "member123")
+
+						Expect(userService.EnsureAdmin("ignored", "member@mail.com", "")).Should(Succeed())
+
+						member, err := userRepo.GetUserByEmail("member@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(member.Role).To(Equal(model.RoleAdmin))
+						Expect(member.Fullname).To(Equal("member"))
+
+						_, err = userService.Login(&model.User{Email: "member@mail.com", Password: "member123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("the account does not exist and no password is set", func() {
+					It("should return an error", func() {
+						Expect(userService.EnsureAdmin("Root", "root@mail.com", "")).To(MatchError(service.ErrAdminPasswordUnset))
+					})
+				})
+			})
+
+			Describe("Login", func() {
+				When("the stored password is a legacy plaintext value", func() {
+					It("should accept it and rehash it on the first successful login", func() {
+						_, err := userRepo.CreateUser(model.User{
+							Fullname: "legacy",
+							Email:    "legacy@mail.com",
+							Password: "legacy123",
+						})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						_, err = userService.Login(&model.User{Email: "legacy@mail.com", Password: "wrong"}, "", "")
+						Expect(err).Should(HaveOccurred())
+
+						dbUser, err := userRepo.GetUserByEmail("legacy@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(dbUser.Password).To(Equal("legacy123"))
+
+						tokens, err := userService.Login(&model.User{Email: "legacy@mail.com", Password: "legacy123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(tokens.AccessToken).NotTo(BeEmpty())
+
+						dbUser, err = userRepo.GetUserByEmail("legacy@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(dbUser.Password).To(HavePrefix("$argon2id$"))
+
+						_, err = userService.Login(&model.User{Email: "legacy@mail.com", Password: "legacy123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("the email is unknown or the password is wrong", func() {
+					It("should fail with the same error", func() {
+						_, err := userService.Login(&model.User{Email: "nobody@mail.com", Password: "testing123"}, "", "")
+						Expect(err).To(MatchError(service.ErrInvalidCredentials))
+
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "wrong"}, "", "")
+						Expect(err).To(MatchError(service.ErrInvalidCredentials))
+					})
+				})
+
+				When("one client IP fails too often across accounts", func() {
+					It("should lock out that IP but not others", func() {
+						for i := 0; i < 20; i++ {
+							_, err := userService.Login(&model.User{Email: fmt.Sprintf("guess%d@mail.com", i), Password: "wrong"}, "", "198.51.100.7")
+							Expect(err).To(MatchError(service.ErrInvalidCredentials))
+						}
+
+						_, err := userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "198.51.100.7")
+						var locked *service.LoginLockedError
+						Expect(errors.As(err, &locked)).To(BeTrue())
+
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "198.51.100.8")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+			})
 
-		Describe("User Service", func() {
 			Describe("GetUserTaskCategory", func() {
 				When("retrieving user task categories from user repository", func() {
 					It("should return the expected user task categories", func() {
@@ -571,7 +1794,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 			Describe("Delete", func() {
 				When("deleting a category from the database", func() {
 					It("should delete the category without any errors", func() {
-						err := categoryService.Delete(3)
+						err := categoryService.Delete(3, model.CategoryDeleteReassign, 1)
 						Expect(err).ShouldNot(HaveOccurred())
 					})
 				})
@@ -603,7 +1826,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 							Status:     "In Progress",
 						}
 
-						err := taskService.Update(task.ID, task)
+						err := taskService.Update(2, task.ID, task)
 						Expect(err).ShouldNot(HaveOccurred())
 					})
 				})
@@ -612,7 +1835,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 			Describe("Delete", func() {
 				When("deleting a task from the database", func() {
 					It("should delete the task without any errors", func() {
-						err := taskService.Delete(3)
+						err := taskService.Delete(3, 3)
 						Expect(err).ShouldNot(HaveOccurred())
 					})
 				})
@@ -621,9 +1844,9 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 			Describe("GetList", func() {
 				When("retrieving the list of tasks from the database", func() {
 					It("should return the list of tasks without any errors", func() {
-						tasks, err := taskService.GetList()
+						tasks, err := taskService.GetList(1)
 						Expect(err).ShouldNot(HaveOccurred())
-						Expect(tasks).To(Equal(insertTasks))
+						Expect(tasks).To(Equal([]model.Task{insertTasks[1], insertTasks[4]}))
 					})
 				})
 			})
@@ -631,12 +1854,10 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 			Describe("GetTaskCategory", func() {
 				When("retrieving the category of a task from the database", func() {
 					It("should return the task category without any errors", func() {
-						taskCategories, err := taskService.GetTaskCategory(1)
+						taskCategories, err := taskService.GetTaskCategory(3, 1)
 						Expect(err).ShouldNot(HaveOccurred())
 						Expect(taskCategories).To(Equal([]model.TaskCategory{
-							{ID: 1, Title: "Task 1", Category: "Category 1"},
 							{ID: 3, Title: "Task 3", Category: "Category 1"},
-							{ID: 4, Title: "Task 4", Category: "Category 1"},
 						}))
 					})
 				})
@@ -688,30 +1909,1374 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 				})
 			})
 
-			Describe("GetUserTaskCategory", func() {
-				When("sending without cookie", func() {
-					It("should return status code 401", func() {
-						r, _ := http.NewRequest("GET", "/api/v1/user/tasks", nil)
+			Describe("Email verification", func() {
+				When("an unverified account logs in", func() {
+					It("should return 403 until the account is verified", func() {
+						body, _ := json.Marshal(model.UserRegister{Fullname: "member", Email: "Member@Mail.com", Password: "member123"})
+						r := httptest.NewRequest("POST", "/api/v1/user/register", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusCreated))
+
+						login := func() int {
+							body, _ := json.Marshal(model.UserLogin{Email: "member@mail.com", Password: "member123"})
+							r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							return w.Code
+						}
+						Expect(login()).To(Equal(http.StatusForbidden))
+
+						member, err := userRepo.GetUserByEmail("member@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						token, err := tokenService.IssueEmailVerification(member.ID, member.Email)
+						Expect(err).ShouldNot(HaveOccurred())
+
+						body, _ = json.Marshal(model.VerifyEmailRequest{Token: token})
+						r = httptest.NewRequest("POST", "/api/v1/user/verify", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						Expect(login()).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("registering with a malformed email", func() {
+					It("should return status code 400", func() {
+						body, _ := json.Marshal(model.UserRegister{Fullname: "member", Email: "not-an-email", Password: "member123"})
+						r := httptest.NewRequest("POST", "/api/v1/user/register", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+			})
+
+			Describe("Logout", func() {
+				When("logging out with a valid session", func() {
+					It("should revoke the session so the token is no longer accepted", func() {
+						cookie := SetCookie(apiServer)
+
+						r, _ := http.NewRequest("POST", "/api/v1/user/logout", nil)
+						r.AddCookie(cookie)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.AddCookie(cookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("logging out from all devices", func() {
+					It("should revoke every session of the user", func() {
+						first := SetCookie(apiServer)
+						second := SetCookie(apiServer)
+
+						r, _ := http.NewRequest("POST", "/api/v1/user/logout/all", nil)
+						r.AddCookie(second)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						for _, cookie := range []*http.Cookie{first, second} {
+							r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+							r.AddCookie(cookie)
+							w = httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							Expect(w.Code).To(Equal(http.StatusUnauthorized))
+						}
+					})
+				})
+			})
+
+			Describe("Refresh", func() {
+				refresh := func(refreshToken string) (*httptest.ResponseRecorder, model.LoginResponse) {
+					body, _ := json.Marshal(model.RefreshRequest{RefreshToken: refreshToken})
+					r := httptest.NewRequest("POST", "/api/v1/user/refresh", bytes.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+
+					var resp model.LoginResponse
+					_ = json.Unmarshal(w.Body.Bytes(), &resp)
+					return w, resp
+				}
+
+				taskList := func(accessToken string) int {
+					r, _ := http.NewRequest("GET", "/api/v1/task/list", nil)
+					r.AddCookie(&http.Cookie{Name: "session_token", Value: accessToken})
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w.Code
+				}
+
+				When("exchanging a valid refresh token", func() {
+					It("should rotate both tokens and invalidate the previous access token", func() {
+						login, err := userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(login.ExpiresAt).To(BeTemporally("<", login.RefreshExpiresAt))
+
+						w, rotated := refresh(login.RefreshToken)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(rotated.RefreshToken).NotTo(Equal(login.RefreshToken))
+						Expect(rotated.AccessToken).NotTo(Equal(login.AccessToken))
+
+						Expect(taskList(login.AccessToken)).To(Equal(http.StatusUnauthorized))
+						Expect(taskList(rotated.AccessToken)).To(Equal(http.StatusOK))
+
+						session, err := sessionRepo.SessionAvailToken(rotated.AccessToken)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(session.RefreshTokenHash).To(Equal(tokenService.HashRefreshToken(rotated.RefreshToken)))
+					})
+				})
+
+				When("replaying a refresh token that was already exchanged", func() {
+					It("should reject it and revoke the whole token family", func() {
+						login, err := userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+
+						w, rotated := refresh(login.RefreshToken)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						w, _ = refresh(login.RefreshToken)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						Expect(taskList(rotated.AccessToken)).To(Equal(http.StatusUnauthorized))
+						w, _ = refresh(rotated.RefreshToken)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("sending an unknown refresh token", func() {
+					It("should return status code 401", func() {
+						w, _ := refresh("not-a-refresh-token")
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+			})
+
+			Describe("Sessions", func() {
+				When("logging in from two devices", func() {
+					It("should keep both sessions valid and list them separately", func() {
+						login := func(userAgent string) *http.Cookie {
+							body, _ := json.Marshal(model.UserLogin{Email: "test@mail.com", Password: "testing123"})
+							r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							r.Header.Set("User-Agent", userAgent)
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							Expect(w.Code).To(Equal(http.StatusOK))
+							for _, c := range w.Result().Cookies() {
+								if c.Name == "session_token" {
+									return c
+								}
+							}
+							return nil
+						}
+
+						laptop := login("laptop-browser")
+						phone := login("phone-browser")
+
+						r, _ := http.NewRequest("GET", "/api/v1/user/sessions", nil)
+						r.AddCookie(laptop)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						var sessions []model.SessionInfo
+						Expect(json.Unmarshal(w.Body.Bytes(), &sessions)).Should(Succeed())
+						Expect(sessions).To(HaveLen(2))
+						Expect(sessions[0].UserAgent).To(Equal("laptop-browser"))
+						Expect(sessions[0].Current).To(BeTrue())
+						Expect(sessions[1].UserAgent).To(Equal("phone-browser"))
+						Expect(sessions[1].Current).To(BeFalse())
+						Expect(w.Body.String()).NotTo(ContainSubstring(phone.Value))
+
+						r, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/v1/user/sessions/%d", sessions[1].ID), nil)
+						r.AddCookie(laptop)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.AddCookie(phone)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.AddCookie(laptop)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("revoking a session of another user", func() {
+					It("should return status code 404", func() {
+						Expect(sessionRepo.AddSessions(model.Session{
+							Token:  "someone-elses-token",
+							Email:  "other@mail.com",
+							Expiry: time.Now().Add(time.Hour),
+						})).Should(Succeed())
+						sessions, err := sessionRepo.SessionsByEmail("other@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(sessions).To(HaveLen(1))
+
+						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/user/sessions/%d", sessions[0].ID), nil)
+						r.AddCookie(SetCookie(apiServer))
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusNotFound))
+
+						_, err = sessionRepo.SessionAvailToken("someone-elses-token")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+			})
+
+			Describe("Password Reset", func() {
+				var (
+					messages <-chan string
+					stop     func()
+				)
+
+				BeforeEach(func() {
+					var host, port string
+					host, port, messages, stop = startSMTPStandIn()
+
+					// Rebuild the server so that it mails through the stand-in.
+					os.Setenv("SMTP_HOST", host)
+					os.Setenv("SMTP_PORT", port)
+					apiServer = gin.New()
+					apiServer = main.RunServer(apiServer, store)
+				})
+
+				AfterEach(func() {
+					os.Unsetenv("SMTP_HOST")
+					os.Unsetenv("SMTP_PORT")
+					stop()
+				})
+
+				requestReset := func(email string) *httptest.ResponseRecorder {
+					body, _ := json.Marshal(model.ForgotPasswordRequest{Email: email})
+					r := httptest.NewRequest("POST", "/api/v1/user/password/forgot", bytes.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w
+				}
+
+				resetPassword := func(token, password string) *httptest.ResponseRecorder {
+					body, _ := json.Marshal(model.ResetPasswordRequest{Token: token, Password: password})
+					r := httptest.NewRequest("POST", "/api/v1/user/password/reset", bytes.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w
+				}
+
+				tokenFromMail := func(mail string) string {
+					return linkToken(mail, "/client/password/reset")
+				}
+
+				When("resetting with the mailed token", func() {
+					It("should change the password, revoke sessions and reject the token afterwards", func() {
+						oldCookie := SetCookie(apiServer)
+
+						Expect(requestReset("test@mail.com").Code).To(Equal(http.StatusOK))
+						var mail string
+						Eventually(messages).Should(Receive(&mail))
+						Expect(mail).To(ContainSubstring("To: test@mail.com"))
+						token := tokenFromMail(mail)
+
+						Expect(resetPassword(token, "newpass123").Code).To(Equal(http.StatusOK))
+						Expect(resetPassword(token, "again123").Code).To(Equal(http.StatusBadRequest))
+
+						r, _ := http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.AddCookie(oldCookie)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						_, err := userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+						Expect(err).To(MatchError(service.ErrInvalidCredentials))
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "newpass123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("a newer reset is requested", func() {
+					It("should invalidate the older token", func() {
+						Expect(requestReset("test@mail.com").Code).To(Equal(http.StatusOK))
+						var first string
+						Eventually(messages).Should(Receive(&first))
+
+						Expect(requestReset("test@mail.com").Code).To(Equal(http.StatusOK))
+						var second string
+						Eventually(messages).Should(Receive(&second))
+
+						Expect(resetPassword(tokenFromMail(first), "newpass123").Code).To(Equal(http.StatusBadRequest))
+						Expect(resetPassword(tokenFromMail(second), "newpass123").Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("the email is not registered", func() {
+					It("should answer the same and send nothing", func() {
+						unknown := requestReset("nobody@mail.com")
+						known := requestReset("test@mail.com")
+						Expect(unknown.Code).To(Equal(http.StatusOK))
+						Expect(unknown.Body.String()).To(Equal(known.Body.String()))
+
+						Eventually(messages).Should(Receive())
+						Consistently(messages, "200ms").ShouldNot(Receive())
+					})
+				})
+
+				When("the mail server is slow", func() {
+					It("should answer before the mail goes out", func() {
+						held := heldMailer{release: make(chan struct{}), sent: make(chan mailer.Message, 1)}
+						resets := service.NewPasswordResetService(userRepo, repo.NewPasswordResetRepo(store), sessionRepo, loginAttemptRepo, held)
+
+						Expect(resets.RequestReset("test@mail.com")).Should(Succeed())
+						Consistently(held.sent, "100ms").ShouldNot(Receive())
+
+						close(held.release)
+						var msg mailer.Message
+						Eventually(held.sent).Should(Receive(&msg))
+						Expect(msg.To).To(Equal("test@mail.com"))
+					})
+				})
+
+				When("the token is unknown", func() {
+					It("should return status code 400", func() {
+						Expect(resetPassword("not-a-token", "newpass123").Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+			})
+
+			Describe("Access Tokens", func() {
+				When("creating, using and revoking a token", func() {
+					It("should authenticate with the token until it is revoked", func() {
+						body, _ := json.Marshal(model.AccessTokenRequest{Name: "ci"})
+						r, _ := http.NewRequest("POST", "/api/v1/user/tokens", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(SetCookie(apiServer))
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusCreated))
+
+						var created model.AccessTokenCreated
+						Expect(json.Unmarshal(w.Body.Bytes(), &created)).Should(Succeed())
+						Expect(created.Token).To(HavePrefix(service.AccessTokenPrefix))
+						Expect(created.Scope).To(Equal(model.ScopeReadWrite))
+
+						r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.Header.Set("Authorization", "Bearer "+created.Token)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						r, _ = http.NewRequest("POST", "/api/v1/user/tokens", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						r.Header.Set("Authorization", "Bearer "+created.Token)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						r, _ = http.NewRequest("GET", "/api/v1/user/tokens", nil)
+						r.AddCookie(SetCookie(apiServer))
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Body.String()).NotTo(ContainSubstring(created.Token))
+
+						var tokens []model.PersonalAccessToken
+						Expect(json.Unmarshal(w.Body.Bytes(), &tokens)).Should(Succeed())
+						Expect(tokens).To(HaveLen(1))
+						Expect(tokens[0].Name).To(Equal("ci"))
+
+						r, _ = http.NewRequest("DELETE", fmt.Sprintf("/api/v1/user/tokens/%d", created.ID), nil)
+						r.AddCookie(SetCookie(apiServer))
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						r, _ = http.NewRequest("GET", "/api/v1/task/list", nil)
+						r.Header.Set("Authorization", "Bearer "+created.Token)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("revoking a token of another user", func() {
+					It("should return status code 404", func() {
+						created, err := accessTokenService.Create(2, "other@mail.com", model.AccessTokenRequest{Name: "other"})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/user/tokens/%d", created.ID), nil)
+						r.AddCookie(SetCookie(apiServer))
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusNotFound))
+					})
+				})
+			})
+
+			Describe("Two-Factor Login", func() {
+				postJSON := func(path string, payload interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+					body, _ := json.Marshal(payload)
+					r, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					if cookie != nil {
+						r.AddCookie(cookie)
+					}
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w
+				}
+
+				// enable turns two-factor login on for the test user and
+				// returns the secret, the recovery codes and the time the
+				// first code was generated for.
+				enable := func() (string, []string, time.Time) {
+					w := postJSON("/api/v1/user/2fa/enroll", nil, SetCookie(apiServer))
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					var enrollment model.TwoFactorEnrollment
+					Expect(json.Unmarshal(w.Body.Bytes(), &enrollment)).Should(Succeed())
+					Expect(enrollment.OTPAuthURI).To(HavePrefix("otpauth://totp/"))
+					Expect(enrollment.OTPAuthURI).To(ContainSubstring("secret=" + enrollment.Secret))
+					Expect(enrollment.QRPayload).To(Equal(enrollment.OTPAuthURI))
+
+					now := time.Now()
+					w = postJSON("/api/v1/user/2fa/confirm", model.TwoFactorCodeRequest{Code: totpAt(enrollment.Secret, now)}, SetCookie(apiServer))
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					var recovery model.RecoveryCodes
+					Expect(json.Unmarshal(w.Body.Bytes(), &recovery)).Should(Succeed())
+					Expect(recovery.RecoveryCodes).To(HaveLen(10))
+
+					user, err := userRepo.GetUserByID(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.TOTPEnabled).To(BeTrue())
+					for _, code := range recovery.RecoveryCodes {
+						Expect(user.RecoveryCodeHashes).NotTo(ContainElement(code))
+					}
+
+					return enrollment.Secret, recovery.RecoveryCodes, now
+				}
+
+				challenge := func() string {
+					w := postJSON("/api/v1/user/login", model.UserLogin{Email: "test@mail.com", Password: "testing123"}, nil)
+					Expect(w.Code).To(Equal(http.StatusAccepted))
+					Expect(w.Result().Cookies()).To(BeEmpty())
+
+					var response model.TwoFactorChallenge
+					Expect(json.Unmarshal(w.Body.Bytes(), &response)).Should(Succeed())
+					Expect(response.Challenge).NotTo(BeEmpty())
+					return response.Challenge
+				}
+
+				When("enrolling and logging in with a code", func() {
+					It("should issue the session only after a valid, unused code", func() {
+						secret, _, confirmedAt := enable()
+
+						w := postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: "000000"}, nil)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						// The confirming code has been used already.
+						w = postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: totpAt(secret, confirmedAt)}, nil)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						next := totpAt(secret, confirmedAt.Add(30*time.Second))
+						w = postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: next}, nil)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						var login model.LoginResponse
+						Expect(json.Unmarshal(w.Body.Bytes(), &login)).Should(Succeed())
+						Expect(login.AccessToken).NotTo(BeEmpty())
+
+						w = postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: next}, nil)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("logging in with a recovery code", func() {
+					It("should accept each recovery code once", func() {
+						_, codes, _ := enable()
+
+						w := postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: codes[0]}, nil)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						w = postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: challenge(), Code: codes[0]}, nil)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						user, err := userRepo.GetUserByID(1)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.RecoveryCodeHashes).To(HaveLen(9))
+					})
+				})
+
+				When("the email was changed and is not verified yet", func() {
+					It("should refuse the login with or without a code", func() {
+						cookie := SetCookie(apiServer)
+						secret, _, confirmedAt := enable()
+
+						body, _ := json.Marshal(model.ProfileUpdateRequest{Email: "new@mail.com", CurrentPassword: "testing123"})
+						r := httptest.NewRequest("PUT", "/api/v1/user/me", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(cookie)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						w = postJSON("/api/v1/user/login", model.UserLogin{Email: "new@mail.com", Password: "testing123"}, nil)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						// A challenge issued for the new address before it was
+						// verified gets no further.
+						pending, err := tokenService.IssueLoginChallenge(1, "new@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						w = postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: pending, Code: totpAt(secret, confirmedAt.Add(30*time.Second))}, nil)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+					})
+				})
+
+				When("sending a forged challenge", func() {
+					It("should return status code 401", func() {
+						enable()
+
+						verification, err := tokenService.IssueEmailVerification(1, "test@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+
+						w := postJSON("/api/v1/user/login/2fa", model.TwoFactorLoginRequest{Challenge: verification, Code: "000000"}, nil)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("a session guesses codes to turn two-factor login off", func() {
+					It("should lock it out like a failed login", func() {
+						cookie := SetCookie(apiServer)
+						secret, _, confirmedAt := enable()
+
+						for i := 0; i < 5; i++ {
+							w := postJSON("/api/v1/user/2fa/disable", model.TwoFactorCodeRequest{Code: fmt.Sprintf("%06d", i)}, cookie)
+							Expect(w.Code).To(Equal(http.StatusBadRequest))
+						}
+
+						next := totpAt(secret, confirmedAt.Add(30*time.Second))
+						w := postJSON("/api/v1/user/2fa/disable", model.TwoFactorCodeRequest{Code: next}, cookie)
+						Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+						Expect(w.Header().Get("Retry-After")).To(Equal("30"))
+
+						user, err := userRepo.GetUserByID(1)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.TOTPEnabled).To(BeTrue())
+					})
+				})
+
+				When("confirming with a wrong code", func() {
+					It("should keep two-factor login off", func() {
+						w := postJSON("/api/v1/user/2fa/enroll", nil, SetCookie(apiServer))
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						w = postJSON("/api/v1/user/2fa/confirm", model.TwoFactorCodeRequest{Code: "abcdef"}, SetCookie(apiServer))
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+						w = postJSON("/api/v1/user/login", model.UserLogin{Email: "test@mail.com", Password: "testing123"}, nil)
+						Expect(w.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+
+			Describe("Profile", func() {
+				send := func(method, path string, payload interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+					var body []byte
+					if payload != nil {
+						body, _ = json.Marshal(payload)
+					}
+					r := httptest.NewRequest(method, path, bytes.NewReader(body))
+					r.Header.Set("Content-Type", "application/json")
+					if cookie != nil {
+						r.AddCookie(cookie)
+					}
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w
+				}
+
+				When("reading and renaming the profile", func() {
+					It("should return the profile without secrets and keep UpdatedAt current", func() {
+						cookie := SetCookie(apiServer)
+
+						w := send("GET", "/api/v1/user/me", nil, cookie)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Body.String()).NotTo(ContainSubstring("password"))
+
+						var profile model.Profile
+						Expect(json.Unmarshal(w.Body.Bytes(), &profile)).Should(Succeed())
+						Expect(profile.Email).To(Equal("test@mail.com"))
+						Expect(profile.Role).To(Equal(model.RoleAdmin))
+
+						w = send("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Fullname: "Renamed"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						var updated model.Profile
+						Expect(json.Unmarshal(w.Body.Bytes(), &updated)).Should(Succeed())
+						Expect(updated.Fullname).To(Equal("Renamed"))
+						Expect(updated.UpdatedAt.After(profile.UpdatedAt)).To(BeTrue())
+					})
+				})
+
+				When("changing the email", func() {
+					It("should require the password, sign out and ask for verification", func() {
+						cookie := SetCookie(apiServer)
+
+						w := send("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Email: "new@mail.com"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						registerVerified("other", "taken@mail.com", "other123")
+						w = send("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Email: "taken@mail.com", CurrentPassword: "testing123"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusConflict))
+
+						created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci"})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						w = send("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Email: " New@Mail.com ", CurrentPassword: "testing123"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						pat, err := accessTokenService.Authenticate(created.Token)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(pat.Email).To(Equal("new@mail.com"))
+
+						w = send("GET", "/api/v1/user/me", nil, cookie)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						_, err = userService.Login(&model.User{Email: "new@mail.com", Password: "testing123"}, "", "")
+						Expect(err).To(MatchError(service.ErrEmailNotVerified))
+					})
+				})
+
+				When("changing the password", func() {
+					It("should keep the current session and revoke the others and every access token", func() {
+						current := SetCookie(apiServer)
+						other := SetCookie(apiServer)
+						created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci"})
+						Expect(err).ShouldNot(HaveOccurred())
+
+						w := send("PUT", "/api/v1/user/me/password", model.PasswordChangeRequest{CurrentPassword: "wrong", NewPassword: "changed123"}, current)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						w = send("PUT", "/api/v1/user/me/password", model.PasswordChangeRequest{CurrentPassword: "testing123", NewPassword: "changed123"}, current)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						Expect(send("GET", "/api/v1/user/me", nil, current).Code).To(Equal(http.StatusOK))
+						Expect(send("GET", "/api/v1/user/me", nil, other).Code).To(Equal(http.StatusUnauthorized))
+						_, err = accessTokenService.Authenticate(created.Token)
+						Expect(err).To(HaveOccurred())
+
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+						Expect(err).To(MatchError(service.ErrInvalidCredentials))
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "changed123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("an access token tries to change the profile or the password", func() {
+					It("should return status code 403", func() {
+						created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci"})
+						Expect(err).ShouldNot(HaveOccurred())
+						bearer := func(method, path string, payload interface{}) int {
+							body, _ := json.Marshal(payload)
+							r := httptest.NewRequest(method, path, bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							r.Header.Set("Authorization", "Bearer "+created.Token)
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							return w.Code
+						}
+
+						Expect(bearer("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Email: "new@mail.com", CurrentPassword: "testing123"})).To(Equal(http.StatusForbidden))
+						Expect(bearer("PUT", "/api/v1/user/me/password", model.PasswordChangeRequest{CurrentPassword: "testing123", NewPassword: "changed123"})).To(Equal(http.StatusForbidden))
+
+						_, err = userService.Login(&model.User{Email: "test@mail.com", Password: "testing123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("an account without a password makes a change", func() {
+					It("should only allow it shortly after logging in", func() {
+						tokens, err := userService.LoginSSO("sso-fresh", "sso@mail.com", "SSO User", "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+						withSession := func(method, path string, payload interface{}) int {
+							body, _ := json.Marshal(payload)
+							r := httptest.NewRequest(method, path, bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							r.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							return w.Code
+						}
+
+						session, err := sessionRepo.SessionAvailToken(tokens.AccessToken)
+						Expect(err).ShouldNot(HaveOccurred())
+						session.CreatedAt = time.Now().Add(-time.Hour)
+						Expect(sessionRepo.UpdateSessions(session)).Should(Succeed())
+						Expect(withSession("PUT", "/api/v1/user/me", model.ProfileUpdateRequest{Email: "taken-over@mail.com"})).To(Equal(http.StatusForbidden))
+						Expect(withSession("PUT", "/api/v1/user/me/password", model.PasswordChangeRequest{NewPassword: "changed123"})).To(Equal(http.StatusForbidden))
+
+						session.CreatedAt = time.Now()
+						Expect(sessionRepo.UpdateSessions(session)).Should(Succeed())
+						Expect(withSession("PUT", "/api/v1/user/me/password", model.PasswordChangeRequest{NewPassword: "changed123"})).To(Equal(http.StatusOK))
+						_, err = userService.Login(&model.User{Email: "sso@mail.com", Password: "changed123"}, "", "")
+						Expect(err).ShouldNot(HaveOccurred())
+					})
+				})
+
+				When("deleting the account", func() {
+					It("should remove the user with its tasks, sessions, tokens and categories", func() {
+						cookie := SetCookie(apiServer)
+						created, err := accessTokenService.Create(1, "test@mail.com", model.AccessTokenRequest{Name: "ci"})
+						Expect(err).ShouldNot(HaveOccurred())
+						w := send("POST", "/api/v1/category/add", model.Category{Name: "Mine", UserID: 2}, cookie)
+						Expect(w.Code).To(Equal(http.StatusCreated))
+						var owned model.Category
+						Expect(json.Unmarshal(w.Body.Bytes(), &owned)).Should(Succeed())
+						Expect(owned.UserID).To(Equal(1))
+
+						w = send("DELETE", "/api/v1/user/me", model.DeleteAccountRequest{Password: "wrong"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						r := httptest.NewRequest("DELETE", "/api/v1/user/me", strings.NewReader(`{"password":"testing123"}`))
+						r.Header.Set("Content-Type", "application/json")
+						r.Header.Set("Authorization", "Bearer "+created.Token)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						w = send("DELETE", "/api/v1/user/me", model.DeleteAccountRequest{Password: "testing123"}, cookie)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						_, err = userRepo.GetUserByID(1)
+						Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+						tasks, err := store.GetTasks()
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(tasks).To(HaveLen(3))
+						for _, task := range tasks {
+							Expect(task.UserID).NotTo(Equal(1))
+						}
+
+						categories, err := store.GetCategories()
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(categories).To(HaveLen(5))
+
+						sessions, err := sessionRepo.SessionsByEmail("test@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(sessions).To(BeEmpty())
+
+						_, err = accessTokenService.Authenticate(created.Token)
+						Expect(err).To(HaveOccurred())
+					})
+				})
+			})
+
+			Describe("Single Sign-On", func() {
+				var issuer *mockIssuer
+
+				BeforeEach(func() {
+					issuer = startMockIssuer("task-tracker")
+
+					// Rebuild the server so that it offers single sign-on.
+					os.Setenv("OIDC_ISSUER", issuer.server.URL)
+					os.Setenv("OIDC_CLIENT_ID", "task-tracker")
+					apiServer = gin.New()
+					apiServer = main.RunServer(apiServer, store)
+				})
+
+				AfterEach(func() {
+					os.Unsetenv("OIDC_ISSUER")
+					os.Unsetenv("OIDC_CLIENT_ID")
+					issuer.server.Close()
+				})
+
+				// begin starts a login and returns where the browser is sent
+				// together with the state cookie it is given.
+				begin := func() (string, *http.Cookie) {
+					r := httptest.NewRequest("GET", "/api/v1/user/oidc/login", nil)
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					Expect(w.Code).To(Equal(http.StatusFound))
+
+					var stateCookie *http.Cookie
+					for _, c := range w.Result().Cookies() {
+						if c.Name == "oidc_state" {
+							stateCookie = c
+						}
+					}
+					Expect(stateCookie).NotTo(BeNil())
+					return w.Header().Get("Location"), stateCookie
+				}
+
+				callback := func(code, state string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+					r := httptest.NewRequest("GET", "/api/v1/user/oidc/callback?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(state), nil)
+					if stateCookie != nil {
+						r.AddCookie(stateCookie)
+					}
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					return w
+				}
+
+				sessionCookie := func(w *httptest.ResponseRecorder) *http.Cookie {
+					for _, c := range w.Result().Cookies() {
+						if c.Name == "session_token" {
+							return c
+						}
+					}
+					return nil
+				}
+
+				When("a new user logs in through the provider", func() {
+					It("should create a member account and start a session", func() {
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{
+							"sub":            "sso-1",
+							"email":          "SSO@Mail.com",
+							"email_verified": true,
+							"name":           "SSO User",
+						})
+
+						w := callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusSeeOther))
+						Expect(w.Header().Get("Location")).To(Equal("/client/dashboard"))
+
+						cookie := sessionCookie(w)
+						Expect(cookie).NotTo(BeNil())
+						r := httptest.NewRequest("GET", "/api/v1/user/tasks/me", nil)
+						r.AddCookie(cookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						user, err := userRepo.GetUserByEmail("sso@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.Fullname).To(Equal("SSO User"))
+						Expect(user.OIDCSubject).To(Equal("sso-1"))
+						Expect(user.Role).To(Equal(model.RoleMember))
+
+						_, err = userService.Login(&model.User{Email: "sso@mail.com", Password: ""}, "", "")
+						Expect(err).To(MatchError(service.ErrInvalidCredentials))
+					})
+				})
+
+				When("the email belongs to an existing account", func() {
+					It("should link the account and refuse a different subject later", func() {
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{"sub": "sso-2", "email": "test@mail.com", "email_verified": true})
+						w := callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusSeeOther))
+						Expect(sessionCookie(w)).NotTo(BeNil())
+
+						user, err := userRepo.GetUserByID(1)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.OIDCSubject).To(Equal("sso-2"))
+						Expect(user.Role).To(Equal(model.RoleAdmin))
+
+						location, stateCookie = begin()
+						code, state = issuer.authorize(location, jwt.MapClaims{"sub": "someone-else", "email": "test@mail.com", "email_verified": true})
+						w = callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+						Expect(sessionCookie(w)).To(BeNil())
+					})
+				})
+
+				When("the existing account has two-factor login", func() {
+					It("should ask for a code before starting a session", func() {
+						const secret = "JBSWY3DPEHPK3PXP"
+						user, err := userRepo.GetUserByID(1)
+						Expect(err).ShouldNot(HaveOccurred())
+						user.TOTPSecret = secret
+						user.TOTPEnabled = true
+						Expect(userRepo.UpdateUser(user)).Should(Succeed())
+
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{"sub": "sso-2fa", "email": "test@mail.com", "email_verified": true})
+						w := callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusSeeOther))
+						Expect(w.Header().Get("Location")).To(Equal("/client/login/2fa"))
+						Expect(sessionCookie(w)).To(BeNil())
+
+						var challenge *http.Cookie
+						for _, c := range w.Result().Cookies() {
+							if c.Name == "login_challenge" {
+								challenge = c
+							}
+						}
+						Expect(challenge).NotTo(BeNil())
+						Expect(challenge.Path).To(Equal("/client/login/2fa"))
+
+						body, _ := json.Marshal(model.TwoFactorLoginRequest{Challenge: challenge.Value, Code: "000000"})
+						r := httptest.NewRequest("POST", "/api/v1/user/login/2fa", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						body, _ = json.Marshal(model.TwoFactorLoginRequest{Challenge: challenge.Value, Code: totpAt(secret, time.Now())})
+						r = httptest.NewRequest("POST", "/api/v1/user/login/2fa", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("the provider has not verified the email", func() {
+					It("should return status code 403", func() {
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{"sub": "sso-3", "email": "test@mail.com", "email_verified": false})
+						w := callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+					})
+				})
+
+				When("the ID token carries another nonce", func() {
+					It("should return status code 401", func() {
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{"sub": "sso-4", "email": "nonce@mail.com", "email_verified": true, "nonce": "replayed"})
+						w := callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("the callback comes without the state cookie or twice", func() {
+					It("should return status code 400", func() {
+						location, stateCookie := begin()
+						code, state := issuer.authorize(location, jwt.MapClaims{"sub": "sso-5", "email": "state@mail.com", "email_verified": true})
+
+						w := callback(code, state, nil)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+						w = callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusSeeOther))
+
+						w = callback(code, state, stateCookie)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+			})
+
+			Describe("GetUserTaskCategory", func() {
+				When("sending without cookie", func() {
+					It("should return status code 401", func() {
+						r, _ := http.NewRequest("GET", "/api/v1/user/tasks", nil)
+						w := httptest.NewRecorder()
+						r.Header.Set("Content-Type", "application/json")
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+					})
+				})
+
+				When("retrieving user list by task and category", func() {
+					It("should return status code 200 and task list", func() {
+						r, _ := http.NewRequest("GET", "/api/v1/user/tasks", nil)
+						w := httptest.NewRecorder()
+
+						r.AddCookie(SetCookie(apiServer))
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						var userTasks []model.UserTaskCategory
+						Expect(json.Unmarshal(w.Body.Bytes(), &userTasks)).Should(Succeed())
+						Expect(userTasks).To(Equal(expectedUserTask))
+					})
+				})
+			})
+
+			Describe("Roles", func() {
+				var memberCookie func() *http.Cookie
+
+				BeforeEach(func() {
+					registerVerified("member", "member@mail.com", "member123")
+
+					memberCookie = func() *http.Cookie {
+						body, _ := json.Marshal(model.UserLogin{Email: "member@mail.com", Password: "member123"})
+						r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						for _, c := range w.Result().Cookies() {
+							if c.Name == "session_token" {
+								return c
+							}
+						}
+						return nil
+					}
+				})
+
+				When("a member calls admin endpoints", func() {
+					It("should return status code 403", func() {
+						cookie := memberCookie()
+
+						r, _ := http.NewRequest("GET", "/api/v1/user/tasks", nil)
+						r.AddCookie(cookie)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						body, _ := json.Marshal(model.Category{Name: "Member Category"})
+						r, _ = http.NewRequest("POST", "/api/v1/category/add", bytes.NewReader(body))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(cookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						r, _ = http.NewRequest("PUT", "/api/v1/admin/users/1/role", bytes.NewReader([]byte(`{"role":"member"}`)))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(cookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusForbidden))
+
+						r, _ = http.NewRequest("GET", "/api/v1/category/list", nil)
+						r.AddCookie(cookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("a member lists their own tasks", func() {
+					It("should only return the member's tasks", func() {
+						r, _ := http.NewRequest("GET", "/api/v1/user/tasks/me", nil)
+						r.AddCookie(memberCookie())
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						// The member is user 2, which owns "Task 1" in the seed data.
+						var memberTasks []model.UserTaskCategory
+						Expect(json.Unmarshal(w.Body.Bytes(), &memberTasks)).Should(Succeed())
+						Expect(memberTasks).To(HaveLen(1))
+						Expect(memberTasks[0].Email).To(Equal("member@mail.com"))
+						Expect(memberTasks[0].Task).To(Equal("Task 1"))
+
+						r, _ = http.NewRequest("GET", "/api/v1/user/tasks/me", nil)
+						r.AddCookie(SetCookie(apiServer))
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						var userTasks []model.UserTaskCategory
+						Expect(json.Unmarshal(w.Body.Bytes(), &userTasks)).Should(Succeed())
+						Expect(userTasks).To(Equal(expectedUserTask))
+					})
+				})
+
+				When("an admin promotes a member", func() {
+					It("should revoke the member's sessions and grant admin access", func() {
+						oldCookie := memberCookie()
+						member, err := userRepo.GetUserByEmail("member@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(member.Role).To(Equal(model.RoleMember))
+
+						r, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/admin/users/%d/role", member.ID), bytes.NewReader([]byte(`{"role":"admin"}`)))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(SetCookie(apiServer))
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						r, _ = http.NewRequest("GET", "/api/v1/user/tasks", nil)
+						r.AddCookie(oldCookie)
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+						r, _ = http.NewRequest("GET", "/api/v1/user/tasks", nil)
+						r.AddCookie(memberCookie())
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("a member's account is locked out", func() {
+					It("should refuse even the right password until an admin unlocks it", func() {
+						login := func(password string) *httptest.ResponseRecorder {
+							body, _ := json.Marshal(model.UserLogin{Email: "member@mail.com", Password: password})
+							r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							return w
+						}
+
+						for i := 0; i < 5; i++ {
+							Expect(login("wrong").Code).To(Equal(http.StatusUnauthorized))
+						}
+
+						w := login("member123")
+						Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+						Expect(w.Header().Get("Retry-After")).To(Equal("30"))
+
+						member, err := userRepo.GetUserByEmail("member@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+
+						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/admin/users/%d/lockout", member.ID), nil)
+						r.AddCookie(SetCookie(apiServer))
+						w = httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						Expect(login("member123").Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				When("logging in with an unknown email", func() {
+					It("should answer like a wrong password", func() {
+						login := func(email, password string) *httptest.ResponseRecorder {
+							body, _ := json.Marshal(model.UserLogin{Email: email, Password: password})
+							r := httptest.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+							r.Header.Set("Content-Type", "application/json")
+							w := httptest.NewRecorder()
+							apiServer.ServeHTTP(w, r)
+							return w
+						}
+
+						unknown := login("nobody@mail.com", "member123")
+						wrong := login("member@mail.com", "wrong")
+						Expect(unknown.Code).To(Equal(http.StatusUnauthorized))
+						Expect(unknown.Body.String()).To(Equal(wrong.Body.String()))
+					})
+				})
+
+				When("setting an unknown role", func() {
+					It("should return status code 400", func() {
+						r, _ := http.NewRequest("PUT", "/api/v1/admin/users/1/role", bytes.NewReader([]byte(`{"role":"owner"}`)))
+						r.Header.Set("Content-Type", "application/json")
+						r.AddCookie(SetCookie(apiServer))
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+			})
+		})
+
+		Describe("Backup API", Label("file"), func() {
+			When("downloading a backup", func() {
+				It("should stream a snapshot that opens as a database", func() {
+					r, _ := http.NewRequest("GET", "/api/v1/admin/backup", nil)
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					Expect(w.Code).To(Equal(http.StatusUnauthorized))
+
+					cookie := SetCookie(apiServer)
+					for _, compress := range []bool{false, true} {
+						r, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/admin/backup?gzip=%t", compress), nil)
+						r.AddCookie(cookie)
+						w := httptest.NewRecorder()
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+						Expect(w.Header().Get("Content-Disposition")).To(ContainSubstring("attachment"))
+
+						path := filepath.Join(GinkgoT().TempDir(), "restored.db")
+						Expect(filebased.Restore(path, w.Body)).Should(Succeed())
+
+						restored, err := filebased.Open(path)
+						Expect(err).ShouldNot(HaveOccurred())
+						user, err := restored.GetUserByEmail("test@mail.com")
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(user.ID).To(Equal(1))
+						Expect(restored.CloseDB()).Should(Succeed())
+					}
+				})
+			})
+
+			When("a member downloads a backup", func() {
+				It("should return status code 403", func() {
+					registerVerified("member", "member@mail.com", "testing123")
+					body, _ := json.Marshal(model.UserLogin{Email: "member@mail.com", Password: "testing123"})
+					r, _ := http.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+					w := httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					Expect(w.Code).To(Equal(http.StatusOK))
+
+					r, _ = http.NewRequest("GET", "/api/v1/admin/backup", nil)
+					for _, c := range w.Result().Cookies() {
+						r.AddCookie(c)
+					}
+					w = httptest.NewRecorder()
+					apiServer.ServeHTTP(w, r)
+					Expect(w.Code).To(Equal(http.StatusForbidden))
+				})
+			})
+		})
+
+		Describe("Integrity API", Label("file"), func() {
+			When("an admin checks the database", func() {
+				It("should report problems and quarantine them on request", func() {
+					cookie := SetCookie(apiServer)
+					check := func(method, path string) model.IntegrityReport {
+						r, _ := http.NewRequest(method, path, nil)
+						r.AddCookie(cookie)
 						w := httptest.NewRecorder()
-						r.Header.Set("Content-Type", "application/json")
 						apiServer.ServeHTTP(w, r)
-						Expect(w.Code).To(Equal(http.StatusUnauthorized))
-					})
+						Expect(w.Code).To(Equal(http.StatusOK))
+						var report model.IntegrityReport
+						Expect(json.Unmarshal(w.Body.Bytes(), &report)).Should(Succeed())
+						return report
+					}
+
+					// The seeded tasks of users 2 to 4 have no user.
+					Expect(check("GET", "/api/v1/admin/integrity").Problems).To(HaveLen(3))
+					Expect(check("POST", "/api/v1/admin/integrity/quarantine").Problems).To(HaveLen(3))
+					Expect(check("GET", "/api/v1/admin/integrity").Problems).To(BeEmpty())
 				})
+			})
+		})
 
-				When("retrieving user list by task and category", func() {
-					It("should return status code 200 and task list", func() {
-						r, _ := http.NewRequest("GET", "/api/v1/user/tasks", nil)
-						w := httptest.NewRecorder()
+		Describe("Archive API", func() {
+			// exportArchive downloads the archive of the user the cookies
+			// belong to.
+			exportArchive := func(cookies []*http.Cookie) []byte {
+				r, _ := http.NewRequest("GET", "/api/v1/export", nil)
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				w := httptest.NewRecorder()
+				apiServer.ServeHTTP(w, r)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Header().Get("Content-Type")).To(Equal("application/zip"))
+				return w.Body.Bytes()
+			}
+
+			importArchive := func(cookies []*http.Cookie, mode string, body []byte) *httptest.ResponseRecorder {
+				r, _ := http.NewRequest("POST", "/api/v1/import?mode="+mode, bytes.NewReader(body))
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				w := httptest.NewRecorder()
+				apiServer.ServeHTTP(w, r)
+				return w
+			}
+
+			memberCookies := func() []*http.Cookie {
+				registerVerified("member", "member@mail.com", "testing123")
+				body, _ := json.Marshal(model.UserLogin{Email: "member@mail.com", Password: "testing123"})
+				r, _ := http.NewRequest("POST", "/api/v1/user/login", bytes.NewReader(body))
+				w := httptest.NewRecorder()
+				apiServer.ServeHTTP(w, r)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				return w.Result().Cookies()
+			}
 
-						r.AddCookie(SetCookie(apiServer))
-						apiServer.ServeHTTP(w, r)
-						Expect(w.Code).To(Equal(http.StatusOK))
+			When("exporting", func() {
+				It("should return the caller's data as JSON and CSV sheets", func() {
+					data := exportArchive([]*http.Cookie{SetCookie(apiServer)})
 
-						var userTasks []model.UserTaskCategory
-						Expect(json.Unmarshal(w.Body.Bytes(), &userTasks)).Should(Succeed())
-						Expect(userTasks).To(Equal(expectedUserTask))
-					})
+					zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+					Expect(err).ShouldNot(HaveOccurred())
+					var names []string
+					for _, f := range zr.File {
+						names = append(names, f.Name)
+					}
+					Expect(names).To(ConsistOf("archive.json", "tasks.csv", "categories.csv"))
+
+					archive, err := service.ReadArchive(data)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(archive.Version).To(Equal(model.ArchiveVersion))
+					Expect(archive.Profile.Email).To(Equal("test@mail.com"))
+					Expect(archive.Tasks).To(Equal([]model.Task{insertTasks[1], insertTasks[4]}))
+					Expect(archive.Categories).To(Equal([]model.Category{insertCategories[1], insertCategories[2]}))
+
+					f, err := zr.Open("tasks.csv")
+					Expect(err).ShouldNot(HaveOccurred())
+					rows, err := csv.NewReader(f).ReadAll()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(rows).To(HaveLen(3))
+					Expect(rows[1]).To(Equal([]string{"2", "Task 2", "2023-06-01", "1", "Completed", "2", "Category 2"}))
+				})
+			})
+
+			When("importing into another account", func() {
+				It("should remap IDs and match categories by name", func() {
+					data := exportArchive([]*http.Cookie{SetCookie(apiServer)})
+					cookies := memberCookies()
+
+					w := importArchive(cookies, "merge", data)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					var result model.ImportResult
+					Expect(json.Unmarshal(w.Body.Bytes(), &result)).Should(Succeed())
+					Expect(result).To(Equal(model.ImportResult{CategoriesMatched: 2, TasksImported: 2}))
+
+					// The member already owned the seeded Task 1.
+					member, err := userRepo.GetUserByEmail("member@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					tasks, err := taskRepo.GetList(member.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(3))
+					Expect(tasks[1].ID).To(Equal(6))
+					Expect(tasks[1].Title).To(Equal("Task 2"))
+					Expect(tasks[1].CategoryID).To(Equal(2))
+
+					archive := model.Archive{
+						Version:    model.ArchiveVersion,
+						Profile:    model.Profile{Fullname: "Imported Member"},
+						Categories: []model.Category{{ID: 40, Name: "Imported"}},
+						Tasks:      []model.Task{{ID: 1, Title: "Seeded", CategoryID: 40}},
+					}
+					body, _ := json.Marshal(archive)
+					w = importArchive(cookies, "replace", body)
+					Expect(w.Code).To(Equal(http.StatusOK))
+					Expect(json.Unmarshal(w.Body.Bytes(), &result)).Should(Succeed())
+					Expect(result).To(Equal(model.ImportResult{CategoriesCreated: 1, TasksRemoved: 3, TasksImported: 1}))
+
+					tasks, err = taskRepo.GetList(member.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(1))
+					category, err := categoryRepo.GetByID(tasks[0].CategoryID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.Name).To(Equal("Imported"))
+					member, err = userRepo.GetUserByID(member.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(member.Fullname).To(Equal("Imported Member"))
+				})
+			})
+
+			When("importing an invalid archive", func() {
+				It("should return status code 400 and change nothing", func() {
+					cookies := []*http.Cookie{SetCookie(apiServer)}
+
+					for _, archive := range []model.Archive{
+						{Version: model.ArchiveVersion + 1},
+						{Version: model.ArchiveVersion, Tasks: []model.Task{{Title: "Orphan", CategoryID: 9}}},
+						{Version: model.ArchiveVersion, Categories: []model.Category{{ID: 1}}},
+					} {
+						body, _ := json.Marshal(archive)
+						Expect(importArchive(cookies, "replace", body).Code).To(Equal(http.StatusBadRequest))
+					}
+					Expect(importArchive(cookies, "merge", []byte("PK\x03\x04garbage")).Code).To(Equal(http.StatusBadRequest))
+					Expect(importArchive(cookies, "overwrite", []byte("{}")).Code).To(Equal(http.StatusBadRequest))
+
+					tasks, err := taskRepo.GetList(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(HaveLen(2))
 				})
 			})
 		})
@@ -778,6 +3343,22 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					})
 				})
 
+				When("updating a category that does not exist", func() {
+					It("should return status code 404 and not create it", func() {
+						requestBody, _ := json.Marshal(model.Category{Name: "Category 6"})
+						r, _ := http.NewRequest("PUT", "/api/v1/category/update/6", bytes.NewReader(requestBody))
+						r.Header.Set("Content-Type", "application/json")
+						w := httptest.NewRecorder()
+
+						r.AddCookie(SetCookie(apiServer))
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusNotFound))
+
+						_, err := categoryRepo.GetByID(6)
+						Expect(err).To(MatchError(model.ErrRecordNotFound))
+					})
+				})
+
 				When("sending an invalid request", func() {
 					It("should return status code 400", func() {
 						reqBody := []byte("invalid request body")
@@ -808,6 +3389,34 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					})
 				})
 
+				When("deleting a category that still has tasks", func() {
+					It("should return status code 409 unless a mode says what to do with them", func() {
+						cookie := SetCookie(apiServer)
+
+						r, _ := http.NewRequest("DELETE", "/api/v1/category/delete/1", nil)
+						w := httptest.NewRecorder()
+						r.AddCookie(cookie)
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusConflict))
+
+						r, _ = http.NewRequest("DELETE", "/api/v1/category/delete/1?mode=reassign&reassign_to=99", nil)
+						w = httptest.NewRecorder()
+						r.AddCookie(cookie)
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+						r, _ = http.NewRequest("DELETE", "/api/v1/category/delete/1?mode=reassign&reassign_to=4", nil)
+						w = httptest.NewRecorder()
+						r.AddCookie(cookie)
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusOK))
+
+						task, err := taskRepo.GetByID(3, 3)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(task.CategoryID).To(Equal(4))
+					})
+				})
+
 				When("deleting a category", func() {
 					It("should delete the category and return status code 200", func() {
 						r, _ := http.NewRequest("DELETE", "/api/v1/category/delete/4", nil)
@@ -853,6 +3462,69 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		})
 
 		Describe("Task API", func() {
+			Describe("AddTask", func() {
+				When("adding tasks without an ID", func() {
+					It("should return status code 201 with distinct server-assigned IDs", func() {
+						var ids []int
+						for i := 0; i < 2; i++ {
+							reqBody, _ := json.Marshal(map[string]interface{}{
+								"title":       "New Task",
+								"deadline":    "2023-06-10",
+								"priority":    1,
+								"status":      "In Progress",
+								"category_id": 1,
+							})
+							r, _ := http.NewRequest("POST", "/api/v1/task/add", bytes.NewReader(reqBody))
+							w := httptest.NewRecorder()
+
+							r.AddCookie(SetCookie(apiServer))
+							apiServer.ServeHTTP(w, r)
+							Expect(w.Code).To(Equal(http.StatusCreated))
+
+							var response model.Task
+							Expect(json.Unmarshal(w.Body.Bytes(), &response)).Should(Succeed())
+							ids = append(ids, response.ID)
+						}
+						Expect(ids).To(Equal([]int{6, 7}))
+					})
+				})
+
+				When("adding a task in a category that does not exist", func() {
+					It("should return status code 400", func() {
+						reqBody, _ := json.Marshal(model.Task{Title: "Orphan", CategoryID: 99})
+						r, _ := http.NewRequest("POST", "/api/v1/task/add", bytes.NewReader(reqBody))
+						w := httptest.NewRecorder()
+
+						r.AddCookie(SetCookie(apiServer))
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusBadRequest))
+					})
+				})
+
+				When("adding a task with an ID of its own", func() {
+					It("should ignore the ID and assign the next one", func() {
+						for _, id := range []int{1, -5, math.MaxInt64} {
+							reqBody, _ := json.Marshal(model.Task{ID: id, Title: "Duplicate", CategoryID: 1})
+							r, _ := http.NewRequest("POST", "/api/v1/task/add", bytes.NewReader(reqBody))
+							w := httptest.NewRecorder()
+
+							r.AddCookie(SetCookie(apiServer))
+							apiServer.ServeHTTP(w, r)
+							Expect(w.Code).To(Equal(http.StatusCreated))
+
+							var created model.Task
+							Expect(json.Unmarshal(w.Body.Bytes(), &created)).Should(Succeed())
+							Expect(created.ID).To(BeNumerically(">", 5))
+							Expect(created.ID).To(BeNumerically("<", 10))
+						}
+
+						task, err := taskRepo.GetByID(2, 1)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(task.Title).To(Equal("Task 1"))
+					})
+				})
+			})
+
 			Describe("UpdateTask", func() {
 				When("sending without cookie", func() {
 					It("should return status code 401", func() {
@@ -877,16 +3549,16 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 				When("updating existing task", func() {
 					It("should return status code 200", func() {
 						updatedTask := model.Task{
-							ID:         1,
-							Title:      "Updated with API Task 1",
-							Deadline:   "2023-05-30",
+							ID:         2,
+							Title:      "Updated with API Task 2",
+							Deadline:   "2023-06-01",
 							Priority:   5,
-							CategoryID: 1,
+							CategoryID: 2,
 							Status:     "In Progress",
 						}
 						reqBody, _ := json.Marshal(updatedTask)
 
-						r, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/task/update/%d", 1), bytes.NewReader(reqBody))
+						r, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/task/update/%d", 2), bytes.NewReader(reqBody))
 						w := httptest.NewRecorder()
 
 						r.AddCookie(SetCookie(apiServer))
@@ -899,6 +3571,23 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					})
 				})
 
+				When("updating a task owned by another user", func() {
+					It("should return status code 404", func() {
+						reqBody, _ := json.Marshal(model.Task{Title: "Hijacked", UserID: 1})
+
+						r, _ := http.NewRequest("PUT", fmt.Sprintf("/api/v1/task/update/%d", 1), bytes.NewReader(reqBody))
+						w := httptest.NewRecorder()
+
+						r.AddCookie(SetCookie(apiServer))
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusNotFound))
+
+						task, err := taskRepo.GetByID(2, 1)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(task.Title).To(Equal("Task 1"))
+					})
+				})
+
 				When("sending invalid request", func() {
 					It("should return status code 400", func() {
 						reqBody := []byte("invalid request body")
@@ -930,7 +3619,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 
 				When("deleting existing task", func() {
 					It("should return status code 200", func() {
-						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/task/delete/%d", 1), nil)
+						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/task/delete/%d", 2), nil)
 						w := httptest.NewRecorder()
 
 						r.AddCookie(SetCookie(apiServer))
@@ -943,6 +3632,17 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 					})
 				})
 
+				When("deleting a task owned by another user", func() {
+					It("should return status code 404", func() {
+						r, _ := http.NewRequest("DELETE", fmt.Sprintf("/api/v1/task/delete/%d", 1), nil)
+						w := httptest.NewRecorder()
+
+						r.AddCookie(SetCookie(apiServer))
+						apiServer.ServeHTTP(w, r)
+						Expect(w.Code).To(Equal(http.StatusNotFound))
+					})
+				})
+
 				When("deleting non-existing task", func() {
 					It("should return status code 400", func() {
 						taskID := "abc"
@@ -982,7 +3682,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 
 						var response []model.Task
 						Expect(json.Unmarshal(w.Body.Bytes(), &response)).Should(Succeed())
-						Expect(response).To(Equal(insertTasks))
+						Expect(response).To(Equal([]model.Task{insertTasks[1], insertTasks[4]}))
 					})
 				})
 			})
@@ -1000,7 +3700,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 
 				When("retrieving task list by category", func() {
 					It("should return status code 200 and task list", func() {
-						r, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/task/category/%d", 1), nil)
+						r, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/task/category/%d", 2), nil)
 						w := httptest.NewRecorder()
 
 						r.AddCookie(SetCookie(apiServer))
@@ -1010,9 +3710,7 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 						var response []model.TaskCategory
 						Expect(json.Unmarshal(w.Body.Bytes(), &response)).Should(Succeed())
 						Expect(response).To(Equal([]model.TaskCategory{
-							{ID: 1, Title: "Task 1", Category: "Category 1"},
-							{ID: 3, Title: "Task 3", Category: "Category 1"},
-							{ID: 4, Title: "Task 4", Category: "Category 1"},
+							{ID: 2, Title: "Task 2", Category: "Category 2"},
 						}))
 					})
 				})
@@ -1248,3 +3946,406 @@ var _ = Describe("Task Tracker Plus", Ordered, func() {
 		})
 	})
 })
+
+// Storage conformance runs the same specs against every backend, so that the
+// file, SQL and memory stores cannot drift apart in what the repositories
+// observe.
+var _ = Describe("Storage conformance", func() {
+	// Each backend opens an empty store and a function that closes it and
+	// opens it again, as a restarted server would. The memory store has
+	// nothing to reopen and keeps the same instance.
+	backends := []struct {
+		name string
+		open func() (testStore, func() testStore)
+	}{
+		{"file", func() (testStore, func() testStore) {
+			path := filepath.Join(GinkgoT().TempDir(), "file.db")
+			var data *filebased.Data
+			open := func() testStore {
+				if data != nil {
+					Expect(data.CloseDB()).Should(Succeed())
+				}
+				var err error
+				data, err = filebased.Open(path)
+				Expect(err).ShouldNot(HaveOccurred())
+				return data
+			}
+			DeferCleanup(func() error { return data.CloseDB() })
+			return open(), open
+		}},
+		{"sql", func() (testStore, func() testStore) {
+			path := filepath.Join(GinkgoT().TempDir(), "sql.db")
+			var data *sqldb.Data
+			open := func() testStore {
+				if data != nil {
+					Expect(data.CloseDB()).Should(Succeed())
+				}
+				var err error
+				data, err = openSQLite(path)
+				Expect(err).ShouldNot(HaveOccurred())
+				return data
+			}
+			DeferCleanup(func() error { return data.CloseDB() })
+			return open(), open
+		}},
+		{"memory", func() (testStore, func() testStore) {
+			data := memory.New()
+			return data, func() testStore { return data }
+		}},
+	}
+
+	for _, backend := range backends {
+		backend := backend
+
+		Describe(backend.name, func() {
+			var db testStore
+			var reopen func() testStore
+
+			BeforeEach(func() {
+				db, reopen = backend.open()
+			})
+
+			When("a record is stored under an ID out of range", func() {
+				It("should refuse it and never hand out an ID twice", func() {
+					_, err := db.StoreCategory(model.Category{Name: "Work"})
+					Expect(err).ShouldNot(HaveOccurred())
+					for _, id := range []int{-5, model.MaxID + 1, math.MaxInt64} {
+						_, err := db.StoreTask(model.Task{ID: id, Title: "Bad", CategoryID: 1, UserID: 1})
+						Expect(err).To(MatchError(model.ErrInvalidID))
+						_, err = db.StoreCategory(model.Category{ID: id, Name: "Bad"})
+						Expect(err).To(MatchError(model.ErrInvalidID))
+					}
+
+					first, err := db.StoreTask(model.Task{Title: "First", CategoryID: 1, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(first.ID).To(Equal(1))
+					last, err := db.StoreTask(model.Task{ID: model.MaxID, Title: "Last", CategoryID: 1, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					for i := 0; i < 2; i++ {
+						_, err = db.StoreTask(model.Task{Title: "Overflow", CategoryID: 1, UserID: 1})
+						Expect(err).To(MatchError(model.ErrIDsExhausted))
+					}
+
+					tasks, err := db.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(Equal([]model.Task{first, last}))
+				})
+			})
+
+			When("a user is deleted and another registers", func() {
+				It("should not hand out the deleted user's ID again", func() {
+					ann, err := db.CreateUser(model.User{Email: "ann@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					bob, err := db.CreateUser(model.User{Email: "bob@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(db.DeleteUser(bob.ID)).Should(Succeed())
+
+					db = reopen()
+
+					cat, err := db.CreateUser(model.User{Email: "cat@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(cat.ID).To(BeNumerically(">", bob.ID))
+					Expect(bob.ID).To(BeNumerically(">", ann.ID))
+				})
+			})
+
+			When("updating a category that does not exist", func() {
+				It("should report it and leave the ID to the next new category", func() {
+					Expect(db.UpdateCategory(1, model.Category{Name: "Ghost"})).To(MatchError(model.ErrRecordNotFound))
+					_, err := db.GetCategoryByID(1)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					category, err := db.StoreCategory(model.Category{Name: "Work"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(1))
+					Expect(db.UpdateCategory(1, model.Category{Name: "Office"})).Should(Succeed())
+
+					categories, err := db.GetCategories()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(categories).To(Equal([]model.Category{{ID: 1, Name: "Office"}}))
+				})
+			})
+
+			When("reopening a store that already holds records", func() {
+				It("should hand out IDs after the stored ones without overwriting them", func() {
+					_, err := db.StoreCategory(model.Category{ID: 5, Name: "Work"})
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = db.StoreTask(model.Task{ID: 7, Title: "Report", CategoryID: 5, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					ann, err := db.CreateUser(model.User{Email: "ann@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+
+					db = reopen()
+
+					category, err := db.StoreCategory(model.Category{Name: "Home"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(6))
+					task, err := db.StoreTask(model.Task{Title: "Garden", CategoryID: 6, UserID: 1})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(task.ID).To(Equal(8))
+					bob, err := db.CreateUser(model.User{Email: "bob@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(bob.ID).To(Equal(ann.ID + 1))
+
+					categories, err := db.GetCategories()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(categories).To(Equal([]model.Category{{ID: 5, Name: "Work"}, {ID: 6, Name: "Home"}}))
+					tasks, err := db.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(Equal([]model.Task{
+						{ID: 7, Title: "Report", CategoryID: 5, UserID: 1},
+						{ID: 8, Title: "Garden", CategoryID: 6, UserID: 1},
+					}))
+					user, err := db.GetUserByID(ann.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.Email).To(Equal("ann@mail.com"))
+				})
+			})
+
+			When("storing tasks and categories", func() {
+				It("should allocate IDs and report missing records", func() {
+					category, err := db.StoreCategory(model.Category{Name: "Work"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(1))
+					category, err = db.StoreCategory(model.Category{ID: 10, Name: "Home"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(10))
+					_, err = db.StoreCategory(model.Category{ID: 10, Name: "Again"})
+					Expect(err).To(MatchError(model.ErrRecordExists))
+					category, err = db.StoreCategory(model.Category{Name: "Errands"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.ID).To(Equal(11))
+
+					_, err = db.StoreTask(model.Task{Title: "Nowhere", CategoryID: 99, UserID: 1})
+					Expect(err).To(MatchError(model.ErrCategoryNotFound))
+					first, err := db.StoreTask(model.Task{Title: "Report", CategoryID: 1, UserID: 1, Priority: 2})
+					Expect(err).ShouldNot(HaveOccurred())
+					second, err := db.StoreTask(model.Task{Title: "Garden", CategoryID: 10, UserID: 2})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect([]int{first.ID, second.ID}).To(Equal([]int{1, 2}))
+
+					_, err = db.GetTaskByID(42)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+					first.Title = "Taken over"
+					first.UserID = 2
+					Expect(db.UpdateTask(first.ID, first)).To(MatchError(model.ErrRecordNotFound))
+					Expect(db.DeleteTask(2, first.ID)).To(MatchError(model.ErrRecordNotFound))
+
+					Expect(db.DeleteCategory(1, model.CategoryDeleteRestrict, 0)).To(MatchError(model.ErrCategoryInUse))
+					Expect(db.DeleteCategory(1, model.CategoryDeleteReassign, 1)).To(MatchError(model.ErrCategoryNotFound))
+					Expect(db.DeleteCategory(1, model.CategoryDeleteReassign, 10)).Should(Succeed())
+					Expect(db.DeleteCategory(1, model.CategoryDeleteCascade, 0)).To(MatchError(model.ErrRecordNotFound))
+
+					tasks, err := db.GetTasksByUser(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(Equal([]model.Task{{ID: 1, Title: "Report", CategoryID: 10, UserID: 1, Priority: 2}}))
+					list, err := db.GetTaskListByCategory(2, 10)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(list).To(Equal([]model.TaskCategory{{ID: 2, Title: "Garden", Category: "Home"}}))
+
+					Expect(db.DeleteCategory(10, model.CategoryDeleteCascade, 0)).Should(Succeed())
+					tasks, err = db.GetTasks()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(BeEmpty())
+					categories, err := db.GetCategories()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(categories).To(Equal([]model.Category{{ID: 11, Name: "Errands"}}))
+				})
+			})
+
+			When("storing users and sessions", func() {
+				It("should round-trip every field and match emails ignoring case", func() {
+					created := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+					user, err := db.CreateUser(model.User{
+						Fullname:           "Ann",
+						Email:              "Ann@Mail.com",
+						Password:           "hash",
+						Role:               model.RoleAdmin,
+						CreatedAt:          created,
+						TOTPEnabled:        true,
+						TOTPLastStep:       42,
+						RecoveryCodeHashes: []string{"a", "b"},
+					})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(user.ID).To(Equal(1))
+					_, err = db.CreateUser(model.User{Email: "ann@mail.COM"})
+					Expect(err).To(MatchError(model.ErrRecordExists))
+
+					stored, err := db.GetUserByEmail("ANN@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(stored).To(Equal(user))
+					unknown, err := db.GetUserByEmail("nobody@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(unknown).To(Equal(model.User{}))
+					_, err = db.GetUserByID(2)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					Expect(db.AddSession(model.Session{Token: "t2", Email: "ann@mail.com", Expiry: created, RefreshTokenHash: "r1"})).Should(Succeed())
+					Expect(db.AddSession(model.Session{Token: "t1", Email: "ANN@mail.com", Expiry: created})).Should(Succeed())
+					sessions, err := db.SessionsByEmail("Ann@Mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(sessions).To(HaveLen(2))
+					Expect([]int{sessions[0].ID, sessions[1].ID}).To(Equal([]int{1, 2}))
+					Expect(sessions[0].Expiry).To(Equal(created))
+					session, err := db.SessionAvailEmail("ann@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(session.Token).To(Equal("t1"))
+
+					rotated := sessions[0]
+					rotated.Token = "t3"
+					rotated.RefreshTokenHash = "r2"
+					rotated.UsedRefreshHashes = []string{"r1"}
+					Expect(db.RotateSession("t2", "wrong", rotated)).To(MatchError(model.ErrRecordNotFound))
+					Expect(db.RotateSession("t2", "r1", rotated)).Should(Succeed())
+					session, reused, err := db.SessionByRefreshHash("r1")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(reused).To(BeTrue())
+					Expect(session).To(Equal(rotated))
+					_, _, err = db.SessionByRefreshHash("r")
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+
+					Expect(db.DeleteUser(user.ID)).Should(Succeed())
+					sessions, err = db.SessionsByEmail("ann@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(sessions).To(BeEmpty())
+					Expect(db.DeleteUser(user.ID)).To(MatchError(model.ErrRecordNotFound))
+				})
+			})
+
+			When("deleting a user", func() {
+				It("should take the categories it created and its login attempts along", func() {
+					ann, err := db.CreateUser(model.User{Email: "Ann@Mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					bob, err := db.CreateUser(model.User{Email: "bob@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+
+					shared, err := db.StoreCategory(model.Category{Name: "Shared"})
+					Expect(err).ShouldNot(HaveOccurred())
+					private, err := db.StoreCategory(model.Category{Name: "Private", UserID: ann.ID})
+					Expect(err).ShouldNot(HaveOccurred())
+					lent, err := db.StoreCategory(model.Category{Name: "Lent", UserID: ann.ID})
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = db.StoreTask(model.Task{Title: "ann", CategoryID: private.ID, UserID: ann.ID})
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = db.StoreTask(model.Task{Title: "bob", CategoryID: lent.ID, UserID: bob.ID})
+					Expect(err).ShouldNot(HaveOccurred())
+
+					Expect(db.UpdateCategory(lent.ID, model.Category{Name: "Borrowed"})).Should(Succeed())
+					category, err := db.GetCategoryByID(lent.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(category.UserID).To(Equal(ann.ID))
+
+					now := time.Now()
+					for _, key := range []string{"email:ann@mail.com", fmt.Sprintf("user:%d", ann.ID), "email:bob@mail.com"} {
+						_, err := db.RecordLoginFailure(key, now, time.Hour)
+						Expect(err).ShouldNot(HaveOccurred())
+					}
+
+					Expect(db.DeleteUser(ann.ID)).Should(Succeed())
+
+					categories, err := db.GetCategories()
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(categories).To(Equal([]model.Category{
+						{ID: shared.ID, Name: "Shared"},
+						{ID: lent.ID, Name: "Borrowed"},
+					}))
+
+					for _, key := range []string{"email:ann@mail.com", fmt.Sprintf("user:%d", ann.ID)} {
+						attempt, err := db.LoginAttempt(key)
+						Expect(err).ShouldNot(HaveOccurred())
+						Expect(attempt.Failures).To(BeZero())
+					}
+					attempt, err := db.LoginAttempt("email:bob@mail.com")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(attempt.Failures).To(Equal(1))
+				})
+			})
+
+			When("storing access tokens, login attempts and password resets", func() {
+				It("should key them by hash and hand out IDs in order", func() {
+					first, err := db.StoreAccessToken(model.PersonalAccessToken{UserID: 1, Name: "ci", TokenHash: "h1"})
+					Expect(err).ShouldNot(HaveOccurred())
+					second, err := db.StoreAccessToken(model.PersonalAccessToken{UserID: 1, Name: "cli", TokenHash: "h2"})
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect([]int{first.ID, second.ID}).To(Equal([]int{1, 2}))
+					_, err = db.StoreAccessToken(model.PersonalAccessToken{UserID: 2, TokenHash: "h1"})
+					Expect(err).To(MatchError(model.ErrRecordExists))
+
+					Expect(db.UpdateAccessToken(model.PersonalAccessToken{TokenHash: "h9"})).To(MatchError(model.ErrRecordNotFound))
+					_, err = db.AccessTokenByHash("h9")
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+					Expect(db.DeleteAccessToken(2, first.ID)).To(MatchError(model.ErrRecordNotFound))
+					Expect(db.DeleteAccessToken(1, first.ID)).Should(Succeed())
+					pats, err := db.AccessTokensByUser(1)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(pats).To(Equal([]model.PersonalAccessToken{second}))
+
+					now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+					attempt, err := db.LoginAttempt("ip:1")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(attempt).To(Equal(model.LoginAttempt{Key: "ip:1"}))
+					_, err = db.RecordLoginFailure("ip:1", now, time.Hour)
+					Expect(err).ShouldNot(HaveOccurred())
+					attempt, err = db.RecordLoginFailure("ip:1", now.Add(time.Minute), time.Hour)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(attempt.Failures).To(Equal(2))
+					attempt, err = db.RecordLoginFailure("ip:1", now.Add(2*time.Hour), time.Hour)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(attempt.Failures).To(Equal(1))
+					Expect(db.DeleteLoginAttempt("ip:1")).Should(Succeed())
+					attempt, err = db.LoginAttempt("ip:1")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(attempt.Failures).To(BeZero())
+
+					Expect(db.StorePasswordReset(model.PasswordReset{TokenHash: "old", UserID: 1, ExpiresAt: now})).Should(Succeed())
+					Expect(db.StorePasswordReset(model.PasswordReset{TokenHash: "new", UserID: 1, ExpiresAt: now})).Should(Succeed())
+					_, err = db.ConsumePasswordReset("old")
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+					reset, err := db.ConsumePasswordReset("new")
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(reset.UserID).To(Equal(1))
+					_, err = db.ConsumePasswordReset("new")
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+				})
+			})
+
+			When("exporting and importing a user's data", func() {
+				It("should match categories by name and give tasks new IDs", func() {
+					user, err := db.CreateUser(model.User{Fullname: "Ann", Email: "ann@mail.com"})
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = db.StoreCategory(model.Category{Name: "Work"})
+					Expect(err).ShouldNot(HaveOccurred())
+					_, err = db.StoreTask(model.Task{Title: "Report", CategoryID: 1, UserID: user.ID})
+					Expect(err).ShouldNot(HaveOccurred())
+
+					_, _, _, err = db.ExportUserData(2)
+					Expect(err).To(MatchError(model.ErrRecordNotFound))
+					exported, categories, tasks, err := db.ExportUserData(user.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(exported).To(Equal(user))
+					Expect(categories).To(Equal([]model.Category{{ID: 1, Name: "Work"}}))
+					Expect(tasks).To(Equal([]model.Task{{ID: 1, Title: "Report", CategoryID: 1, UserID: user.ID}}))
+
+					archive := model.Archive{
+						Profile:    model.Profile{Fullname: "Ann B"},
+						Categories: []model.Category{{ID: 7, Name: "Work"}, {ID: 8, Name: "Home"}},
+						Tasks:      []model.Task{{ID: 3, Title: "Garden", CategoryID: 8}, {ID: 4, Title: "Slides", CategoryID: 7}},
+					}
+					result, err := db.ImportUserData(user.ID, archive, true)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(result).To(Equal(model.ImportResult{TasksRemoved: 1, CategoriesMatched: 1, CategoriesCreated: 1, TasksImported: 2}))
+
+					tasks, err = db.GetTasksByUser(user.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(tasks).To(Equal([]model.Task{
+						{ID: 2, Title: "Garden", CategoryID: 2, UserID: user.ID},
+						{ID: 3, Title: "Slides", CategoryID: 1, UserID: user.ID},
+					}))
+					renamed, err := db.GetUserByID(user.ID)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(renamed.Fullname).To(Equal("Ann B"))
+				})
+			})
+		})
+	}
+})
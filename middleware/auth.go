@@ -2,54 +2,183 @@ package middleware
 
 import (
 	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"errors"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 )
 
-func Auth() gin.HandlerFunc {
+var (
+	errMissingCredentials = errors.New("missing credentials")
+	errInvalidToken       = errors.New("invalid token")
+	errSessionRevoked     = errors.New("session expired or revoked")
+	errReadOnlyToken      = errors.New("access token is read-only")
+)
+
+// Auth protects API routes. Callers authenticate with either an
+// "Authorization: Bearer <token>" header or the session_token cookie, and
+// every failure is answered with JSON. The bearer token may be a session JWT
+// or a personal access token.
+func Auth(tokenService service.TokenService, sessionService service.SessionService, accessTokenService service.AccessTokenService) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		cookie, err := c.Cookie("session_token")
-		if err != nil {
-			if c.GetHeader("Content-Type") == "application/json" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			} else {
-				c.Redirect(http.StatusSeeOther, "/client/login")
-			}
+		var err error
+		if token := requestToken(c); strings.HasPrefix(token, service.AccessTokenPrefix) {
+			err = authenticateAccessToken(c, token, accessTokenService)
+		} else {
+			err = authenticate(c, token, tokenService, sessionService)
+		}
 
-			c.Abort()
+		if errors.Is(err, errReadOnlyToken) {
+			c.AbortWithStatusJSON(http.StatusForbidden, model.NewErrorResponse(err.Error()))
+			return
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, model.NewErrorResponse(err.Error()))
 			return
 		}
 
-		tokenClaims := &model.Claims{}
-		token, err := jwt.ParseWithClaims(cookie, tokenClaims, func(t *jwt.Token) (interface{}, error) {
-			return model.JwtKey, nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Unauthorized"})
+		c.Next()
+	})
+}
+
+// WebAuth protects the browser pages under /client. It accepts the same
+// credentials as Auth but sends the user to the login page on failure. Once
+// the short-lived access token has expired, the refresh cookie set at login
+// is exchanged for a new pair, so the browser stays logged in for as long as
+// its session lasts.
+func WebAuth(tokenService service.TokenService, sessionService service.SessionService, userService service.UserService) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		err := authenticate(c, requestToken(c), tokenService, sessionService)
+		if err != nil {
+			err = refreshWebSession(c, tokenService, sessionService, userService)
+		}
+		if err != nil {
+			c.Redirect(http.StatusSeeOther, "/client/login")
 			c.Abort()
 			return
 		}
 
-		c.Set("email", tokenClaims.Email)
 		c.Next()
 	})
 }
 
-func CreateToken(email string) (string, error) {
-	claims := model.Claims{
-		Email: email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
-		},
+// refreshWebSession rotates the browser's token pair using its refresh
+// cookie and authenticates the request with the new access token.
+func refreshWebSession(c *gin.Context, tokenService service.TokenService, sessionService service.SessionService, userService service.UserService) error {
+	refreshToken, err := c.Cookie(webRefreshCookie)
+	if err != nil || refreshToken == "" {
+		return errMissingCredentials
+	}
+
+	tokens, err := userService.Refresh(refreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		ClearWebSession(c)
+		return err
+	}
+
+	SetWebSession(c, tokens, http.SameSiteLaxMode)
+	return authenticate(c, tokens.AccessToken, tokenService, sessionService)
+}
+
+// webRefreshCookie holds the browser's refresh token. It is only sent to the
+// pages under /client, which are the only ones that exchange it.
+const webRefreshCookie = "refresh_token"
+
+// SetWebSession stores a browser login: the access token for every path,
+// expiring with the token, and the refresh token for the pages under /client,
+// expiring with the session.
+func SetWebSession(c *gin.Context, tokens model.TokenPair, sameSite http.SameSite) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     "session_token",
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		Expires:  tokens.ExpiresAt,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     webRefreshCookie,
+		Value:    tokens.RefreshToken,
+		Path:     "/client",
+		Expires:  tokens.RefreshExpiresAt,
+		HttpOnly: true,
+		SameSite: sameSite,
+	})
+}
+
+// ClearWebSession expires both cookies written by SetWebSession.
+func ClearWebSession(c *gin.Context) {
+	c.SetCookie("session_token", "", -1, "/", "", false, true)
+	c.SetCookie(webRefreshCookie, "", -1, "/client", "", false, true)
+}
+
+// authenticate verifies the request's token and its server-side session and
+// stores the caller's identity in the gin context.
+func authenticate(c *gin.Context, token string, tokenService service.TokenService, sessionService service.SessionService) error {
+	if token == "" {
+		return errMissingCredentials
+	}
+
+	tokenClaims, err := tokenService.Parse(token)
+	if err != nil {
+		return errInvalidToken
+	}
+
+	// A valid signature is not enough: the session must still exist
+	// server-side so that logout and revocation take effect immediately.
+	session, err := sessionService.ValidateToken(token)
+	if err != nil || session.Email != tokenClaims.Email {
+		return errSessionRevoked
+	}
+
+	c.Set("email", tokenClaims.Email)
+	c.Set("user_id", tokenClaims.UserID)
+	c.Set("role", tokenClaims.Role)
+	c.Set("session_token", token)
+	c.Set("auth_method", "session")
+	return nil
+}
+
+// authenticateAccessToken verifies a personal access token and enforces its
+// scope: read-only tokens may only be used for safe methods.
+func authenticateAccessToken(c *gin.Context, token string, accessTokenService service.AccessTokenService) error {
+	pat, err := accessTokenService.Authenticate(token)
+	if err != nil {
+		return err
+	}
+
+	if pat.Scope == model.ScopeRead {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return errReadOnlyToken
+		}
+	}
+
+	c.Set("email", pat.Email)
+	c.Set("user_id", pat.UserID)
+	c.Set("auth_method", "access_token")
+	c.Set("token_scope", pat.Scope)
+	return nil
+}
+
+// requestToken returns the bearer token from the Authorization header, falling
+// back to the session_token cookie.
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
-	tokenString, err := token.SignedString(model.JwtKey)
+	cookie, err := c.Cookie("session_token")
 	if err != nil {
-		return "", err
+		return ""
 	}
-	return tokenString, nil
+	return cookie
 }
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets the request through when the caller has one of roles.
+// It must run after Auth. The role is read from the user record rather than
+// the token so that a demotion applies to access tokens as well.
+func RequireRole(userService service.UserService, roles ...string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		user, err := userService.GetByID(c.GetInt("user_id"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, model.NewErrorResponse("user not found"))
+			return
+		}
+
+		role := user.EffectiveRole()
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Set("role", role)
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, model.NewErrorResponse("insufficient role"))
+	})
+}
@@ -0,0 +1,26 @@
+package model
+
+import "errors"
+
+// MaxID is the largest ID a task, category or user may have: the largest
+// integer a JSON number carries exactly to a JavaScript client. Keeping
+// sequences below it also keeps them far from overflowing.
+const MaxID = 1<<53 - 1
+
+var (
+	ErrRecordNotFound = errors.New("record not found")
+	ErrRecordExists   = errors.New("record already exists")
+
+	// ErrInvalidID is returned when a record is to be stored under an ID
+	// below 1 or above MaxID.
+	ErrInvalidID = errors.New("id must be between 1 and 9007199254740991")
+	// ErrIDsExhausted is returned when a sequence has handed out MaxID.
+	ErrIDsExhausted = errors.New("no IDs left to allocate")
+
+	// ErrCategoryNotFound is returned when a task, or a category being
+	// deleted, refers to a category that does not exist.
+	ErrCategoryNotFound = errors.New("category does not exist")
+	// ErrCategoryInUse is returned when a category that still has tasks is
+	// deleted with CategoryDeleteRestrict.
+	ErrCategoryInUse = errors.New("category still has tasks")
+)
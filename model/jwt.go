@@ -2,9 +2,9 @@ package model
 
 import "github.com/golang-jwt/jwt"
 
-var JwtKey = []byte("secret-key")
-
 type Claims struct {
-	Email string `json:"email"`
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`
 	jwt.StandardClaims
 }
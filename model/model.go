@@ -2,9 +2,33 @@ package model
 
 import "time"
 
+// Category is shared by all users. UserID is the account that created it,
+// and 0 for categories from before that was recorded or whose owner has been
+// deleted.
 type Category struct {
-	ID   int    `gorm:"primaryKey" json:"id"`
-	Name string `json:"name"`
+	ID     int    `gorm:"primaryKey" json:"id"`
+	Name   string `json:"name"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+// CategoryDeleteMode says what happens to the tasks of a deleted category.
+type CategoryDeleteMode string
+
+const (
+	// CategoryDeleteRestrict refuses to delete a category that has tasks.
+	CategoryDeleteRestrict CategoryDeleteMode = "restrict"
+	// CategoryDeleteCascade deletes the category's tasks with it.
+	CategoryDeleteCascade CategoryDeleteMode = "cascade"
+	// CategoryDeleteReassign moves the category's tasks to another category.
+	CategoryDeleteReassign CategoryDeleteMode = "reassign"
+)
+
+func (m CategoryDeleteMode) Valid() bool {
+	switch m {
+	case CategoryDeleteRestrict, CategoryDeleteCascade, CategoryDeleteReassign:
+		return true
+	}
+	return false
 }
 
 type User struct {
@@ -12,8 +36,99 @@ type User struct {
 	Fullname  string    `json:"fullname" gorm:"type:varchar(255);"`
 	Email     string    `json:"email" gorm:"type:varchar(255);not null"`
 	Password  string    `json:"password" gorm:"type:varchar(255);not null"`
+	Role      string    `json:"role" gorm:"type:varchar(32);default:member"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// PendingVerification is set on accounts that registered but have not
+	// yet followed the emailed link. Such accounts cannot log in.
+	PendingVerification bool `json:"pending_verification,omitempty"`
+
+	// TOTPSecret is written when the user starts enrolling in two-factor
+	// login, which only takes effect once TOTPEnabled is set by confirming a
+	// first code. TOTPLastStep is the time step of the last accepted code, so
+	// that no code is accepted twice. RecoveryCodeHashes holds the SHA-256 of
+	// each unused recovery code.
+	TOTPSecret         string   `json:"totp_secret,omitempty"`
+	TOTPEnabled        bool     `json:"totp_enabled,omitempty"`
+	TOTPLastStep       int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodeHashes []string `json:"recovery_code_hashes,omitempty"`
+
+	// OIDCSubject is the identity provider's subject for accounts that have
+	// logged in with single sign-on. Accounts created that way have no
+	// Password.
+	OIDCSubject string `json:"oidc_subject,omitempty"`
+}
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// EffectiveRole returns the user's role. Accounts created before roles
+// existed have none stored and are members.
+func (u User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleMember
+	}
+	return u.Role
+}
+
+// Profile is the client-facing view of a user; it never carries the password
+// or two-factor secrets.
+type Profile struct {
+	ID                  int       `json:"id"`
+	Fullname            string    `json:"fullname"`
+	Email               string    `json:"email"`
+	Role                string    `json:"role"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	PendingVerification bool      `json:"pending_verification"`
+	TwoFactorEnabled    bool      `json:"two_factor_enabled"`
+	SingleSignOn        bool      `json:"single_sign_on"`
+}
+
+func (u User) Profile() Profile {
+	return Profile{
+		ID:                  u.ID,
+		Fullname:            u.Fullname,
+		Email:               u.Email,
+		Role:                u.EffectiveRole(),
+		CreatedAt:           u.CreatedAt,
+		UpdatedAt:           u.UpdatedAt,
+		PendingVerification: u.PendingVerification,
+		TwoFactorEnabled:    u.TOTPEnabled,
+		SingleSignOn:        u.OIDCSubject != "",
+	}
+}
+
+// ProfileUpdateRequest changes the fields that are set. Changing the email
+// requires the current password.
+type ProfileUpdateRequest struct {
+	Fullname        string `json:"fullname"`
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
 }
 
 type UserLogin struct {
@@ -21,6 +136,55 @@ type UserLogin struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// TokenPair is a short-lived access token together with the single-use
+// refresh token that can be exchanged for the next pair.
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type LoginResponse struct {
+	Message string `json:"message"`
+	TokenPair
+}
+
+// TwoFactorChallenge answers a correct password on an account with two-factor
+// login. The challenge is sent back with a code to finish logging in.
+type TwoFactorChallenge struct {
+	Message   string `json:"message"`
+	Challenge string `json:"challenge"`
+}
+
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorEnrollment is what an authenticator app needs to be set up.
+// QRPayload is the text to encode in a QR code for the app to scan.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRPayload  string `json:"qr_payload"`
+}
+
+// RecoveryCodes are shown once, when two-factor login is turned on. Each can
+// be used in place of a code a single time.
+type RecoveryCodes struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type UserRegister struct {
 	Fullname string `json:"fullname" binding:"required"`
 	Email    string `json:"email" binding:"required"`
@@ -37,11 +201,160 @@ type Task struct {
 	UserID     int    `json:"user_id"`
 }
 
+// ArchiveVersion is the version of the export archive format this server
+// writes. Archives of a later version are refused on import.
+const ArchiveVersion = 1
+
+// Archive is everything a user can take to another instance: their profile,
+// their tasks and the categories those tasks are in. IDs are those of the
+// exporting instance and are remapped on import.
+type Archive struct {
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exported_at"`
+	Profile    Profile    `json:"profile"`
+	Categories []Category `json:"categories"`
+	Tasks      []Task     `json:"tasks"`
+}
+
+// ImportMode says what happens to a user's existing data on import.
+type ImportMode string
+
+const (
+	// ImportMerge adds the archived tasks next to the existing ones.
+	ImportMerge ImportMode = "merge"
+	// ImportReplace deletes the user's tasks first and takes the full name
+	// from the archive.
+	ImportReplace ImportMode = "replace"
+)
+
+func (m ImportMode) Valid() bool {
+	return m == ImportMerge || m == ImportReplace
+}
+
+// ImportResult counts what an import did. Archived categories are matched
+// to existing ones by name and only created when there is no such category.
+type ImportResult struct {
+	CategoriesMatched int `json:"categories_matched"`
+	CategoriesCreated int `json:"categories_created"`
+	TasksRemoved      int `json:"tasks_removed"`
+	TasksImported     int `json:"tasks_imported"`
+}
+
+// Kinds of problem an integrity check reports.
+const (
+	// ProblemUndecodable is a record whose value is not valid JSON for its
+	// bucket.
+	ProblemUndecodable = "undecodable"
+	// ProblemOrphanedTask is a task whose user or category does not exist.
+	ProblemOrphanedTask = "orphaned_task"
+	// ProblemDangling is a session, access token or password reset whose
+	// user does not exist.
+	ProblemDangling = "dangling"
+	// ProblemIndexMismatch is an index entry that is missing, wrong or
+	// stale.
+	ProblemIndexMismatch = "index_mismatch"
+)
+
+// IntegrityProblem is one problem found by an integrity check. Quarantined
+// is set when the record was moved to the Quarantine bucket.
+type IntegrityProblem struct {
+	Bucket      string `json:"bucket"`
+	Key         string `json:"key"`
+	Kind        string `json:"kind"`
+	Detail      string `json:"detail"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+type IntegrityReport struct {
+	CheckedAt      time.Time          `json:"checked_at"`
+	Records        int                `json:"records"`
+	Problems       []IntegrityProblem `json:"problems"`
+	IndexesRebuilt bool               `json:"indexes_rebuilt,omitempty"`
+}
+
+// PasswordReset is an outstanding password reset. Only the SHA-256 of the
+// token sent by mail is stored.
+type PasswordReset struct {
+	TokenHash string    `json:"token_hash"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// LoginAttempt counts the recent failed logins for one account or client IP.
+// Key is "email:<address>" or "ip:<address>".
+type LoginAttempt struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
 type Session struct {
-	ID     int       `gorm:"primaryKey" json:"id"`
-	Token  string    `json:"token"`
-	Email  string    `json:"email"`
-	Expiry time.Time `json:"expiry"`
+	ID         int       `gorm:"primaryKey" json:"id"`
+	Token      string    `json:"token"`
+	Email      string    `json:"email"`
+	Expiry     time.Time `json:"expiry"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+
+	// RefreshTokenHash is the SHA-256 of the refresh token that may currently
+	// be exchanged. UsedRefreshHashes keeps the hashes of every token already
+	// exchanged in this family so that a replay can be detected.
+	RefreshTokenHash  string   `json:"refresh_token_hash"`
+	UsedRefreshHashes []string `json:"used_refresh_hashes,omitempty"`
+}
+
+// SessionInfo is the client-facing view of a session; it never carries the
+// token itself.
+type SessionInfo struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Expiry     time.Time `json:"expiry"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+}
+
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+// PersonalAccessToken is a long-lived API credential for scripts and CI. The
+// token itself is never stored; the record is keyed by its SHA-256 hash.
+type PersonalAccessToken struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Email      string    `json:"email"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	TokenHash  string    `json:"-"`
+}
+
+type AccessTokenRequest struct {
+	Name      string    `json:"name" binding:"required"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AccessTokenCreated struct {
+	Token string `json:"token"`
+	PersonalAccessToken
 }
 
 type TaskCategory struct {
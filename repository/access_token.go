@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+)
+
+type AccessTokenRepository interface {
+	Store(pat model.PersonalAccessToken) (model.PersonalAccessToken, error)
+	Update(pat model.PersonalAccessToken) error
+	GetByHash(hash string) (model.PersonalAccessToken, error)
+	GetList(userID int) ([]model.PersonalAccessToken, error)
+	Delete(userID, id int) error
+}
+
+type accessTokenRepository struct {
+	db Storage
+}
+
+func NewAccessTokenRepo(db Storage) *accessTokenRepository {
+	return &accessTokenRepository{db}
+}
+
+func (a *accessTokenRepository) Store(pat model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	return a.db.StoreAccessToken(pat)
+}
+
+func (a *accessTokenRepository) Update(pat model.PersonalAccessToken) error {
+	return a.db.UpdateAccessToken(pat)
+}
+
+func (a *accessTokenRepository) GetByHash(hash string) (model.PersonalAccessToken, error) {
+	return a.db.AccessTokenByHash(hash)
+}
+
+func (a *accessTokenRepository) GetList(userID int) ([]model.PersonalAccessToken, error) {
+	return a.db.AccessTokensByUser(userID)
+}
+
+func (a *accessTokenRepository) Delete(userID, id int) error {
+	return a.db.DeleteAccessToken(userID, id)
+}
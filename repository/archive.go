@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+)
+
+type ArchiveRepository interface {
+	Export(userID int) (model.User, []model.Category, []model.Task, error)
+	Import(userID int, archive model.Archive, replace bool) (model.ImportResult, error)
+}
+
+type archiveRepository struct {
+	db Storage
+}
+
+func NewArchiveRepo(db Storage) *archiveRepository {
+	return &archiveRepository{db}
+}
+
+func (a *archiveRepository) Export(userID int) (model.User, []model.Category, []model.Task, error) {
+	return a.db.ExportUserData(userID)
+}
+
+func (a *archiveRepository) Import(userID int, archive model.Archive, replace bool) (model.ImportResult, error) {
+	return a.db.ImportUserData(userID, archive, replace)
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"io"
+)
+
+type BackupRepository interface {
+	Write(w io.Writer, compress bool) error
+	WriteToDir(dir string, keep int, compress bool) (string, error)
+}
+
+type backupRepository struct {
+	db Snapshotter
+}
+
+func NewBackupRepo(db Snapshotter) *backupRepository {
+	return &backupRepository{db}
+}
+
+func (b *backupRepository) Write(w io.Writer, compress bool) error {
+	return b.db.Backup(w, compress)
+}
+
+func (b *backupRepository) WriteToDir(dir string, keep int, compress bool) (string, error) {
+	return b.db.BackupToDir(dir, keep, compress)
+}
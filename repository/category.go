@@ -1,44 +1,44 @@
 package repository
 
 import (
-	"a21hc3NpZ25tZW50/db/filebased"
 	"a21hc3NpZ25tZW50/model"
 )
 
 type CategoryRepository interface {
-	Store(Category *model.Category) error
+	Store(Category *model.Category) (model.Category, error)
 	Update(id int, category model.Category) error
-	Delete(id int) error
+	Delete(id int, mode model.CategoryDeleteMode, reassignTo int) error
 	GetByID(id int) (*model.Category, error)
 	GetList() ([]model.Category, error)
 }
 
 type categoryRepository struct {
-	filebasedDb *filebased.Data
+	db Storage
 }
 
-func NewCategoryRepo(filebasedDb *filebased.Data) *categoryRepository {
-	return &categoryRepository{filebasedDb}
+func NewCategoryRepo(db Storage) *categoryRepository {
+	return &categoryRepository{db}
 }
 
-func (c *categoryRepository) Store(Category *model.Category) error {
-	if err := c.filebasedDb.StoreCategory(*Category); err != nil {
-		return err
+func (c *categoryRepository) Store(Category *model.Category) (model.Category, error) {
+	createdCategory, err := c.db.StoreCategory(*Category)
+	if err != nil {
+		return model.Category{}, err
 	}
 
-	return nil
+	return createdCategory, nil
 }
 
 func (c *categoryRepository) Update(id int, category model.Category) error {
-	if err := c.filebasedDb.UpdateCategory(id, category); err != nil {
+	if err := c.db.UpdateCategory(id, category); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c *categoryRepository) Delete(id int) error {
-	if err := c.filebasedDb.DeleteCategory(id); err != nil {
+func (c *categoryRepository) Delete(id int, mode model.CategoryDeleteMode, reassignTo int) error {
+	if err := c.db.DeleteCategory(id, mode, reassignTo); err != nil {
 		return err
 	}
 
@@ -46,13 +46,13 @@ func (c *categoryRepository) Delete(id int) error {
 }
 
 func (c *categoryRepository) GetByID(id int) (*model.Category, error) {
-	category, err := c.filebasedDb.GetCategoryByID(id)
+	category, err := c.db.GetCategoryByID(id)
 
 	return category, err
 }
 
 func (c *categoryRepository) GetList() ([]model.Category, error) {
-	categories, err := c.filebasedDb.GetCategories()
+	categories, err := c.db.GetCategories()
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+)
+
+type IntegrityRepository interface {
+	Check(quarantine bool) (model.IntegrityReport, error)
+}
+
+type integrityRepository struct {
+	db IntegrityChecker
+}
+
+func NewIntegrityRepo(db IntegrityChecker) *integrityRepository {
+	return &integrityRepository{db}
+}
+
+func (i *integrityRepository) Check(quarantine bool) (model.IntegrityReport, error) {
+	return i.db.CheckIntegrity(quarantine)
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"time"
+)
+
+type LoginAttemptRepository interface {
+	Get(key string) (model.LoginAttempt, error)
+	RecordFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error)
+	Delete(key string) error
+}
+
+type loginAttemptRepository struct {
+	db Storage
+}
+
+func NewLoginAttemptRepo(db Storage) *loginAttemptRepository {
+	return &loginAttemptRepository{db}
+}
+
+func (l *loginAttemptRepository) Get(key string) (model.LoginAttempt, error) {
+	return l.db.LoginAttempt(key)
+}
+
+func (l *loginAttemptRepository) RecordFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error) {
+	return l.db.RecordLoginFailure(key, now, resetAfter)
+}
+
+func (l *loginAttemptRepository) Delete(key string) error {
+	return l.db.DeleteLoginAttempt(key)
+}
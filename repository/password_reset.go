@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+)
+
+type PasswordResetRepository interface {
+	Store(reset model.PasswordReset) error
+	Consume(hash string) (model.PasswordReset, error)
+}
+
+type passwordResetRepository struct {
+	db Storage
+}
+
+func NewPasswordResetRepo(db Storage) *passwordResetRepository {
+	return &passwordResetRepository{db}
+}
+
+func (p *passwordResetRepository) Store(reset model.PasswordReset) error {
+	return p.db.StorePasswordReset(reset)
+}
+
+func (p *passwordResetRepository) Consume(hash string) (model.PasswordReset, error) {
+	return p.db.ConsumePasswordReset(hash)
+}
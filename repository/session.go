@@ -1,46 +1,67 @@
 package repository
 
 import (
-	"a21hc3NpZ25tZW50/db/filebased"
 	"a21hc3NpZ25tZW50/model"
+	"errors"
 	"time"
 )
 
 type SessionRepository interface {
 	AddSessions(session model.Session) error
 	DeleteSession(token string) error
+	DeleteSessionsByEmail(email string) error
 	UpdateSessions(session model.Session) error
 	SessionAvailEmail(email string) (model.Session, error)
 	SessionAvailToken(token string) (model.Session, error)
+	SessionsByEmail(email string) ([]model.Session, error)
+	SessionByRefreshHash(hash string) (session model.Session, reused bool, err error)
+	RotateSession(oldToken, refreshHash string, session model.Session) error
+	TokenValidity(token string) (model.Session, error)
 	TokenExpired(session model.Session) bool
 }
 
 type sessionsRepo struct {
-	filebasedDb *filebased.Data
+	db Storage
 }
 
-func NewSessionsRepo(filebasedDb *filebased.Data) *sessionsRepo {
-	return &sessionsRepo{filebasedDb}
+func NewSessionsRepo(db Storage) *sessionsRepo {
+	return &sessionsRepo{db}
 }
 
 func (u *sessionsRepo) AddSessions(session model.Session) error {
-	return u.filebasedDb.AddSession(session)
+	return u.db.AddSession(session)
 }
 
 func (u *sessionsRepo) DeleteSession(token string) error {
-	return u.filebasedDb.DeleteSession(token)
+	return u.db.DeleteSession(token)
+}
+
+func (u *sessionsRepo) DeleteSessionsByEmail(email string) error {
+	return u.db.DeleteSessionsByEmail(email)
 }
 
 func (u *sessionsRepo) UpdateSessions(session model.Session) error {
-	return u.filebasedDb.UpdateSession(session)
+	return u.db.UpdateSession(session)
 }
 
 func (u *sessionsRepo) SessionAvailEmail(email string) (model.Session, error) {
-	return u.filebasedDb.SessionAvailEmail(email)
+	return u.db.SessionAvailEmail(email)
 }
 
 func (u *sessionsRepo) SessionAvailToken(token string) (model.Session, error) {
-	return u.filebasedDb.SessionAvailToken(token)
+	return u.db.SessionAvailToken(token)
+}
+
+func (u *sessionsRepo) SessionsByEmail(email string) ([]model.Session, error) {
+	return u.db.SessionsByEmail(email)
+}
+
+func (u *sessionsRepo) SessionByRefreshHash(hash string) (model.Session, bool, error) {
+	return u.db.SessionByRefreshHash(hash)
+}
+
+func (u *sessionsRepo) RotateSession(oldToken, refreshHash string, session model.Session) error {
+	return u.db.RotateSession(oldToken, refreshHash, session)
 }
 
 func (u *sessionsRepo) TokenValidity(token string) (model.Session, error) {
@@ -54,7 +75,7 @@ func (u *sessionsRepo) TokenValidity(token string) (model.Session, error) {
 		if err != nil {
 			return model.Session{}, err
 		}
-		return model.Session{}, err
+		return model.Session{}, errors.New("session expired")
 	}
 
 	return session, nil
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"io"
+	"time"
+)
+
+// Storage is what the repositories need from a database. The bbolt file store
+// (filebased.Data), the SQL store (sqldb.Data) and the memory store
+// (memory.Data) all implement it with the same semantics: missing records
+// yield model.ErrRecordNotFound, taken keys model.ErrRecordExists, and lists
+// come back in ID order.
+type Storage interface {
+	StoreTask(task model.Task) (model.Task, error)
+	UpdateTask(id int, task model.Task) error
+	DeleteTask(userID, id int) error
+	GetTaskByID(id int) (*model.Task, error)
+	GetTasksByUser(userID int) ([]model.Task, error)
+	GetTaskListByCategory(userID, categoryID int) ([]model.TaskCategory, error)
+
+	StoreCategory(category model.Category) (model.Category, error)
+	UpdateCategory(id int, category model.Category) error
+	DeleteCategory(id int, mode model.CategoryDeleteMode, reassignTo int) error
+	GetCategoryByID(id int) (*model.Category, error)
+	GetCategories() ([]model.Category, error)
+
+	GetUserByEmail(email string) (model.User, error)
+	GetUserByID(id int) (model.User, error)
+	CreateUser(user model.User) (model.User, error)
+	UpdateUser(user model.User) error
+	DeleteUser(id int) error
+	GetUserTaskCategory() ([]model.UserTaskCategory, error)
+	GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error)
+
+	AddSession(session model.Session) error
+	UpdateSession(session model.Session) error
+	DeleteSession(token string) error
+	DeleteSessionsByEmail(email string) error
+	SessionAvailEmail(email string) (model.Session, error)
+	SessionAvailToken(token string) (model.Session, error)
+	SessionsByEmail(email string) ([]model.Session, error)
+	SessionByRefreshHash(hash string) (session model.Session, reused bool, err error)
+	RotateSession(oldToken, refreshHash string, session model.Session) error
+
+	StoreAccessToken(pat model.PersonalAccessToken) (model.PersonalAccessToken, error)
+	UpdateAccessToken(pat model.PersonalAccessToken) error
+	AccessTokenByHash(hash string) (model.PersonalAccessToken, error)
+	AccessTokensByUser(userID int) ([]model.PersonalAccessToken, error)
+	DeleteAccessToken(userID, id int) error
+
+	LoginAttempt(key string) (model.LoginAttempt, error)
+	RecordLoginFailure(key string, now time.Time, resetAfter time.Duration) (model.LoginAttempt, error)
+	DeleteLoginAttempt(key string) error
+
+	StorePasswordReset(reset model.PasswordReset) error
+	ConsumePasswordReset(hash string) (model.PasswordReset, error)
+
+	ExportUserData(userID int) (model.User, []model.Category, []model.Task, error)
+	ImportUserData(userID int, archive model.Archive, replace bool) (model.ImportResult, error)
+}
+
+// Snapshotter is a Storage that can write a consistent copy of itself. Only
+// the file store can; a SQL database is backed up with its own tools.
+type Snapshotter interface {
+	Backup(w io.Writer, compress bool) error
+	BackupToDir(dir string, keep int, compress bool) (string, error)
+}
+
+// IntegrityChecker is a Storage that can look for damaged records. Only the
+// file store needs to; a SQL database enforces its schema itself.
+type IntegrityChecker interface {
+	CheckIntegrity(quarantine bool) (model.IntegrityReport, error)
+}
@@ -1,59 +1,69 @@
 package repository
 
 import (
-	"a21hc3NpZ25tZW50/db/filebased"
 	"a21hc3NpZ25tZW50/model"
 )
 
 type TaskRepository interface {
-	Store(task *model.Task) error
-	Update(taskID int, task *model.Task) error
-	Delete(id int) error
-	GetByID(id int) (*model.Task, error)
-	GetList() ([]model.Task, error)
-	GetTaskCategory(id int) ([]model.TaskCategory, error)
+	Store(task *model.Task) (model.Task, error)
+	Update(userID, taskID int, task *model.Task) error
+	Delete(userID, id int) error
+	GetByID(userID, id int) (*model.Task, error)
+	GetList(userID int) ([]model.Task, error)
+	GetTaskCategory(userID, id int) ([]model.TaskCategory, error)
 }
 
 type taskRepository struct {
-	filebased *filebased.Data
+	db Storage
 }
 
-func NewTaskRepo(filebasedDb *filebased.Data) *taskRepository {
+func NewTaskRepo(db Storage) *taskRepository {
 	return &taskRepository{
-		filebased: filebasedDb,
+		db: db,
 	}
 }
 
-func (t *taskRepository) Store(task *model.Task) error {
-	if err := t.filebased.StoreTask(*task); err != nil {
-		return err
+func (t *taskRepository) Store(task *model.Task) (model.Task, error) {
+	createdTask, err := t.db.StoreTask(*task)
+	if err != nil {
+		return model.Task{}, err
 	}
 
-	return nil
+	return createdTask, nil
 }
 
-func (t *taskRepository) Update(taskID int, task *model.Task) error {
-	if err := t.filebased.UpdateTask(task.ID, *task); err != nil {
+func (t *taskRepository) Update(userID, taskID int, task *model.Task) error {
+	task.UserID = userID
+	if err := t.db.UpdateTask(taskID, *task); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (t *taskRepository) Delete(id int) error {
-	if err := t.filebased.DeleteTask(id); err != nil {
+func (t *taskRepository) Delete(userID, id int) error {
+	if err := t.db.DeleteTask(userID, id); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (t *taskRepository) GetByID(id int) (*model.Task, error) {
-	return t.filebased.GetTaskByID(id)
+func (t *taskRepository) GetByID(userID, id int) (*model.Task, error) {
+	task, err := t.db.GetTaskByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.UserID != userID {
+		return nil, model.ErrRecordNotFound
+	}
+
+	return task, nil
 }
 
-func (t *taskRepository) GetList() ([]model.Task, error) {
-	tasks, err := t.filebased.GetTasks()
+func (t *taskRepository) GetList(userID int) ([]model.Task, error) {
+	tasks, err := t.db.GetTasksByUser(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -61,8 +71,8 @@ func (t *taskRepository) GetList() ([]model.Task, error) {
 	return tasks, nil
 }
 
-func (t *taskRepository) GetTaskCategory(id int) ([]model.TaskCategory, error) {
-	taskCategories, err := t.filebased.GetTaskListByCategory(id)
+func (t *taskRepository) GetTaskCategory(userID, id int) ([]model.TaskCategory, error) {
+	taskCategories, err := t.db.GetTaskListByCategory(userID, id)
 	if err != nil {
 		return nil, err
 	}
@@ -1,26 +1,29 @@
 package repository
 
 import (
-	"a21hc3NpZ25tZW50/db/filebased"
 	"a21hc3NpZ25tZW50/model"
 )
 
 type UserRepository interface {
 	GetUserByEmail(email string) (model.User, error)
+	GetUserByID(id int) (model.User, error)
 	CreateUser(user model.User) (model.User, error)
+	UpdateUser(user model.User) error
+	DeleteUser(id int) error
 	GetUserTaskCategory() ([]model.UserTaskCategory, error)
+	GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error)
 }
 
 type userRepository struct {
-	filebasedDb *filebased.Data
+	db Storage
 }
 
-func NewUserRepo(filebasedDb *filebased.Data) *userRepository {
-	return &userRepository{filebasedDb}
+func NewUserRepo(db Storage) *userRepository {
+	return &userRepository{db}
 }
 
 func (ur *userRepository) GetUserByEmail(email string) (model.User, error) {
-	user, err := ur.filebasedDb.GetUserByEmail(email)
+	user, err := ur.db.GetUserByEmail(email)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -28,8 +31,12 @@ func (ur *userRepository) GetUserByEmail(email string) (model.User, error) {
 	return user, nil
 }
 
+func (ur *userRepository) GetUserByID(id int) (model.User, error) {
+	return ur.db.GetUserByID(id)
+}
+
 func (ur *userRepository) CreateUser(user model.User) (model.User, error) {
-	createdUser, err := ur.filebasedDb.CreateUser(user)
+	createdUser, err := ur.db.CreateUser(user)
 	if err != nil {
 		return model.User{}, err
 	}
@@ -37,8 +44,25 @@ func (ur *userRepository) CreateUser(user model.User) (model.User, error) {
 	return createdUser, nil
 }
 
+func (ur *userRepository) UpdateUser(user model.User) error {
+	return ur.db.UpdateUser(user)
+}
+
+func (ur *userRepository) DeleteUser(id int) error {
+	return ur.db.DeleteUser(id)
+}
+
 func (ur *userRepository) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
-	userTasks, err := ur.filebasedDb.GetUserTaskCategory()
+	userTasks, err := ur.db.GetUserTaskCategory()
+	if err != nil {
+		return nil, err
+	}
+
+	return userTasks, nil
+}
+
+func (ur *userRepository) GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error) {
+	userTasks, err := ur.db.GetUserTaskCategoryByUser(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	repo "a21hc3NpZ25tZW50/repository"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AccessTokenPrefix marks personal access tokens so they can be told apart
+// from session JWTs in an Authorization header.
+const AccessTokenPrefix = "ttp_"
+
+var (
+	ErrInvalidAccessToken = errors.New("invalid or expired access token")
+	ErrInvalidScope       = errors.New("scope must be \"read\" or \"read-write\"")
+	ErrExpiryInPast       = errors.New("expires_at must be in the future")
+)
+
+type AccessTokenService interface {
+	Create(userID int, email string, request model.AccessTokenRequest) (model.AccessTokenCreated, error)
+	List(userID int) ([]model.PersonalAccessToken, error)
+	Revoke(userID, id int) error
+	Authenticate(token string) (model.PersonalAccessToken, error)
+}
+
+type accessTokenService struct {
+	accessTokenRepo repo.AccessTokenRepository
+}
+
+func NewAccessTokenService(accessTokenRepo repo.AccessTokenRepository) *accessTokenService {
+	return &accessTokenService{accessTokenRepo}
+}
+
+// Create issues a new token for userID. The plaintext token is only ever
+// returned here.
+func (as *accessTokenService) Create(userID int, email string, request model.AccessTokenRequest) (model.AccessTokenCreated, error) {
+	if request.Scope == "" {
+		request.Scope = model.ScopeReadWrite
+	}
+	if request.Scope != model.ScopeRead && request.Scope != model.ScopeReadWrite {
+		return model.AccessTokenCreated{}, ErrInvalidScope
+	}
+	if !request.ExpiresAt.IsZero() && !request.ExpiresAt.After(time.Now()) {
+		return model.AccessTokenCreated{}, ErrExpiryInPast
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return model.AccessTokenCreated{}, err
+	}
+	token := AccessTokenPrefix + secret
+
+	pat, err := as.accessTokenRepo.Store(model.PersonalAccessToken{
+		UserID:    userID,
+		Email:     email,
+		Name:      request.Name,
+		Scope:     request.Scope,
+		CreatedAt: time.Now(),
+		ExpiresAt: request.ExpiresAt,
+		TokenHash: hashToken(token),
+	})
+	if err != nil {
+		return model.AccessTokenCreated{}, err
+	}
+
+	return model.AccessTokenCreated{Token: token, PersonalAccessToken: pat}, nil
+}
+
+func (as *accessTokenService) List(userID int) ([]model.PersonalAccessToken, error) {
+	return as.accessTokenRepo.GetList(userID)
+}
+
+func (as *accessTokenService) Revoke(userID, id int) error {
+	return as.accessTokenRepo.Delete(userID, id)
+}
+
+// Authenticate resolves a presented token to its record, rejecting unknown
+// and expired tokens.
+func (as *accessTokenService) Authenticate(token string) (model.PersonalAccessToken, error) {
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return model.PersonalAccessToken{}, ErrInvalidAccessToken
+	}
+
+	pat, err := as.accessTokenRepo.GetByHash(hashToken(token))
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return model.PersonalAccessToken{}, ErrInvalidAccessToken
+		}
+		return model.PersonalAccessToken{}, err
+	}
+
+	now := time.Now()
+	if !pat.ExpiresAt.IsZero() && pat.ExpiresAt.Before(now) {
+		return model.PersonalAccessToken{}, ErrInvalidAccessToken
+	}
+
+	if now.Sub(pat.LastUsedAt) >= lastSeenResolution {
+		pat.LastUsedAt = now
+		if err := as.accessTokenRepo.Update(pat); err != nil {
+			return model.PersonalAccessToken{}, err
+		}
+	}
+
+	return pat, nil
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	repo "a21hc3NpZ25tZW50/repository"
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidArchive = errors.New("invalid archive")
+
+// archiveJSON is the file in an export archive that imports are read from.
+// The CSV sheets next to it are for people and spreadsheets.
+const archiveJSON = "archive.json"
+
+type ArchiveService interface {
+	Export(userID int) (model.Archive, error)
+	Import(userID int, archive model.Archive, mode model.ImportMode) (model.ImportResult, error)
+}
+
+type archiveService struct {
+	archiveRepo repo.ArchiveRepository
+}
+
+func NewArchiveService(archiveRepo repo.ArchiveRepository) *archiveService {
+	return &archiveService{archiveRepo}
+}
+
+func (a *archiveService) Export(userID int) (model.Archive, error) {
+	user, categories, tasks, err := a.archiveRepo.Export(userID)
+	if err != nil {
+		return model.Archive{}, err
+	}
+
+	archive := model.Archive{
+		Version:    model.ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Profile:    user.Profile(),
+		Categories: categories,
+		Tasks:      tasks,
+	}
+	if archive.Categories == nil {
+		archive.Categories = []model.Category{}
+	}
+	if archive.Tasks == nil {
+		archive.Tasks = []model.Task{}
+	}
+	return archive, nil
+}
+
+// Import checks archive and adds its data to user userID.
+func (a *archiveService) Import(userID int, archive model.Archive, mode model.ImportMode) (model.ImportResult, error) {
+	if err := validateArchive(archive); err != nil {
+		return model.ImportResult{}, err
+	}
+	return a.archiveRepo.Import(userID, archive, mode == model.ImportReplace)
+}
+
+func validateArchive(archive model.Archive) error {
+	if archive.Version < 1 || archive.Version > model.ArchiveVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, archive.Version)
+	}
+
+	categories := map[int]bool{}
+	for _, category := range archive.Categories {
+		if strings.TrimSpace(category.Name) == "" {
+			return fmt.Errorf("%w: category %d has no name", ErrInvalidArchive, category.ID)
+		}
+		if categories[category.ID] {
+			return fmt.Errorf("%w: category %d appears twice", ErrInvalidArchive, category.ID)
+		}
+		categories[category.ID] = true
+	}
+
+	for _, task := range archive.Tasks {
+		if strings.TrimSpace(task.Title) == "" {
+			return fmt.Errorf("%w: task %d has no title", ErrInvalidArchive, task.ID)
+		}
+		if !categories[task.CategoryID] {
+			return fmt.Errorf("%w: task %d is in category %d, which is not in the archive", ErrInvalidArchive, task.ID, task.CategoryID)
+		}
+	}
+	return nil
+}
+
+// WriteArchive writes archive to w as a zip file holding archive.json,
+// tasks.csv and categories.csv.
+func WriteArchive(w io.Writer, archive model.Archive) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(archiveJSON)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+
+	categoryNames := map[int]string{}
+	categoryRows := [][]string{{"id", "name"}}
+	for _, category := range archive.Categories {
+		categoryNames[category.ID] = category.Name
+		categoryRows = append(categoryRows, []string{strconv.Itoa(category.ID), category.Name})
+	}
+
+	taskRows := [][]string{{"id", "title", "deadline", "priority", "status", "category_id", "category"}}
+	for _, task := range archive.Tasks {
+		taskRows = append(taskRows, []string{
+			strconv.Itoa(task.ID),
+			task.Title,
+			task.Deadline,
+			strconv.Itoa(task.Priority),
+			task.Status,
+			strconv.Itoa(task.CategoryID),
+			categoryNames[task.CategoryID],
+		})
+	}
+
+	for _, sheet := range []struct {
+		name string
+		rows [][]string
+	}{{"tasks.csv", taskRows}, {"categories.csv", categoryRows}} {
+		f, err := zw.Create(sheet.name)
+		if err != nil {
+			return err
+		}
+		if err := csv.NewWriter(f).WriteAll(sheet.rows); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// ReadArchive decodes an archive written by WriteArchive, or its
+// archive.json on its own.
+func ReadArchive(data []byte) (model.Archive, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return model.Archive{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		f, err := zr.Open(archiveJSON)
+		if err != nil {
+			return model.Archive{}, fmt.Errorf("%w: no %s", ErrInvalidArchive, archiveJSON)
+		}
+		defer f.Close()
+
+		if data, err = io.ReadAll(f); err != nil {
+			return model.Archive{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+	}
+
+	var archive model.Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return model.Archive{}, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return archive, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/config"
+	repo "a21hc3NpZ25tZW50/repository"
+	"io"
+	"log"
+	"time"
+)
+
+type BackupService interface {
+	Write(w io.Writer, compress bool) error
+	Schedule(cfg config.BackupConfig) (stop func())
+}
+
+type backupService struct {
+	backupRepo repo.BackupRepository
+}
+
+func NewBackupService(backupRepo repo.BackupRepository) *backupService {
+	return &backupService{backupRepo}
+}
+
+// Write streams a snapshot of the database to w.
+func (b *backupService) Write(w io.Writer, compress bool) error {
+	return b.backupRepo.Write(w, compress)
+}
+
+// Schedule writes a backup to cfg.Dir every cfg.Interval until stop is
+// called. A failed backup is logged and retried at the next interval.
+func (b *backupService) Schedule(cfg config.BackupConfig) func() {
+	ticker := time.NewTicker(cfg.Interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				path, err := b.backupRepo.WriteToDir(cfg.Dir, cfg.Keep, cfg.Compress)
+				if err != nil {
+					log.Printf("Scheduled backup failed: %v", err)
+					continue
+				}
+				log.Printf("Backup written to %s", path)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
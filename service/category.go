@@ -6,9 +6,9 @@ import (
 )
 
 type CategoryService interface {
-	Store(category *model.Category) error
+	Store(category *model.Category) (model.Category, error)
 	Update(id int, category model.Category) error
-	Delete(id int) error
+	Delete(id int, mode model.CategoryDeleteMode, reassignTo int) error
 	GetByID(id int) (*model.Category, error)
 	GetList() ([]model.Category, error)
 }
@@ -21,12 +21,15 @@ func NewCategoryService(categoryRepository repo.CategoryRepository) CategoryServ
 	return &categoryService{categoryRepository}
 }
 
-func (cs *categoryService) Store(category *model.Category) error {
-	if err := cs.categoryRepository.Store(category); err != nil {
-		return err
+func (cs *categoryService) Store(category *model.Category) (model.Category, error) {
+	// IDs are the store's to assign, as for tasks.
+	category.ID = 0
+	createdCategory, err := cs.categoryRepository.Store(category)
+	if err != nil {
+		return model.Category{}, err
 	}
 
-	return nil
+	return createdCategory, nil
 }
 
 func (cs *categoryService) Update(id int, category model.Category) error {
@@ -37,8 +40,8 @@ func (cs *categoryService) Update(id int, category model.Category) error {
 	return nil
 }
 
-func (cs *categoryService) Delete(id int) error {
-	if err := cs.categoryRepository.Delete(id); err != nil {
+func (cs *categoryService) Delete(id int, mode model.CategoryDeleteMode, reassignTo int) error {
+	if err := cs.categoryRepository.Delete(id, mode, reassignTo); err != nil {
 		return err
 	}
 
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// normalizeEmail trims and lower-cases email so that each address maps to a
+// single account, and rejects anything that is not a bare address.
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	repo "a21hc3NpZ25tZW50/repository"
+	"log"
+)
+
+type IntegrityService interface {
+	Check() (model.IntegrityReport, error)
+	Quarantine() (model.IntegrityReport, error)
+}
+
+type integrityService struct {
+	integrityRepo repo.IntegrityRepository
+}
+
+func NewIntegrityService(integrityRepo repo.IntegrityRepository) *integrityService {
+	return &integrityService{integrityRepo}
+}
+
+// Check reports the problems in the database without changing anything.
+func (i *integrityService) Check() (model.IntegrityReport, error) {
+	return i.integrityRepo.Check(false)
+}
+
+// Quarantine moves the bad records out of the way and rebuilds the indexes.
+func (i *integrityService) Quarantine() (model.IntegrityReport, error) {
+	report, err := i.integrityRepo.Check(true)
+	if err != nil {
+		return model.IntegrityReport{}, err
+	}
+	if len(report.Problems) > 0 {
+		log.Printf("Integrity check repaired %d problems", len(report.Problems))
+	}
+	return report, nil
+}
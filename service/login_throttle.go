@@ -0,0 +1,92 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginPolicy limits password guessing. Once Threshold failures have been
+// counted, further attempts are refused for BaseLockout, doubling with every
+// additional failure up to MaxLockout. Counters start over after ResetAfter
+// without failures.
+type loginPolicy struct {
+	Threshold   int
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	ResetAfter  time.Duration
+}
+
+var (
+	accountLoginPolicy = loginPolicy{
+		Threshold:   5,
+		BaseLockout: 30 * time.Second,
+		MaxLockout:  15 * time.Minute,
+		ResetAfter:  time.Hour,
+	}
+
+	// A client IP may be shared by many users, so it gets more headroom.
+	ipLoginPolicy = loginPolicy{
+		Threshold:   20,
+		BaseLockout: 30 * time.Second,
+		MaxLockout:  15 * time.Minute,
+		ResetAfter:  time.Hour,
+	}
+)
+
+// lockedUntil returns the end of the lockout caused by attempt, or the zero
+// time if it does not lock.
+func (p loginPolicy) lockedUntil(attempt model.LoginAttempt) time.Time {
+	if attempt.Failures < p.Threshold {
+		return time.Time{}
+	}
+
+	lockout := p.BaseLockout
+	for i := p.Threshold; i < attempt.Failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
+	}
+	return attempt.LastFailureAt.Add(lockout)
+}
+
+// LoginLockedError is returned by Login while the account or the client IP is
+// locked out. It is returned whether or not the account exists.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// userAttemptKey counts wrong codes given to change the two-factor settings
+// of a signed-in user.
+func userAttemptKey(id int) string {
+	return "user:" + strconv.Itoa(id)
+}
+
+var (
+	dummyPasswordHash     string
+	dummyPasswordHashOnce sync.Once
+)
+
+// burnPasswordCheck spends the time of a real password verification so that
+// logins for unknown emails cannot be told apart by their response time.
+func burnPasswordCheck(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = hashPassword("dummy password")
+	})
+	verifyPassword(password, dummyPasswordHash)
+}
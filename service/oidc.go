@@ -0,0 +1,109 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"a21hc3NpZ25tZW50/oidc"
+	"errors"
+	"sync"
+	"time"
+)
+
+// OIDCService logs users in through an OpenID Connect provider.
+type OIDCService interface {
+	Begin() (authURL, state string, err error)
+	Complete(state, code, userAgent, ip string) (model.TokenPair, error)
+}
+
+var (
+	ErrInvalidSSOState    = errors.New("invalid or expired single sign-on request")
+	ErrSSOEmailUnverified = errors.New("the identity provider has not verified this email address")
+)
+
+// oidcFlowTTL is how long a user has to log in at the provider.
+const oidcFlowTTL = 10 * time.Minute
+
+// oidcFlow is a login waiting for the provider to redirect back. The PKCE
+// verifier and the nonce never leave the server.
+type oidcFlow struct {
+	nonce        string
+	codeVerifier string
+	expiresAt    time.Time
+}
+
+type oidcService struct {
+	provider    *oidc.Provider
+	userService UserService
+
+	// Flows in progress are kept in memory only; a restart merely makes
+	// users start their login again.
+	mu    sync.Mutex
+	flows map[string]oidcFlow
+}
+
+func NewOIDCService(provider *oidc.Provider, userService UserService) OIDCService {
+	return &oidcService{
+		provider:    provider,
+		userService: userService,
+		flows:       map[string]oidcFlow{},
+	}
+}
+
+// Begin starts a login and returns the provider URL to send the browser to,
+// together with the state it will come back with.
+func (o *oidcService) Begin() (string, string, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := o.provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	for key, flow := range o.flows {
+		if now.After(flow.expiresAt) {
+			delete(o.flows, key)
+		}
+	}
+	o.flows[state] = oidcFlow{nonce: nonce, codeVerifier: verifier, expiresAt: now.Add(oidcFlowTTL)}
+	o.mu.Unlock()
+
+	return authURL, state, nil
+}
+
+// Complete redeems the code the provider redirected back with and starts a
+// session for the user it identifies. Each state can be completed once.
+func (o *oidcService) Complete(state, code, userAgent, ip string) (model.TokenPair, error) {
+	o.mu.Lock()
+	flow, ok := o.flows[state]
+	delete(o.flows, state)
+	o.mu.Unlock()
+
+	if !ok || time.Now().After(flow.expiresAt) {
+		return model.TokenPair{}, ErrInvalidSSOState
+	}
+
+	identity, err := o.provider.Exchange(code, flow.codeVerifier, flow.nonce)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	// Accounts are matched by email, so an address the provider has not
+	// checked could take over someone else's account.
+	if identity.Email == "" || !identity.EmailVerified {
+		return model.TokenPair{}, ErrSSOEmailUnverified
+	}
+
+	return o.userService.LoginSSO(identity.Subject, identity.Email, identity.Name, userAgent, ip)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// passwordParams are the argon2id settings a hash was produced with. They are
+// encoded into the stored hash so that old hashes keep verifying after the
+// defaults change.
+type passwordParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var defaultPasswordParams = passwordParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// hashPassword returns an encoded argon2id hash in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func hashPassword(password string) (string, error) {
+	p := defaultPasswordParams
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// verifyPassword compares password against a stored value in constant time.
+// Values that are not argon2id hashes are treated as legacy plaintext rows.
+// needsRehash is set when the stored value is plaintext or was hashed with
+// parameters other than the current defaults.
+func verifyPassword(password, stored string) (match bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(stored, argon2idPrefix) {
+		match = subtle.ConstantTimeCompare([]byte(password), []byte(stored)) == 1
+		return match, true, nil
+	}
+
+	p, salt, key, err := decodePasswordHash(stored)
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, p != defaultPasswordParams, nil
+}
+
+func decodePasswordHash(encoded string) (passwordParams, []byte, []byte, error) {
+	var p passwordParams
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	p.SaltLength = uint32(len(salt))
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, errInvalidPasswordHash
+	}
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/config"
+	"a21hc3NpZ25tZW50/mailer"
+	"a21hc3NpZ25tZW50/model"
+	repo "a21hc3NpZ25tZW50/repository"
+	"errors"
+	"log"
+	"time"
+)
+
+const passwordResetTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+type PasswordResetService interface {
+	RequestReset(email string) error
+	Reset(token, password string) error
+}
+
+type passwordResetService struct {
+	userRepo          repo.UserRepository
+	passwordResetRepo repo.PasswordResetRepository
+	sessionsRepo      repo.SessionRepository
+	loginAttemptRepo  repo.LoginAttemptRepository
+	mailer            mailer.Mailer
+}
+
+func NewPasswordResetService(userRepo repo.UserRepository, passwordResetRepo repo.PasswordResetRepository, sessionsRepo repo.SessionRepository, loginAttemptRepo repo.LoginAttemptRepository, mailer mailer.Mailer) *passwordResetService {
+	return &passwordResetService{userRepo, passwordResetRepo, sessionsRepo, loginAttemptRepo, mailer}
+}
+
+// RequestReset mails a single-use reset link to email if it belongs to a
+// user. It reports success either way so that it cannot be used to find out
+// which emails are registered. The lookup and the mail happen in the
+// background, so the response takes as long for a registered email as for an
+// unknown one.
+func (ps *passwordResetService) RequestReset(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	go func() {
+		if err := ps.sendReset(email); err != nil {
+			log.Println("Error sending password reset mail:", err)
+		}
+	}()
+	return nil
+}
+
+// sendReset stores a new reset for the user with email, if there is one, and
+// mails them the link.
+func (ps *passwordResetService) sendReset(email string) error {
+	user, err := ps.userRepo.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.ID == 0 {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = ps.passwordResetRepo.Store(model.PasswordReset{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ps.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Task Tracker Plus password",
+		Body: "Hi " + user.Fullname + ",\n\n" +
+			"Someone asked to reset the password of your Task Tracker Plus account.\n" +
+			"Open the link below within an hour to choose a new one:\n\n" +
+			config.SetUrl("/client/password/reset?token="+token) + "\n\n" +
+			"If this wasn't you, you can ignore this email.\n",
+	})
+}
+
+// Reset redeems a reset token and sets the user's new password. All of the
+// user's sessions are revoked, any login lockout is lifted and the email
+// counts as verified.
+func (ps *passwordResetService) Reset(token, password string) error {
+	reset, err := ps.passwordResetRepo.Consume(hashToken(token))
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	user, err := ps.userRepo.GetUserByID(reset.UserID)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	// Redeeming a mailed token proves the address as well.
+	user.PendingVerification = false
+	user.UpdatedAt = time.Now()
+	if err := ps.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	if err := ps.sessionsRepo.DeleteSessionsByEmail(user.Email); err != nil {
+		return err
+	}
+	return ps.loginAttemptRepo.Delete(accountAttemptKey(user.Email))
+}
@@ -3,10 +3,20 @@ package service
 import (
 	"a21hc3NpZ25tZW50/model"
 	repo "a21hc3NpZ25tZW50/repository"
+	"time"
 )
 
+// lastSeenResolution bounds how often a session's LastSeenAt is written back,
+// so that authenticated requests do not each cost a database write.
+const lastSeenResolution = time.Minute
+
 type SessionService interface {
 	GetSessionByEmail(email string) (model.Session, error)
+	ValidateToken(token string) (model.Session, error)
+	ListSessions(email, currentToken string) ([]model.SessionInfo, error)
+	Revoke(token string) error
+	RevokeByID(email string, id int) error
+	RevokeAll(email string) error
 }
 
 type sessionService struct {
@@ -25,3 +35,72 @@ func (s *sessionService) GetSessionByEmail(email string) (model.Session, error)
 
 	return session, nil
 }
+
+// ValidateToken returns the live session stored for token and records it as
+// seen. Expired rows are deleted and reported as an error.
+func (s *sessionService) ValidateToken(token string) (model.Session, error) {
+	session, err := s.sessionRepo.TokenValidity(token)
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) >= lastSeenResolution {
+		session.LastSeenAt = now
+		if err := s.sessionRepo.UpdateSessions(session); err != nil {
+			return model.Session{}, err
+		}
+	}
+
+	return session, nil
+}
+
+func (s *sessionService) ListSessions(email, currentToken string) ([]model.SessionInfo, error) {
+	sessions, err := s.sessionRepo.SessionsByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]model.SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		if s.sessionRepo.TokenExpired(session) {
+			continue
+		}
+		infos = append(infos, model.SessionInfo{
+			ID:         session.ID,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Expiry:     session.Expiry,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.Token == currentToken,
+		})
+	}
+
+	return infos, nil
+}
+
+func (s *sessionService) Revoke(token string) error {
+	return s.sessionRepo.DeleteSession(token)
+}
+
+// RevokeByID deletes one of email's sessions. Sessions of other users are
+// reported as model.ErrRecordNotFound.
+func (s *sessionService) RevokeByID(email string, id int) error {
+	sessions, err := s.sessionRepo.SessionsByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == id {
+			return s.sessionRepo.DeleteSession(session.Token)
+		}
+	}
+
+	return model.ErrRecordNotFound
+}
+
+func (s *sessionService) RevokeAll(email string) error {
+	return s.sessionRepo.DeleteSessionsByEmail(email)
+}
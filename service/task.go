@@ -6,12 +6,12 @@ import (
 )
 
 type TaskService interface {
-	Store(task *model.Task) error
-	Update(id int, task *model.Task) error
-	Delete(id int) error
-	GetByID(id int) (*model.Task, error)
-	GetList() ([]model.Task, error)
-	GetTaskCategory(id int) ([]model.TaskCategory, error)
+	Store(userID int, task *model.Task) (model.Task, error)
+	Update(userID, id int, task *model.Task) error
+	Delete(userID, id int) error
+	GetByID(userID, id int) (*model.Task, error)
+	GetList(userID int) ([]model.Task, error)
+	GetTaskCategory(userID, id int) ([]model.TaskCategory, error)
 }
 
 type taskService struct {
@@ -22,32 +22,38 @@ func NewTaskService(taskRepository repo.TaskRepository) TaskService {
 	return &taskService{taskRepository}
 }
 
-func (ts *taskService) Store(task *model.Task) error {
-	if err := ts.taskRepository.Store(task); err != nil {
-		return err
+func (ts *taskService) Store(userID int, task *model.Task) (model.Task, error) {
+	// IDs are the store's to assign; one picked by the client could probe
+	// for the tasks of other users or push the sequence out of range.
+	task.ID = 0
+	task.UserID = userID
+
+	createdTask, err := ts.taskRepository.Store(task)
+	if err != nil {
+		return model.Task{}, err
 	}
 
-	return nil
+	return createdTask, nil
 }
 
-func (ts *taskService) Update(id int, task *model.Task) error {
-	if err := ts.taskRepository.Update(id, task); err != nil {
+func (ts *taskService) Update(userID, id int, task *model.Task) error {
+	if err := ts.taskRepository.Update(userID, id, task); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (ts *taskService) Delete(id int) error {
-	if err := ts.taskRepository.Delete(id); err != nil {
+func (ts *taskService) Delete(userID, id int) error {
+	if err := ts.taskRepository.Delete(userID, id); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (ts *taskService) GetByID(id int) (*model.Task, error) {
-	task, err := ts.taskRepository.GetByID(id)
+func (ts *taskService) GetByID(userID, id int) (*model.Task, error) {
+	task, err := ts.taskRepository.GetByID(userID, id)
 	if err != nil {
 		return nil, err
 	}
@@ -55,8 +61,8 @@ func (ts *taskService) GetByID(id int) (*model.Task, error) {
 	return task, nil
 }
 
-func (ts *taskService) GetList() ([]model.Task, error) {
-	tasks, err := ts.taskRepository.GetList()
+func (ts *taskService) GetList(userID int) ([]model.Task, error) {
+	tasks, err := ts.taskRepository.GetList(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -64,8 +70,8 @@ func (ts *taskService) GetList() ([]model.Task, error) {
 	return tasks, nil
 }
 
-func (ts *taskService) GetTaskCategory(id int) ([]model.TaskCategory, error) {
-	task, err := ts.taskRepository.GetTaskCategory(id)
+func (ts *taskService) GetTaskCategory(userID, id int) ([]model.TaskCategory, error) {
+	task, err := ts.taskRepository.GetTaskCategory(userID, id)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/config"
+	"a21hc3NpZ25tZW50/model"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// TokenService is the single place session JWTs are signed and verified.
+type TokenService interface {
+	Issue(claims *model.Claims) (tokenString string, expiresAt time.Time, err error)
+	Parse(tokenString string) (*model.Claims, error)
+	IssueRefreshToken() (token, hash string, expiresAt time.Time, err error)
+	HashRefreshToken(token string) string
+	IssueEmailVerification(userID int, email string) (string, error)
+	ParseEmailVerification(tokenString string) (*model.Claims, error)
+	IssueLoginChallenge(userID int, email string) (string, error)
+	ParseLoginChallenge(tokenString string) (*model.Claims, error)
+}
+
+// Email verification links and two-factor login challenges carry JWTs signed
+// with the same keys as access tokens. The audience keeps the kinds from being
+// mistaken for each other.
+const (
+	emailVerificationAudience = "email-verification"
+	emailVerificationTTL      = 24 * time.Hour
+
+	loginChallengeAudience = "login-challenge"
+	loginChallengeTTL      = 5 * time.Minute
+)
+
+type tokenService struct {
+	keys        map[string][]byte
+	activeKeyID string
+	ttl         time.Duration
+	refreshTTL  time.Duration
+}
+
+func NewTokenService(cfg config.JWTConfig) TokenService {
+	return &tokenService{
+		keys:        cfg.Keys,
+		activeKeyID: cfg.ActiveKeyID,
+		ttl:         cfg.TokenTTL,
+		refreshTTL:  cfg.RefreshTTL,
+	}
+}
+
+// Issue signs claims with the active key, stamping its kid into the header
+// and setting the expiry from the configured token lifetime.
+func (ts *tokenService) Issue(claims *model.Claims) (string, time.Time, error) {
+	return ts.sign(claims, ts.ttl)
+}
+
+// IssueEmailVerification returns a token proving that whoever holds it
+// received mail at email.
+func (ts *tokenService) IssueEmailVerification(userID int, email string) (string, error) {
+	return ts.issueFor(emailVerificationAudience, emailVerificationTTL, userID, email)
+}
+
+// IssueLoginChallenge returns a token proving that the user passed the
+// password step of a login and may now present a second factor.
+func (ts *tokenService) IssueLoginChallenge(userID int, email string) (string, error) {
+	return ts.issueFor(loginChallengeAudience, loginChallengeTTL, userID, email)
+}
+
+func (ts *tokenService) issueFor(audience string, ttl time.Duration, userID int, email string) (string, error) {
+	claims := &model.Claims{UserID: userID, Email: email}
+	claims.Audience = audience
+
+	token, _, err := ts.sign(claims, ttl)
+	return token, err
+}
+
+func (ts *tokenService) sign(claims *model.Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = expiresAt.Unix()
+
+	// A random jti keeps two tokens issued in the same second distinct, so
+	// each maps to its own server-side session.
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims.Id = jti
+
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	t.Header["kid"] = ts.activeKeyID
+
+	tokenString, err := t.SignedString(ts.keys[ts.activeKeyID])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// IssueRefreshToken returns a new opaque refresh token together with the hash
+// that is stored in its place.
+func (ts *tokenService) IssueRefreshToken() (string, string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	return token, ts.HashRefreshToken(token), time.Now().Add(ts.refreshTTL), nil
+}
+
+func (ts *tokenService) HashRefreshToken(token string) string {
+	return hashToken(token)
+}
+
+// hashToken returns the hex SHA-256 of an opaque token. Refresh, access and
+// reset tokens are stored only in this form.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded as URL-safe base64.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Parse verifies tokenString against the key named by its kid header. Any
+// configured key is accepted, which lets a retired key keep verifying while
+// the tokens it signed run out.
+func (ts *tokenService) Parse(tokenString string) (*model.Claims, error) {
+	claims, err := ts.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Audience != "" {
+		return nil, errors.New("not an access token")
+	}
+
+	return claims, nil
+}
+
+func (ts *tokenService) ParseEmailVerification(tokenString string) (*model.Claims, error) {
+	return ts.parseFor(emailVerificationAudience, tokenString)
+}
+
+func (ts *tokenService) ParseLoginChallenge(tokenString string) (*model.Claims, error) {
+	return ts.parseFor(loginChallengeAudience, tokenString)
+}
+
+func (ts *tokenService) parseFor(audience, tokenString string) (*model.Claims, error) {
+	claims, err := ts.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Audience != audience {
+		return nil, fmt.Errorf("not a %s token", audience)
+	}
+
+	return claims, nil
+}
+
+func (ts *tokenService) parse(tokenString string) (*model.Claims, error) {
+	claims := &model.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, ok := ts.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). They are the defaults every authenticator app
+// assumes, so the otpauth URI names them only for completeness.
+const (
+	totpIssuer     = "Task Tracker Plus"
+	totpSecretSize = 20
+	totpPeriod     = 30
+	totpDigits     = 6
+	// totpSkew is how many periods a code may be early or late, to allow for
+	// clock drift between the server and the user's device.
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random shared secret, base32 encoded.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURI returns the otpauth:// URI that authenticator apps import, usually
+// by scanning it as a QR code.
+func totpURI(secret, account string) string {
+	label := url.PathEscape(totpIssuer) + ":" + url.PathEscape(account)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes the HOTP value (RFC 4226) of key for counter step.
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// validateTOTP checks code against secret at now and returns the step it
+// matched. Steps up to lastStep are refused so that a code cannot be replayed
+// once it has been accepted.
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns a fresh set of one-time recovery codes, formatted
+// as "xxxxx-xxxxx", together with the hashes that are stored in their place.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeSecondFactorCode drops the spaces and dashes people type or paste
+// along with a code.
+func normalizeSecondFactorCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code for user. On success user is updated so the code cannot be used again;
+// the caller must store it.
+func checkSecondFactor(user *model.User, code string, now time.Time) bool {
+	code = normalizeSecondFactorCode(code)
+
+	if step, ok := validateTOTP(user.TOTPSecret, code, now, user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+		return true
+	}
+
+	hash := hashToken(code)
+	for i, stored := range user.RecoveryCodeHashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
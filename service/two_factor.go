@@ -0,0 +1,161 @@
+package service
+
+import (
+	"a21hc3NpZ25tZW50/model"
+	repo "a21hc3NpZ25tZW50/repository"
+	"errors"
+	"time"
+)
+
+// TwoFactorService turns TOTP two-factor login on and off for a user. Logging
+// in with a second factor is handled by UserService.
+type TwoFactorService interface {
+	Enroll(userID int) (model.TwoFactorEnrollment, error)
+	Confirm(userID int, code string) (recoveryCodes []string, err error)
+	Disable(userID int, code string) error
+}
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor login is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor login is not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment has not been started")
+)
+
+type twoFactorService struct {
+	userRepo         repo.UserRepository
+	loginAttemptRepo repo.LoginAttemptRepository
+}
+
+func NewTwoFactorService(userRepo repo.UserRepository, loginAttemptRepo repo.LoginAttemptRepository) TwoFactorService {
+	return &twoFactorService{userRepo, loginAttemptRepo}
+}
+
+// Enroll generates a new TOTP secret for the user. It is not used for logins
+// until Confirm has seen a code generated from it. Enrolling again before
+// confirming replaces the secret.
+func (ts *twoFactorService) Enroll(userID int) (model.TwoFactorEnrollment, error) {
+	user, err := ts.userRepo.GetUserByID(userID)
+	if err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+	if user.TOTPEnabled {
+		return model.TwoFactorEnrollment{}, ErrTwoFactorEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	user.UpdatedAt = time.Now()
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return model.TwoFactorEnrollment{}, err
+	}
+
+	uri := totpURI(secret, user.Email)
+	return model.TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: uri,
+		QRPayload:  uri,
+	}, nil
+}
+
+// Confirm turns two-factor login on once code shows that the user's
+// authenticator app has the enrolled secret. It returns the recovery codes,
+// which are not stored in readable form and cannot be shown again. Wrong
+// codes are throttled like failed logins.
+func (ts *twoFactorService) Confirm(userID int, code string) ([]string, error) {
+	user, err := ts.userRepo.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	now := time.Now()
+	if err := ts.checkLock(now, userID); err != nil {
+		return nil, err
+	}
+	step, ok := validateTOTP(user.TOTPSecret, normalizeSecondFactorCode(code), now, user.TOTPLastStep)
+	if !ok {
+		return nil, ts.recordFailure(now, userID)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodeHashes = hashes
+	user.UpdatedAt = now
+	if err := ts.userRepo.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	if err := ts.loginAttemptRepo.Delete(userAttemptKey(userID)); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns two-factor login off. It takes a current code or a recovery
+// code, so that a stolen session alone cannot remove the second factor, and
+// wrong codes are throttled like failed logins so that it cannot guess one
+// either.
+func (ts *twoFactorService) Disable(userID int, code string) error {
+	user, err := ts.userRepo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	now := time.Now()
+	if err := ts.checkLock(now, userID); err != nil {
+		return err
+	}
+	if !checkSecondFactor(&user, code, now) {
+		return ts.recordFailure(now, userID)
+	}
+	if err := ts.loginAttemptRepo.Delete(userAttemptKey(userID)); err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodeHashes = nil
+	user.UpdatedAt = now
+	return ts.userRepo.UpdateUser(user)
+}
+
+// checkLock returns a *LoginLockedError while the user has entered too many
+// wrong codes to change their two-factor settings.
+func (ts *twoFactorService) checkLock(now time.Time, userID int) error {
+	attempt, err := ts.loginAttemptRepo.Get(userAttemptKey(userID))
+	if err != nil {
+		return err
+	}
+	if until := accountLoginPolicy.lockedUntil(attempt); now.Before(until) {
+		return &LoginLockedError{RetryAfter: until.Sub(now)}
+	}
+	return nil
+}
+
+// recordFailure counts a wrong code against the user and returns
+// ErrInvalidTwoFactor.
+func (ts *twoFactorService) recordFailure(now time.Time, userID int) error {
+	if _, err := ts.loginAttemptRepo.RecordFailure(userAttemptKey(userID), now, accountLoginPolicy.ResetAfter); err != nil {
+		return err
+	}
+	return ErrInvalidTwoFactor
+}
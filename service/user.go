@@ -1,39 +1,90 @@
 package service
 
 import (
+	"a21hc3NpZ25tZW50/config"
+	"a21hc3NpZ25tZW50/mailer"
 	"a21hc3NpZ25tZW50/model"
 	repo "a21hc3NpZ25tZW50/repository"
 	"errors"
+	"log"
+	"strings"
 	"time"
-
-	"github.com/golang-jwt/jwt"
 )
 
 type UserService interface {
 	Register(user *model.User) (model.User, error)
-	Login(user *model.User) (token *string, err error)
+	Login(user *model.User, userAgent, ip string) (model.TokenPair, error)
+	LoginSecondFactor(challenge, code, userAgent, ip string) (model.TokenPair, error)
+	LoginSSO(subject, email, fullname, userAgent, ip string) (model.TokenPair, error)
+	Refresh(refreshToken, userAgent, ip string) (model.TokenPair, error)
 	GetUserTaskCategory() ([]model.UserTaskCategory, error)
+	GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error)
+	GetByID(id int) (model.User, error)
+	UpdateProfile(id int, request model.ProfileUpdateRequest, sessionToken string) (model.User, error)
+	ChangePassword(id int, currentPassword, newPassword, keepSessionToken string) error
+	DeleteAccount(id int, password, sessionToken string) error
+	SetRole(id int, role string) error
+	EnsureAdmin(fullname, email, password string) error
+	Unlock(id int) error
+	VerifyEmail(token string) error
+	ResendVerification(email string) error
 }
 
+var (
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrEmailExists         = errors.New("email already exists")
+	ErrEmailNotVerified    = errors.New("email address is not verified yet")
+	ErrInvalidVerification = errors.New("invalid or expired verification link")
+	ErrInvalidChallenge    = errors.New("invalid or expired login challenge")
+	ErrInvalidTwoFactor    = errors.New("invalid two-factor code")
+	ErrSSOAccountMismatch  = errors.New("this account is linked to a different single sign-on identity")
+	ErrWrongPassword       = errors.New("current password is incorrect")
+	ErrLoginNotFresh       = errors.New("log in again to confirm this change")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrInvalidRole         = errors.New("role must be \"admin\" or \"member\"")
+	ErrAdminPasswordUnset  = errors.New("ADMIN_PASSWORD is required to create the admin account")
+)
+
 type userService struct {
-	userRepo     repo.UserRepository
-	sessionsRepo repo.SessionRepository
+	userRepo         repo.UserRepository
+	sessionsRepo     repo.SessionRepository
+	loginAttemptRepo repo.LoginAttemptRepository
+	accessTokenRepo  repo.AccessTokenRepository
+	tokenService     TokenService
+	mailer           mailer.Mailer
 }
 
-func NewUserService(userRepository repo.UserRepository, sessionsRepo repo.SessionRepository) UserService {
-	return &userService{userRepository, sessionsRepo}
+func NewUserService(userRepository repo.UserRepository, sessionsRepo repo.SessionRepository, loginAttemptRepo repo.LoginAttemptRepository, accessTokenRepo repo.AccessTokenRepository, tokenService TokenService, mailer mailer.Mailer) UserService {
+	return &userService{userRepository, sessionsRepo, loginAttemptRepo, accessTokenRepo, tokenService, mailer}
 }
 
+// Register creates an unverified member account and mails it a verification
+// link. The email is normalized first so that each address has one account.
 func (us *userService) Register(user *model.User) (model.User, error) {
+	email, err := normalizeEmail(user.Email)
+	if err != nil {
+		return *user, err
+	}
+	user.Email = email
+
 	dbUser, err := us.userRepo.GetUserByEmail(user.Email)
 	if err != nil {
 		return *user, err
 	}
 
 	if dbUser.Email != "" || dbUser.ID != 0 {
-		return *user, errors.New("email already exists")
+		return *user, ErrEmailExists
 	}
 
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		return *user, err
+	}
+	user.Password = hashedPassword
+
+	user.Role = model.RoleMember
+	user.PendingVerification = true
 	user.CreatedAt = time.Now()
 
 	newUser, err := us.userRepo.CreateUser(*user)
@@ -41,51 +92,327 @@ func (us *userService) Register(user *model.User) (model.User, error) {
 		return *user, err
 	}
 
+	if err := us.sendVerification(newUser); err != nil {
+		// The account exists either way; the user can ask for a new link.
+		log.Println("Error sending verification mail:", err)
+	}
+
 	return newUser, nil
 }
 
-func (us *userService) Login(user *model.User) (token *string, err error) {
+// TwoFactorRequiredError is returned by Login when the password is correct but
+// the account has two-factor login turned on. The session is only started by
+// LoginSecondFactor, given Challenge and a valid code.
+type TwoFactorRequiredError struct {
+	Challenge string
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor code required"
+}
+
+// Login checks the user's credentials and starts a new session. Failed
+// attempts are counted per account and per client IP; both lock out for a
+// while once they pile up. Unknown emails and wrong passwords fail with the
+// same ErrInvalidCredentials.
+func (us *userService) Login(user *model.User, userAgent, ip string) (model.TokenPair, error) {
+	if email, err := normalizeEmail(user.Email); err == nil {
+		user.Email = email
+	}
+
+	now := time.Now()
+	if err := us.checkLoginLock(now, user.Email, ip); err != nil {
+		return model.TokenPair{}, err
+	}
+
 	dbUser, err := us.userRepo.GetUserByEmail(user.Email)
 	if err != nil {
-		return nil, err
+		return model.TokenPair{}, err
+	}
+
+	// Accounts created by single sign-on have no password to log in with.
+	if dbUser.Email == "" || dbUser.ID == 0 || dbUser.Password == "" {
+		burnPasswordCheck(user.Password)
+		if err := us.recordLoginFailure(now, user.Email, ip); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrInvalidCredentials
+	}
+
+	match, needsRehash, err := verifyPassword(user.Password, dbUser.Password)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	if !match {
+		if err := us.recordLoginFailure(now, user.Email, ip); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		hashedPassword, err := hashPassword(user.Password)
+		if err != nil {
+			return model.TokenPair{}, err
+		}
+		dbUser.Password = hashedPassword
+		dbUser.UpdatedAt = time.Now()
+		if err := us.userRepo.UpdateUser(dbUser); err != nil {
+			return model.TokenPair{}, err
+		}
+	}
+
+	// The failure counter is left alone until the second factor passes as
+	// well, so that knowing the password does not buy unlimited code guesses.
+	// An unverified address is refused first, or two-factor accounts could
+	// skip verifying a changed email.
+	if dbUser.TOTPEnabled {
+		if dbUser.PendingVerification {
+			return model.TokenPair{}, ErrEmailNotVerified
+		}
+		challenge, err := us.tokenService.IssueLoginChallenge(dbUser.ID, dbUser.Email)
+		if err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, &TwoFactorRequiredError{Challenge: challenge}
+	}
+
+	if err := us.loginAttemptRepo.Delete(accountAttemptKey(user.Email)); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	if dbUser.PendingVerification {
+		return model.TokenPair{}, ErrEmailNotVerified
+	}
+
+	return us.startSession(dbUser, userAgent, ip)
+}
+
+// LoginSecondFactor finishes a login that Login answered with a
+// *TwoFactorRequiredError. code is either the current TOTP code or one of the
+// user's unused recovery codes. Wrong codes count as failed logins.
+func (us *userService) LoginSecondFactor(challenge, code, userAgent, ip string) (model.TokenPair, error) {
+	claims, err := us.tokenService.ParseLoginChallenge(challenge)
+	if err != nil {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+
+	now := time.Now()
+	if err := us.checkLoginLock(now, claims.Email, ip); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	dbUser, err := us.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return model.TokenPair{}, ErrInvalidChallenge
+		}
+		return model.TokenPair{}, err
+	}
+	if dbUser.Email != claims.Email || !dbUser.TOTPEnabled {
+		return model.TokenPair{}, ErrInvalidChallenge
+	}
+	if dbUser.PendingVerification {
+		return model.TokenPair{}, ErrEmailNotVerified
+	}
+
+	if !checkSecondFactor(&dbUser, code, now) {
+		if err := us.recordLoginFailure(now, dbUser.Email, ip); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrInvalidTwoFactor
+	}
+
+	dbUser.UpdatedAt = now
+	if err := us.userRepo.UpdateUser(dbUser); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	if err := us.loginAttemptRepo.Delete(accountAttemptKey(dbUser.Email)); err != nil {
+		return model.TokenPair{}, err
 	}
 
-	if dbUser.Email == "" || dbUser.ID == 0 {
-		return nil, errors.New("user not found")
+	return us.startSession(dbUser, userAgent, ip)
+}
+
+// LoginSSO starts a session for a user the identity provider has
+// authenticated with a verified email. The account with that email is linked
+// to the provider's subject on first use, or created as a member if there is
+// none. An account with two-factor login still has to pass it, so the
+// provider alone gets a TwoFactorRequiredError carrying the challenge instead
+// of a session.
+func (us *userService) LoginSSO(subject, email, fullname, userAgent, ip string) (model.TokenPair, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return model.TokenPair{}, err
 	}
 
-	if user.Password != dbUser.Password {
-		return nil, errors.New("wrong email or password")
+	dbUser, err := us.userRepo.GetUserByEmail(email)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	now := time.Now()
+	if dbUser.ID == 0 {
+		if fullname == "" {
+			fullname = email
+		}
+		dbUser, err = us.userRepo.CreateUser(model.User{
+			Fullname:    fullname,
+			Email:       email,
+			Role:        model.RoleMember,
+			OIDCSubject: subject,
+			CreatedAt:   now,
+		})
+		if err != nil {
+			return model.TokenPair{}, err
+		}
+	} else {
+		if dbUser.OIDCSubject != "" && dbUser.OIDCSubject != subject {
+			return model.TokenPair{}, ErrSSOAccountMismatch
+		}
+		if dbUser.OIDCSubject == "" || dbUser.PendingVerification {
+			// The provider vouches for the address, which is as good as
+			// following our own verification link.
+			dbUser.OIDCSubject = subject
+			dbUser.PendingVerification = false
+			dbUser.UpdatedAt = now
+			if err := us.userRepo.UpdateUser(dbUser); err != nil {
+				return model.TokenPair{}, err
+			}
+		}
+
+		if dbUser.TOTPEnabled {
+			challenge, err := us.tokenService.IssueLoginChallenge(dbUser.ID, dbUser.Email)
+			if err != nil {
+				return model.TokenPair{}, err
+			}
+			return model.TokenPair{}, &TwoFactorRequiredError{Challenge: challenge}
+		}
 	}
 
-	expirationTime := time.Now().Add(20 * time.Minute)
+	return us.startSession(dbUser, userAgent, ip)
+}
+
+// startSession issues a token pair for dbUser and records the session it
+// belongs to.
+func (us *userService) startSession(dbUser model.User, userAgent, ip string) (model.TokenPair, error) {
 	claims := &model.Claims{
-		Email: dbUser.Email,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
+		UserID: dbUser.ID,
+		Email:  dbUser.Email,
+		Role:   dbUser.EffectiveRole(),
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := t.SignedString(model.JwtKey)
+	accessToken, accessExpiry, err := us.tokenService.Issue(claims)
 	if err != nil {
-		return nil, err
+		return model.TokenPair{}, err
+	}
+
+	refreshToken, refreshHash, refreshExpiry, err := us.tokenService.IssueRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
 	}
 
+	// Every login gets its own session so that signing in on one device
+	// leaves the sessions of the others intact. The session lives as long as
+	// its refresh token; the access token inside it is short-lived.
+	now := time.Now()
 	session := model.Session{
-		Token:  tokenString,
-		Email:  user.Email,
-		Expiry: expirationTime,
+		Token:            accessToken,
+		Email:            dbUser.Email,
+		Expiry:           refreshExpiry,
+		CreatedAt:        now,
+		LastSeenAt:       now,
+		UserAgent:        userAgent,
+		IP:               ip,
+		RefreshTokenHash: refreshHash,
 	}
 
-	_, err = us.sessionsRepo.SessionAvailEmail(session.Email)
+	if err := us.sessionsRepo.AddSessions(session); err != nil {
+		return model.TokenPair{}, err
+	}
+
+	return model.TokenPair{
+		AccessToken:      accessToken,
+		ExpiresAt:        accessExpiry,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiry,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new access/refresh pair. Refresh
+// tokens are single use: presenting one that was already exchanged revokes
+// the whole session it belongs to, since either the client or an attacker is
+// holding a stolen copy.
+func (us *userService) Refresh(refreshToken, userAgent, ip string) (model.TokenPair, error) {
+	refreshHash := us.tokenService.HashRefreshToken(refreshToken)
+
+	session, reused, err := us.sessionsRepo.SessionByRefreshHash(refreshHash)
 	if err != nil {
-		err = us.sessionsRepo.AddSessions(session)
-	} else {
-		err = us.sessionsRepo.UpdateSessions(session)
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return model.TokenPair{}, ErrInvalidRefreshToken
+		}
+		return model.TokenPair{}, err
+	}
+
+	if reused {
+		if err := us.sessionsRepo.DeleteSession(session.Token); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrRefreshTokenReused
+	}
+
+	if us.sessionsRepo.TokenExpired(session) {
+		if err := us.sessionsRepo.DeleteSession(session.Token); err != nil {
+			return model.TokenPair{}, err
+		}
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	dbUser, err := us.userRepo.GetUserByEmail(session.Email)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	if dbUser.ID == 0 {
+		return model.TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	accessToken, accessExpiry, err := us.tokenService.Issue(&model.Claims{
+		UserID: dbUser.ID,
+		Email:  dbUser.Email,
+		Role:   dbUser.EffectiveRole(),
+	})
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	newRefreshToken, newRefreshHash, refreshExpiry, err := us.tokenService.IssueRefreshToken()
+	if err != nil {
+		return model.TokenPair{}, err
 	}
 
-	return &tokenString, nil
+	oldToken := session.Token
+	session.Token = accessToken
+	session.Expiry = refreshExpiry
+	session.LastSeenAt = time.Now()
+	session.UserAgent = userAgent
+	session.IP = ip
+	session.UsedRefreshHashes = append(session.UsedRefreshHashes, refreshHash)
+	session.RefreshTokenHash = newRefreshHash
+
+	if err := us.sessionsRepo.RotateSession(oldToken, refreshHash, session); err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return model.TokenPair{}, ErrInvalidRefreshToken
+		}
+		return model.TokenPair{}, err
+	}
+
+	return model.TokenPair{
+		AccessToken:      accessToken,
+		ExpiresAt:        accessExpiry,
+		RefreshToken:     newRefreshToken,
+		RefreshExpiresAt: refreshExpiry,
+	}, nil
 }
 
 func (us *userService) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
@@ -96,3 +423,352 @@ func (us *userService) GetUserTaskCategory() ([]model.UserTaskCategory, error) {
 
 	return userTasks, nil
 }
+
+func (us *userService) GetUserTaskCategoryByUser(userID int) ([]model.UserTaskCategory, error) {
+	userTasks, err := us.userRepo.GetUserTaskCategoryByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return userTasks, nil
+}
+
+func (us *userService) GetByID(id int) (model.User, error) {
+	return us.userRepo.GetUserByID(id)
+}
+
+// UpdateProfile changes the full name and email of user id. A new email has to
+// be verified like at registration: the user is signed out everywhere and can
+// log in again once the link mailed to the new address has been followed.
+func (us *userService) UpdateProfile(id int, request model.ProfileUpdateRequest, sessionToken string) (model.User, error) {
+	user, err := us.userRepo.GetUserByID(id)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if fullname := strings.TrimSpace(request.Fullname); fullname != "" {
+		user.Fullname = fullname
+	}
+
+	oldEmail := user.Email
+	if request.Email != "" {
+		email, err := normalizeEmail(request.Email)
+		if err != nil {
+			return model.User{}, err
+		}
+
+		if email != user.Email {
+			if err := us.checkPassword(user, request.CurrentPassword, sessionToken); err != nil {
+				return model.User{}, err
+			}
+
+			existing, err := us.userRepo.GetUserByEmail(email)
+			if err != nil {
+				return model.User{}, err
+			}
+			if existing.ID != 0 {
+				return model.User{}, ErrEmailExists
+			}
+
+			user.Email = email
+			user.PendingVerification = true
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+	if err := us.userRepo.UpdateUser(user); err != nil {
+		return model.User{}, err
+	}
+
+	if user.Email != oldEmail {
+		// Sessions are tied to the email, so none of them would work anyway.
+		if err := us.sessionsRepo.DeleteSessionsByEmail(oldEmail); err != nil {
+			return model.User{}, err
+		}
+		// Access tokens carry the email too, but are meant to outlive it.
+		pats, err := us.accessTokenRepo.GetList(user.ID)
+		if err != nil {
+			return model.User{}, err
+		}
+		for _, pat := range pats {
+			pat.Email = user.Email
+			if err := us.accessTokenRepo.Update(pat); err != nil {
+				return model.User{}, err
+			}
+		}
+		if err := us.sendVerification(user); err != nil {
+			log.Println("Error sending verification mail:", err)
+		}
+	}
+
+	return user, nil
+}
+
+// ChangePassword sets a new password for user id after checking the current
+// one. Every session except keepSessionToken, the one making the change, and
+// every personal access token are revoked. Accounts created by single sign-on
+// have no password yet and may set one from a fresh login instead.
+func (us *userService) ChangePassword(id int, currentPassword, newPassword, keepSessionToken string) error {
+	user, err := us.userRepo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := us.checkPassword(user, currentPassword, keepSessionToken); err != nil {
+		return err
+	}
+
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = hashedPassword
+	user.UpdatedAt = time.Now()
+	if err := us.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	sessions, err := us.sessionsRepo.SessionsByEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.Token == keepSessionToken {
+			continue
+		}
+		if err := us.sessionsRepo.DeleteSession(session.Token); err != nil {
+			return err
+		}
+	}
+
+	pats, err := us.accessTokenRepo.GetList(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, pat := range pats {
+		if err := us.accessTokenRepo.Delete(user.ID, pat.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAccount removes user id with its tasks, sessions, access tokens,
+// login attempts and the categories it created.
+func (us *userService) DeleteAccount(id int, password, sessionToken string) error {
+	user, err := us.userRepo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := us.checkPassword(user, password, sessionToken); err != nil {
+		return err
+	}
+
+	return us.userRepo.DeleteUser(id)
+}
+
+// freshLoginWindow is how recently an account without a password must have
+// logged in to make a change that others confirm with their password.
+const freshLoginWindow = 10 * time.Minute
+
+// checkPassword confirms a sensitive change with the user's password.
+// Accounts without one, created by single sign-on, confirm it instead by
+// making the change from sessionToken within freshLoginWindow of logging in,
+// so that a stolen old session cannot take the account over.
+func (us *userService) checkPassword(user model.User, password, sessionToken string) error {
+	if user.Password == "" {
+		session, err := us.sessionsRepo.SessionAvailToken(sessionToken)
+		if err != nil || !strings.EqualFold(session.Email, user.Email) || time.Since(session.CreatedAt) > freshLoginWindow {
+			return ErrLoginNotFresh
+		}
+		return nil
+	}
+
+	match, _, err := verifyPassword(password, user.Password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// SetRole changes the role of user id. The user's sessions are revoked so that
+// no access token carrying the old role stays in use.
+func (us *userService) SetRole(id int, role string) error {
+	if role != model.RoleAdmin && role != model.RoleMember {
+		return ErrInvalidRole
+	}
+
+	user, err := us.userRepo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	user.Role = role
+	user.UpdatedAt = time.Now()
+	if err := us.userRepo.UpdateUser(user); err != nil {
+		return err
+	}
+
+	return us.sessionsRepo.DeleteSessionsByEmail(user.Email)
+}
+
+// EnsureAdmin makes sure the account with the given email exists and is an
+// admin. It is used to bootstrap the first administrator.
+func (us *userService) EnsureAdmin(fullname, email, password string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	dbUser, err := us.userRepo.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if dbUser.ID != 0 {
+		if dbUser.Role == model.RoleAdmin {
+			return nil
+		}
+		return us.SetRole(dbUser.ID, model.RoleAdmin)
+	}
+
+	if password == "" {
+		return ErrAdminPasswordUnset
+	}
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = us.userRepo.CreateUser(model.User{
+		Fullname:  fullname,
+		Email:     email,
+		Password:  hashedPassword,
+		Role:      model.RoleAdmin,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// Unlock clears the failed login and two-factor code counters of user id,
+// lifting a lockout.
+func (us *userService) Unlock(id int) error {
+	user, err := us.userRepo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	if err := us.loginAttemptRepo.Delete(accountAttemptKey(user.Email)); err != nil {
+		return err
+	}
+	return us.loginAttemptRepo.Delete(userAttemptKey(user.ID))
+}
+
+// checkLoginLock returns a *LoginLockedError if the account or the client IP
+// is locked out at now.
+func (us *userService) checkLoginLock(now time.Time, email, ip string) error {
+	attempt, err := us.loginAttemptRepo.Get(accountAttemptKey(email))
+	if err != nil {
+		return err
+	}
+	until := accountLoginPolicy.lockedUntil(attempt)
+
+	if ip != "" {
+		attempt, err := us.loginAttemptRepo.Get(ipAttemptKey(ip))
+		if err != nil {
+			return err
+		}
+		if ipUntil := ipLoginPolicy.lockedUntil(attempt); ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+
+	if now.Before(until) {
+		return &LoginLockedError{RetryAfter: until.Sub(now)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the account and the
+// client IP.
+func (us *userService) recordLoginFailure(now time.Time, email, ip string) error {
+	if _, err := us.loginAttemptRepo.RecordFailure(accountAttemptKey(email), now, accountLoginPolicy.ResetAfter); err != nil {
+		return err
+	}
+	if ip != "" {
+		if _, err := us.loginAttemptRepo.RecordFailure(ipAttemptKey(ip), now, ipLoginPolicy.ResetAfter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyEmail activates the account named by a verification token. The token
+// only counts while the account still has the email it was sent to.
+func (us *userService) VerifyEmail(token string) error {
+	claims, err := us.tokenService.ParseEmailVerification(token)
+	if err != nil {
+		return ErrInvalidVerification
+	}
+
+	user, err := us.userRepo.GetUserByID(claims.UserID)
+	if err != nil {
+		if errors.Is(err, model.ErrRecordNotFound) {
+			return ErrInvalidVerification
+		}
+		return err
+	}
+	if user.Email != claims.Email {
+		return ErrInvalidVerification
+	}
+	if !user.PendingVerification {
+		return nil
+	}
+
+	user.PendingVerification = false
+	user.UpdatedAt = time.Now()
+	return us.userRepo.UpdateUser(user)
+}
+
+// ResendVerification mails a new verification link if email belongs to an
+// unverified account, and silently does nothing otherwise.
+func (us *userService) ResendVerification(email string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	user, err := us.userRepo.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.ID == 0 || !user.PendingVerification {
+		return nil
+	}
+
+	if err := us.sendVerification(user); err != nil {
+		log.Println("Error sending verification mail:", err)
+	}
+	return nil
+}
+
+func (us *userService) sendVerification(user model.User) error {
+	token, err := us.tokenService.IssueEmailVerification(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return us.mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Task Tracker Plus email",
+		Body: "Hi " + user.Fullname + ",\n\n" +
+			"Please confirm your email address by opening the link below within 24 hours:\n\n" +
+			config.SetUrl("/client/verify?token="+token) + "\n\n" +
+			"If you did not sign up for Task Tracker Plus, you can ignore this email.\n",
+	})
+}